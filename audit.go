@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Audited entities, named like their api paths
+const (
+	AuditProduct       = "product"
+	AuditCustomer      = "customer"
+	AuditBill          = "bill"
+	AuditPayment       = "payment"
+	AuditCreditNote    = "credit-note"
+	AuditRefund        = "refund"
+	AuditTaxClass      = "tax-class"
+	AuditExchangeRate  = "exchange-rate"
+	AuditCoupon        = "coupon"
+	AuditSupplier      = "supplier"
+	AuditPurchaseOrder = "purchase-order"
+	AuditCart          = "cart"
+)
+
+// Actions recorded by audit
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// Actors of changes made outside of api requests and by requests without actor
+const (
+	AuditSystemActor    = "system"
+	AuditAnonymousActor = "anonymous"
+)
+
+// auditSnapshots select entity by id as json object. Rows owned by entity are nested into it,
+// so e.g. change of bill's products is recorded as change of bill. Entity's row is locked, so concurrent
+// change cannot slip between snapshots taken before and after the change
+var auditSnapshots = map[string]string{
+	AuditProduct: `SELECT to_jsonb(product) FROM product WHERE product.id = $1 FOR NO KEY UPDATE`,
+	AuditCustomer: `
+	SELECT to_jsonb(customer) || jsonb_build_object('addresses', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(customeraddress) - 'customer_id' ORDER BY customeraddress.id), '[]')
+		FROM customeraddress WHERE customeraddress.customer_id = customer.id
+	))
+	FROM customer WHERE customer.id = $1 FOR NO KEY UPDATE`,
+	AuditBill: `
+	SELECT to_jsonb(bill) || jsonb_build_object('products', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(productbill) - 'bill_id' ORDER BY productbill.product_id), '[]')
+		FROM productbill WHERE productbill.bill_id = bill.id
+	), 'coupon', (
+		SELECT coupon.code FROM couponredemption
+		JOIN coupon ON coupon.id = couponredemption.coupon_id
+		WHERE couponredemption.bill_id = bill.id
+	))
+	FROM bill WHERE bill.id = $1 FOR NO KEY UPDATE`,
+	AuditPayment: `SELECT to_jsonb(payment) FROM payment WHERE payment.id = $1 FOR NO KEY UPDATE`,
+	AuditCreditNote: `
+	SELECT to_jsonb(creditnote) || jsonb_build_object('lines', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(creditnoteline) - 'credit_note_id' ORDER BY creditnoteline.product_id), '[]')
+		FROM creditnoteline WHERE creditnoteline.credit_note_id = creditnote.id
+	))
+	FROM creditnote WHERE creditnote.id = $1 FOR NO KEY UPDATE`,
+	AuditRefund: `SELECT to_jsonb(refund) FROM refund WHERE refund.id = $1 FOR NO KEY UPDATE`,
+	AuditTaxClass: `
+	SELECT to_jsonb(taxclass) || jsonb_build_object('rates', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(taxrate) - 'tax_class_id' ORDER BY taxrate.effective_from), '[]')
+		FROM taxrate WHERE taxrate.tax_class_id = taxclass.id
+	))
+	FROM taxclass WHERE taxclass.id = $1 FOR NO KEY UPDATE`,
+	AuditExchangeRate: `SELECT to_jsonb(exchangerate) FROM exchangerate WHERE exchangerate.id = $1 FOR NO KEY UPDATE`,
+	AuditCoupon:       `SELECT to_jsonb(coupon) FROM coupon WHERE coupon.id = $1 FOR NO KEY UPDATE`,
+	AuditSupplier: `
+	SELECT to_jsonb(supplier) || jsonb_build_object('products', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(supplierproduct) - 'supplier_id' ORDER BY supplierproduct.product_id), '[]')
+		FROM supplierproduct WHERE supplierproduct.supplier_id = supplier.id
+	))
+	FROM supplier WHERE supplier.id = $1 FOR NO KEY UPDATE`,
+	AuditPurchaseOrder: `
+	SELECT to_jsonb(purchaseorder) || jsonb_build_object('lines', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(purchaseorderline) - 'purchase_order_id' ORDER BY purchaseorderline.product_id), '[]')
+		FROM purchaseorderline WHERE purchaseorderline.purchase_order_id = purchaseorder.id
+	))
+	FROM purchaseorder WHERE purchaseorder.id = $1 FOR NO KEY UPDATE`,
+	AuditCart: `
+	SELECT to_jsonb(cart) - 'token' || jsonb_build_object('items', (
+		SELECT COALESCE(jsonb_agg(to_jsonb(cartitem) - 'cart_id' ORDER BY cartitem.product_id), '[]')
+		FROM cartitem WHERE cartitem.cart_id = cart.id
+	))
+	FROM cart WHERE cart.id = $1 FOR NO KEY UPDATE`,
+}
+
+type auditContextKey struct{}
+
+// auditActor is who makes changes and within which request
+type auditActor struct {
+	Actor     string
+	RequestId string
+}
+
+// WithAuditActor returns context which changes are recorded as made by actor within request
+func WithAuditActor(ctx context.Context, actor, requestId string) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, auditActor{Actor: actor, RequestId: requestId})
+}
+
+// auditActorFrom returns actor of context, changes without actor are made by system
+func auditActorFrom(ctx context.Context) auditActor {
+	if actor, ok := ctx.Value(auditContextKey{}).(auditActor); ok {
+		return actor
+	}
+	return auditActor{Actor: AuditSystemActor}
+}
+
+// auditSnapshot returns current state of entity, nil when entity not exists
+func auditSnapshot(ctx context.Context, tx *sqlx.Tx, entity string, id int) (json.RawMessage, error) {
+	var state []byte
+	if err := tx.QueryRowContext(ctx, auditSnapshots[entity], id).Scan(&state); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+// auditDiff keeps only fields which differ between states. Absent state is kept absent, so created entity
+// has only after and deleted one has only before. Both are nil when nothing changed
+func auditDiff(before, after json.RawMessage) (json.RawMessage, json.RawMessage, error) {
+	var beforeFields, afterFields map[string]json.RawMessage
+	if before != nil {
+		if err := json.Unmarshal(before, &beforeFields); err != nil {
+			return nil, nil, err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal(after, &afterFields); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	changedBefore, changedAfter := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	for name, value := range beforeFields {
+		if other, ok := afterFields[name]; !ok || !bytes.Equal(value, other) {
+			changedBefore[name] = value
+		}
+	}
+	for name, value := range afterFields {
+		if other, ok := beforeFields[name]; !ok || !bytes.Equal(value, other) {
+			changedAfter[name] = value
+		}
+	}
+	if len(changedBefore) == 0 && len(changedAfter) == 0 {
+		return nil, nil, nil
+	}
+
+	var err error
+	if before != nil {
+		if before, err = json.Marshal(changedBefore); err != nil {
+			return nil, nil, err
+		}
+	}
+	if after != nil {
+		if after, err = json.Marshal(changedAfter); err != nil {
+			return nil, nil, err
+		}
+	}
+	return before, after, nil
+}
+
+// audit records change of entity within transaction making it. before is entity's state snapshotted prior
+// to the change, nil for created entity. Nothing is recorded when entity didn't change
+func (s *Service) audit(ctx context.Context, tx *sqlx.Tx, entity string, id int, action string, before json.RawMessage) error {
+	after, err := auditSnapshot(ctx, tx, entity, id)
+	if err != nil {
+		return err
+	}
+	if before, after, err = auditDiff(before, after); err != nil {
+		return err
+	}
+	if before == nil && after == nil {
+		return nil
+	}
+
+	actor := auditActorFrom(ctx)
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO audit (actor, request_id, entity, entity_id, action, before, after)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, actor.Actor, actor.RequestId, entity, id, action, auditArg(before), auditArg(after))
+	return err
+}
+
+// auditArg passes state as jsonb, absent state is NULL
+func auditArg(state json.RawMessage) any {
+	if state == nil {
+		return nil
+	}
+	return string(state)
+}
+
+// scrubCustomerAudit removes personal data of erased customer from audit: customer's states and addresses
+// snapshotted into its bills. Entries themselves are kept, so it's still known who changed what and when
+func (s *Service) scrubCustomerAudit(ctx context.Context, tx *sqlx.Tx, id int) error {
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE audit SET before = NULL, after = NULL WHERE entity = $1 AND entity_id = $2
+	`, AuditCustomer, id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+	UPDATE audit
+	SET before = before - 'billing_address' - 'shipping_address', after = after - 'billing_address' - 'shipping_address'
+	WHERE entity = $1 AND entity_id IN (SELECT id FROM bill WHERE customer_id = $2)
+	`, AuditBill, id)
+	return err
+}
+
+// GetAuditEntries returns recorded changes matching filter, the latest first
+func (s *Service) GetAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	if _, ok := auditSnapshots[filter.Entity]; filter.Entity != "" && !ok {
+		return nil, &ApiError{Err: fmt.Sprintf("unknown audited entity %q", filter.Entity)}
+	}
+	if action := filter.Action; action != "" && action != AuditCreate && action != AuditUpdate && action != AuditDelete {
+		return nil, &ApiError{Err: fmt.Sprintf("unknown audit action %q, expected %v, %v or %v", action, AuditCreate, AuditUpdate, AuditDelete)}
+	}
+
+	entries := []AuditEntry{}
+	if err := s.db.SelectContext(ctx, &entries, `
+	SELECT audit.id, audit.created_at, audit.actor, audit.request_id, audit.entity, audit.entity_id, audit.action,
+		COALESCE(audit.before, 'null') AS before, COALESCE(audit.after, 'null') AS after
+	FROM audit
+	WHERE ($1 = '' OR audit.entity = $1)
+		AND ($2 = 0 OR audit.entity_id = $2)
+		AND ($3 = '' OR audit.actor = $3)
+		AND ($4 = '' OR audit.action = $4)
+		AND ($5 = '' OR audit.request_id = $5)
+		AND ($6::timestamp IS NULL OR audit.created_at >= $6)
+		AND ($7::timestamp IS NULL OR audit.created_at < $7)
+	ORDER BY audit.created_at DESC, audit.id DESC
+	LIMIT $8 OFFSET $9
+	`, filter.Entity, filter.EntityId, filter.Actor, filter.Action, filter.RequestId, filter.From, filter.To,
+		filter.Limit, filter.Offset); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
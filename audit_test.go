@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditDiff(t *testing.T) {
+	state := json.RawMessage(`{"id": 1, "name": "Phone", "price": 250, "lines": [{"product_id": 2}]}`)
+
+	tests := []struct {
+		name           string
+		before, after  json.RawMessage
+		expectedBefore string
+		expectedAfter  string
+	}{
+		{"create", nil, state, "", `{"id":1,"lines":[{"product_id":2}],"name":"Phone","price":250}`},
+		{"delete", state, nil, `{"id":1,"lines":[{"product_id":2}],"name":"Phone","price":250}`, ""},
+		{
+			"update",
+			state,
+			json.RawMessage(`{"id": 1, "name": "Phone", "price": 300, "lines": [], "archived_at": null}`),
+			`{"lines":[{"product_id":2}],"price":250}`,
+			`{"archived_at":null,"lines":[],"price":300}`,
+		},
+		{"no change", state, state, "", ""},
+	}
+	for _, test := range tests {
+		before, after, err := auditDiff(test.before, test.after)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.name, err)
+			continue
+		}
+		if string(before) != test.expectedBefore || string(after) != test.expectedAfter {
+			t.Errorf("%v: have to be %v -> %v, got %s -> %s", test.name, test.expectedBefore, test.expectedAfter, before, after)
+		}
+	}
+
+	if _, _, err := auditDiff(json.RawMessage(`[1]`), state); err == nil {
+		t.Errorf("State which is not object have to be rejected")
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	tests := []struct {
+		name            string
+		actor, request  string
+		expectedStatus  int
+		expectedActor   string
+		expectedRequest string
+	}{
+		{"defaults", "", "", http.StatusOK, AuditAnonymousActor, ""},
+		{"passed", "jane.doe@example.com", "req-1:2", http.StatusOK, "jane.doe@example.com", "req-1:2"},
+		{"unicode actor", "Zoë", "", http.StatusOK, "Zoë", ""},
+		{"long actor", strings.Repeat("a", 101), "", http.StatusBadRequest, "", ""},
+		{"actor with space", "jane doe", "", http.StatusBadRequest, "", ""},
+		{"long request id", "", strings.Repeat("1", 101), http.StatusBadRequest, "", ""},
+		{"request id with quote", "", "req'1", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actor auditActor
+			reached := false
+			handler := auditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actor, reached = auditActorFrom(r.Context()), true
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/product", nil)
+			if test.actor != "" {
+				r.Header.Set("X-Actor", test.actor)
+			}
+			if test.request != "" {
+				r.Header.Set("X-Request-Id", test.request)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Fatalf("Status have to be %v, got %v", test.expectedStatus, w.Code)
+			}
+			if test.expectedStatus != http.StatusOK {
+				if reached {
+					t.Errorf("Rejected request cannot reach handler")
+				}
+				return
+			}
+			if actor.Actor != test.expectedActor {
+				t.Errorf("Actor have to be %q, got %+v", test.expectedActor, actor)
+			}
+			if test.expectedRequest != "" && actor.RequestId != test.expectedRequest {
+				t.Errorf("Request id have to be %q, got %+v", test.expectedRequest, actor)
+			}
+			if w.Header().Get("X-Request-Id") == "" {
+				t.Errorf("Request id have to be echoed")
+			}
+		})
+	}
+}
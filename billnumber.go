@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// billNumberToken matches placeholders of bill number format like {YYYY} or {seq:06}
+var billNumberToken = regexp.MustCompile(`\{([A-Za-z]+)(?::(\d+))?\}`)
+
+// BillNumberFormat renders bill numbers like INV-2023-000042 from format like INV-{YYYY}-{seq:06}.
+// Supported placeholders are {YYYY}, {YY}, {MM}, {DD}, {TENANT} and {seq} with optional zero padding width.
+// Everything around {seq} is scope of counter, so INV-{YYYY}-{seq} starts from 1 every year
+// and {TENANT}-{seq} counts bills of each tenant separately
+type BillNumberFormat struct {
+	format string
+	tenant string
+}
+
+func parseBillNumberFormat(format, tenant string) (*BillNumberFormat, error) {
+	seqs := 0
+	for _, match := range billNumberToken.FindAllStringSubmatch(format, -1) {
+		switch match[1] {
+		case "seq":
+			seqs++
+		case "YYYY", "YY", "MM", "DD", "TENANT":
+			if match[2] != "" {
+				return nil, fmt.Errorf("bill number placeholder {%v} doesn't accept width", match[1])
+			}
+		default:
+			return nil, fmt.Errorf("unknown bill number placeholder {%v}", match[1])
+		}
+	}
+	if seqs != 1 {
+		return nil, fmt.Errorf("bill number format %q have to contain exactly one {seq} placeholder", format)
+	}
+	if strings.Contains(format, "{TENANT}") && tenant == "" {
+		return nil, fmt.Errorf("bill number format %q uses {TENANT}, but BILL_NUMBER_TENANT is empty", format)
+	}
+	return &BillNumberFormat{format: format, tenant: tenant}, nil
+}
+
+// Scope returns key of counter bill created at passed time is numbered by
+func (f *BillNumberFormat) Scope(createdAt time.Time) string {
+	return billNumberToken.ReplaceAllStringFunc(f.format, func(token string) string {
+		switch match := billNumberToken.FindStringSubmatch(token); match[1] {
+		case "YYYY":
+			return createdAt.Format("2006")
+		case "YY":
+			return createdAt.Format("06")
+		case "MM":
+			return createdAt.Format("01")
+		case "DD":
+			return createdAt.Format("02")
+		case "TENANT":
+			return f.tenant
+		}
+		return token
+	})
+}
+
+// Number renders seq into scope returned by Scope
+func (f *BillNumberFormat) Number(scope string, seq int) string {
+	return billNumberToken.ReplaceAllStringFunc(scope, func(token string) string {
+		match := billNumberToken.FindStringSubmatch(token)
+		width, _ := strconv.Atoi(match[2])
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+}
+
+// nextBillNumber allocates number of bill created in transaction tx. Counter's row stays locked until tx ends,
+// so concurrent bills wait for each other and rolled back bill gives its number back, leaving no gaps
+func (s *Service) nextBillNumber(ctx context.Context, tx *sqlx.Tx) (string, error) {
+	format, err := parseBillNumberFormat(s.config.Bill.NumberFormat, s.config.Bill.NumberTenant)
+	if err != nil {
+		return "", err
+	}
+
+	// LOCALTIMESTAMP is start time of transaction, so it is the same as bill's created_at
+	var createdAt time.Time
+	if err := tx.GetContext(ctx, &createdAt, "SELECT LOCALTIMESTAMP"); err != nil {
+		return "", err
+	}
+
+	scope := format.Scope(createdAt)
+	var seq int
+	if err := tx.GetContext(ctx, &seq, `
+	INSERT INTO billnumbercounter (scope, value) VALUES ($1, 1)
+	ON CONFLICT (scope) DO UPDATE SET value = billnumbercounter.value + 1
+	RETURNING value
+	`, scope); err != nil {
+		return "", err
+	}
+	return format.Number(scope, seq), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBillNumberFormat(t *testing.T) {
+	createdAt := time.Date(2023, 4, 7, 15, 4, 5, 0, time.UTC)
+	cases := []struct {
+		format string
+		tenant string
+		seq    int
+		scope  string
+		number string
+	}{
+		{"INV-{YYYY}-{seq:06}", "", 42, "INV-2023-{seq:06}", "INV-2023-000042"},
+		{"{YY}{MM}{DD}/{seq}", "", 7, "230407/{seq}", "230407/7"},
+		{"{TENANT}-{seq:04}", "SHOP1", 12345, "SHOP1-{seq:04}", "SHOP1-12345"}, // width is minimal, not maximal
+		{"{TENANT}-{YYYY}-{seq:03}", "B", 1, "B-2023-{seq:03}", "B-2023-001"},
+	}
+
+	for _, c := range cases {
+		format, err := parseBillNumberFormat(c.format, c.tenant)
+		if err != nil {
+			t.Errorf("Cannot parse %v: %+v", c.format, err)
+			continue
+		}
+		scope := format.Scope(createdAt)
+		if scope != c.scope {
+			t.Errorf("Invalid scope of %v: have to be %v, got %v", c.format, c.scope, scope)
+		}
+		if number := format.Number(scope, c.seq); number != c.number {
+			t.Errorf("Invalid number %v of %v: have to be %v, got %v", c.seq, c.format, c.number, number)
+		}
+	}
+
+	for _, invalid := range []struct{ format, tenant string }{
+		{"INV-{YYYY}", ""},
+		{"{seq}-{seq}", ""},
+		{"INV-{YYYY:2}-{seq}", ""},
+		{"INV-{HH}-{seq}", ""},
+		{"{TENANT}-{seq}", ""},
+	} {
+		if _, err := parseBillNumberFormat(invalid.format, invalid.tenant); err == nil {
+			t.Errorf("Parsing %q have to fail", invalid.format)
+		}
+	}
+}
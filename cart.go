@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const cartColumns = `cart.id, COALESCE(cart.customer_id, 0) AS customer_id, COALESCE(cart.session, '') AS session,
+	cart.coupon, cart.created_at, cart.expires_at`
+
+// cartNotExists is returned for wrong token too, so carts of others cannot be found by their sequential ids
+func cartNotExists(id int) error {
+	return &ApiError{Err: fmt.Sprintf("Cart with passed id:%v not exists", id)}
+}
+
+// newCartToken returns random secret of cart
+func newCartToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// validateCartFields checks that cart's customer and coupon exist. Whether coupon is applicable is shown by preview
+func (s *Service) validateCartFields(ctx context.Context, q sqlx.QueryerContext, dto CartDTOAdd) error {
+	if dto.Customer != 0 {
+		var hasCustomer bool
+		if err := q.QueryRowxContext(ctx, `
+		SELECT EXISTS(SELECT id FROM customer WHERE id = $1 AND deleted_at IS NULL)
+		`, dto.Customer).Scan(&hasCustomer); err != nil {
+			return err
+		}
+		if !hasCustomer {
+			return &ApiError{Err: fmt.Sprintf("customer with passed id:%v not exists", dto.Customer)}
+		}
+	}
+	if dto.Coupon != "" {
+		var hasCoupon bool
+		if err := q.QueryRowxContext(ctx, `
+		SELECT EXISTS(SELECT id FROM coupon WHERE LOWER(code) = LOWER($1))
+		`, dto.Coupon).Scan(&hasCoupon); err != nil {
+			return err
+		}
+		if !hasCoupon {
+			return &ApiError{Err: fmt.Sprintf("Coupon %v not exists", dto.Coupon)}
+		}
+	}
+	return nil
+}
+
+// AddCart creates cart with new token, which is the only way to access the cart afterwards
+func (s *Service) AddCart(ctx context.Context, dto CartDTOAdd) (*Cart, error) {
+	token, err := newCartToken()
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &Cart{}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := s.validateCartFields(ctx, tx, dto); err != nil {
+			return err
+		}
+		if err := tx.GetContext(ctx, cart, `
+		INSERT INTO cart (customer_id, session, token, coupon, expires_at)
+		VALUES (NULLIF($1, 0), NULLIF($2, ''), $3, $4, NOW() + make_interval(secs => $5))
+		RETURNING `+cartColumns+`, cart.token
+		`, dto.Customer, dto.Session, token, dto.Coupon, s.config.Cart.TTL.Seconds()); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditCart, cart.Id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return cart, nil
+}
+
+// touchCart checks cart's token and prolongs cart's life after its change. Cart is locked, so it cannot be
+// checked out concurrently
+func (s *Service) touchCart(ctx context.Context, tx *sqlx.Tx, id int, token string) error {
+	resp, err := tx.ExecContext(ctx, `
+	UPDATE cart SET expires_at = NOW() + make_interval(secs => $2) WHERE id = $1 AND token = $3 AND expires_at > NOW()
+	`, id, s.config.Cart.TTL.Seconds(), token)
+	if err != nil {
+		return err
+	}
+	if count, _ := resp.RowsAffected(); count == 0 {
+		return cartNotExists(id)
+	}
+	return nil
+}
+
+// UpdateCartById replaces cart's customer, session and coupon, e.g. when anonymous visitor signs in
+func (s *Service) UpdateCartById(ctx context.Context, dto CartDTOUpdate) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCart, dto.Id)
+		if err != nil {
+			return err
+		}
+		if err := s.touchCart(ctx, tx, dto.Id, dto.Token); err != nil {
+			return err
+		}
+		if err := s.validateCartFields(ctx, tx, dto.CartDTOAdd); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE cart SET customer_id = NULLIF($2, 0), session = NULLIF($3, ''), coupon = $4 WHERE id = $1
+		`, dto.Id, dto.Customer, dto.Session, dto.Coupon); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditCart, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) DeleteCartById(ctx context.Context, id int, token string) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCart, id)
+		if err != nil {
+			return err
+		}
+		resp, err := tx.ExecContext(ctx, "DELETE FROM cart WHERE id = $1 AND token = $2 AND expires_at > NOW()", id, token)
+		if err != nil {
+			return err
+		}
+		if count, _ := resp.RowsAffected(); count == 0 {
+			return cartNotExists(id)
+		}
+		return s.audit(ctx, tx, AuditCart, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// AddCartItem adds quantity of product to cart. Stock isn't checked until checkout, cart's preview shows shortage
+func (s *Service) AddCartItem(ctx context.Context, dto CartItemDTO) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCart, dto.Cart)
+		if err != nil {
+			return err
+		}
+		if err := s.touchCart(ctx, tx, dto.Cart, dto.Token); err != nil {
+			return err
+		}
+		// archived product is treated as absent
+		res, err := tx.ExecContext(ctx, `
+		INSERT INTO cartitem (cart_id, product_id, quantity)
+		SELECT $1, product.id, $3 FROM product WHERE product.id = $2 AND product.archived_at IS NULL
+		ON CONFLICT (cart_id, product_id) DO UPDATE SET quantity = cartitem.quantity + EXCLUDED.quantity
+		`, dto.Cart, dto.Product, dto.Quantity)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", dto.Product)}
+		}
+		return s.audit(ctx, tx, AuditCart, dto.Cart, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// UpdateCartItem sets quantity of product in cart
+func (s *Service) UpdateCartItem(ctx context.Context, dto CartItemDTO) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCart, dto.Cart)
+		if err != nil {
+			return err
+		}
+		if err := s.touchCart(ctx, tx, dto.Cart, dto.Token); err != nil {
+			return err
+		}
+		resp, err := tx.ExecContext(ctx, `
+		UPDATE cartitem SET quantity = $3 WHERE cart_id = $1 AND product_id = $2
+		`, dto.Cart, dto.Product, dto.Quantity)
+		if err != nil {
+			return err
+		}
+		if count, _ := resp.RowsAffected(); count == 0 {
+			return &ApiError{Err: fmt.Sprintf("Product with passed id:%v is not in cart", dto.Product)}
+		}
+		return s.audit(ctx, tx, AuditCart, dto.Cart, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) DeleteCartItem(ctx context.Context, cart_id, product_id int, token string) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCart, cart_id)
+		if err != nil {
+			return err
+		}
+		if err := s.touchCart(ctx, tx, cart_id, token); err != nil {
+			return err
+		}
+		resp, err := tx.ExecContext(ctx, "DELETE FROM cartitem WHERE cart_id = $1 AND product_id = $2", cart_id, product_id)
+		if err != nil {
+			return err
+		}
+		if count, _ := resp.RowsAffected(); count == 0 {
+			return &ApiError{Err: fmt.Sprintf("Product with passed id:%v is not in cart", product_id)}
+		}
+		return s.audit(ctx, tx, AuditCart, cart_id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// getCartItems returns cart's products priced by their current prices and tax rates
+func (s *Service) getCartItems(ctx context.Context, q sqlx.QueryerContext, id int) ([]CartItem, error) {
+	items := []CartItem{}
+	if err := sqlx.SelectContext(ctx, q, &items, `
+	SELECT cartitem.product_id, product.name, product.category, cartitem.quantity,
+		product.price AS "price.amount", product.currency AS "price.currency", product.quantity AS stock,
+		COALESCE((
+			SELECT taxrate.rate FROM taxrate
+			WHERE taxrate.tax_class_id = product.tax_class_id AND taxrate.effective_from <= NOW()
+			ORDER BY taxrate.effective_from DESC
+			LIMIT 1
+		), 0) AS tax_rate
+	FROM cartitem
+	JOIN product ON product.id = cartitem.product_id
+	WHERE cartitem.cart_id = $1
+	ORDER BY cartitem.product_id
+	`, id); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetCartById returns cart priced as if it were checked out now
+func (s *Service) GetCartById(ctx context.Context, id int, token string) (*CartPreview, error) {
+	preview := &CartPreview{}
+	if err := s.db.GetContext(ctx, &preview.Cart, `
+	SELECT `+cartColumns+` FROM cart WHERE cart.id = $1 AND cart.token = $2 AND cart.expires_at > NOW()
+	`, id, token); err != nil {
+		if err == sql.ErrNoRows {
+			err = cartNotExists(id)
+		}
+		return nil, err
+	}
+
+	items, err := s.getCartItems(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var coupon *Coupon
+	var problems []string
+	if preview.Coupon != "" {
+		coupon = &Coupon{}
+		if err := s.db.GetContext(ctx, coupon, `
+		SELECT `+couponColumns+` FROM coupon WHERE LOWER(code) = LOWER($1)
+		`, preview.Coupon); err != nil {
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+			coupon, problems = nil, []string{fmt.Sprintf("Coupon %v not exists", preview.Coupon)}
+		} else if err := s.checkCouponUsage(ctx, s.db, coupon, preview.Customer); err != nil {
+			if _, ok := err.(*ApiError); !ok {
+				return nil, err
+			}
+			coupon, problems = nil, []string{err.Error()}
+		}
+	}
+
+	preview.Items = items
+	preview.Totals, preview.Problems = previewCart(items, coupon, s.config.Tax.PricingMode)
+	preview.Problems = append(problems, preview.Problems...)
+	return preview, nil
+}
+
+// previewCart calculates amounts of cart's items and totals like bill's ones and lists problems preventing
+// checkout. Coupon's discount is previewed when coupon is passed
+func previewCart(items []CartItem, coupon *Coupon, mode string) (*BillTotals, []string) {
+	problems := []string{}
+	if len(items) == 0 {
+		problems = append(problems, "Cart is empty")
+	}
+	currency := defaultCurrency
+	if len(items) > 0 {
+		currency = items[0].Price.Currency
+	}
+
+	lines := make([]BillLine, len(items))
+	mixed := false
+	for i, item := range items {
+		lines[i] = item.BillLine
+		if item.Price.Currency != currency {
+			problems = append(problems, fmt.Sprintf("product id:%v is priced in %v while cart's products are priced in %v",
+				item.Product, item.Price.Currency, currency))
+			mixed = true
+		}
+		if item.Quantity > item.Stock {
+			problems = append(problems, fmt.Sprintf("only %v of product id:%v are in stock, requested %v",
+				item.Stock, item.Product, item.Quantity))
+		}
+	}
+	if mixed {
+		return nil, problems
+	}
+
+	var discount int64
+	if coupon != nil && len(lines) > 0 {
+		var err error
+		if discount, err = couponDiscount(coupon, lines, nil, currency); err != nil {
+			problems = append(problems, err.Error())
+			coupon = nil
+		}
+	}
+
+	totals := calculateBillTotals(lines, nil, discount, currency)
+	totals = applyTaxes(totals, lines, coupon, mode)
+	for i := range items {
+		items[i].BillLine = lines[i]
+	}
+	return &totals, problems
+}
+
+// CheckoutCart creates bill of cart's products and deletes cart in single transaction.
+// Bill takes products from stock, so concurrent checkouts cannot take more than is in stock
+func (s *Service) CheckoutCart(ctx context.Context, dto CartCheckoutDTO) (*Bill, error) {
+	var bill *Bill
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		var cart Cart
+		if err := tx.GetContext(ctx, &cart, `
+		SELECT `+cartColumns+` FROM cart WHERE cart.id = $1 AND cart.token = $2 AND cart.expires_at > NOW() FOR UPDATE
+		`, dto.Cart, dto.Token); err != nil {
+			if err == sql.ErrNoRows {
+				err = cartNotExists(dto.Cart)
+			}
+			return err
+		}
+		before, err := auditSnapshot(ctx, tx, AuditCart, cart.Id)
+		if err != nil {
+			return err
+		}
+
+		customer := cart.Customer
+		if dto.Customer != 0 {
+			if customer != 0 && customer != dto.Customer {
+				return &ApiError{Err: fmt.Sprintf("Cart belongs to customer id:%v", customer)}
+			}
+			customer = dto.Customer
+		}
+		if customer == 0 {
+			return &ApiError{Err: "customer is required to checkout anonymous cart"}
+		}
+
+		items, err := s.getCartItems(ctx, tx, cart.Id)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return &ApiError{Err: "Cart is empty"}
+		}
+		products := make([]BillProduct, len(items))
+		for i, item := range items {
+			products[i] = BillProduct{Product: item.Product, Quantity: item.Quantity}
+		}
+
+		if bill, err = s.addBill(ctx, tx, BillDTOAdd{
+			Customer:        customer,
+			Products:        products,
+			BillingAddress:  dto.BillingAddress,
+			ShippingAddress: dto.ShippingAddress,
+			Coupon:          cart.Coupon,
+			Currency:        dto.Currency,
+		}); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM cart WHERE id = $1", cart.Id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditCart, cart.Id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return bill, nil
+}
+
+// DeleteExpiredCarts deletes carts which weren't changed for configured time and returns their number.
+// Carts are deleted one by one, so every deletion is audited along with cart's items
+func (s *Service) DeleteExpiredCarts(ctx context.Context) (int64, error) {
+	var deleted int64
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		ids := []int{}
+		if err := tx.SelectContext(ctx, &ids, `
+		SELECT id FROM cart WHERE expires_at <= NOW() FOR UPDATE SKIP LOCKED
+		`); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			before, err := auditSnapshot(ctx, tx, AuditCart, id)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM cart WHERE id = $1", id); err != nil {
+				return err
+			}
+			if err := s.audit(ctx, tx, AuditCart, id, AuditDelete, before); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	tx.Commit()
+	return deleted, nil
+}
+
+// CartCleaner periodically deletes expired carts
+type CartCleaner struct {
+	s        *Service
+	interval time.Duration
+}
+
+func NewCartCleaner(s *Service, interval time.Duration) *CartCleaner {
+	return &CartCleaner{s: s, interval: interval}
+}
+
+func (c *CartCleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if count, err := c.s.DeleteExpiredCarts(ctx); err != nil {
+			log.Println(err)
+		} else if count > 0 {
+			log.Printf("Deleted %v expired carts", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
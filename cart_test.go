@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPreviewCart(t *testing.T) {
+	items := []CartItem{
+		{BillLine: BillLine{Product: 1, Category: "consoles", Quantity: 2, Price: NewMoney(500, "USD")}, Stock: 5},
+		{BillLine: BillLine{Product: 2, Category: "phones", Quantity: 3, Price: NewMoney(200, "USD")}, Stock: 1},
+	}
+
+	// Stock shortage is reported, but totals are still calculated
+	coupon := &Coupon{Code: "ALL10", Discount: Discount{Type: DiscountPercent, Value: 10}}
+	totals, problems := previewCart(items, coupon, TaxExclusive)
+	expected := []string{"only 1 of product id:2 are in stock, requested 3"}
+	if !cmp.Equal(problems, expected) {
+		t.Errorf("Invalid cart problems: have to be %v, got %v", expected, problems)
+	}
+	if totals == nil || totals.Subtotal.Amount != 1600 || totals.CouponDiscount.Amount != 160 || totals.Total.Amount != 1440 {
+		t.Errorf("Invalid cart totals: %+v", totals)
+	}
+	if items[0].Total.Amount != 1000 || items[1].Total.Amount != 600 {
+		t.Errorf("Invalid cart line totals: %+v", items)
+	}
+
+	// Not applicable coupon is reported and ignored
+	coupon = &Coupon{Code: "BIG", Discount: Discount{Type: DiscountFixed, Value: 100}, Currency: "USD", MinBillTotal: 5000}
+	totals, problems = previewCart(items[:1], coupon, TaxExclusive)
+	if len(problems) != 1 || totals == nil || totals.CouponDiscount.Amount != 0 || totals.Total.Amount != 1000 {
+		t.Errorf("Invalid cart preview with not applicable coupon: %+v %v", totals, problems)
+	}
+
+	// Mixed currencies can't be totalled
+	mixed := append(items[:1:1], CartItem{BillLine: BillLine{Product: 3, Quantity: 1, Price: NewMoney(100, "EUR")}, Stock: 1})
+	if totals, problems = previewCart(mixed, nil, TaxExclusive); totals != nil || len(problems) != 1 {
+		t.Errorf("Invalid cart preview with mixed currencies: %+v %v", totals, problems)
+	}
+
+	if totals, problems = previewCart(nil, nil, TaxExclusive); totals == nil || totals.Total.Amount != 0 || !cmp.Equal(problems, []string{"Cart is empty"}) {
+		t.Errorf("Invalid empty cart preview: %+v %v", totals, problems)
+	}
+}
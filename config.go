@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v7"
 	"github.com/joho/godotenv"
@@ -20,6 +21,48 @@ type config struct {
 
 		Scripts string `env:"DB_SCRIPTS_PATH"`
 	}
+
+	Tax struct {
+		PricingMode string `env:"TAX_PRICING_MODE" envDefault:"exclusive"`
+	}
+
+	Bill struct {
+		NumberFormat string `env:"BILL_NUMBER_FORMAT" envDefault:"INV-{YYYY}-{seq:06}"`
+		NumberTenant string `env:"BILL_NUMBER_TENANT"`
+	}
+
+	Money struct {
+		DefaultCurrency string `env:"DEFAULT_CURRENCY" envDefault:"USD"`
+		JSONFormat      string `env:"MONEY_JSON_FORMAT" envDefault:"decimal"`
+	}
+
+	Seller struct {
+		Name    string `env:"SELLER_NAME"`
+		Address string `env:"SELLER_ADDRESS"`
+		TaxId   string `env:"SELLER_TAX_ID"`
+		Email   string `env:"SELLER_EMAIL"`
+		Phone   string `env:"SELLER_PHONE"`
+	}
+
+	Templates struct {
+		Path string `env:"TEMPLATES_PATH"`
+	}
+
+	LowStock struct {
+		Notifier      string        `env:"LOW_STOCK_NOTIFIER" envDefault:"log"`
+		WebhookUrl    string        `env:"LOW_STOCK_WEBHOOK_URL"`
+		CheckInterval time.Duration `env:"LOW_STOCK_CHECK_INTERVAL" envDefault:"1m"`
+	}
+
+	Related struct {
+		MinSupport      float64       `env:"RELATED_MIN_SUPPORT" envDefault:"0.01"`
+		RefreshInterval time.Duration `env:"RELATED_REFRESH_INTERVAL" envDefault:"1h"`
+	}
+
+	Cart struct {
+		TTL             time.Duration `env:"CART_TTL" envDefault:"168h"`
+		CleanupInterval time.Duration `env:"CART_CLEANUP_INTERVAL" envDefault:"1h"`
+	}
 }
 
 var once sync.Once
@@ -36,6 +79,28 @@ func GetConfig() *config {
 		if err := env.Parse(configInstance); err != nil {
 			log.Fatalf("unable to parse .env file: %e", err)
 		}
+		if mode := configInstance.Tax.PricingMode; mode != TaxExclusive && mode != TaxInclusive {
+			log.Fatalf("unknown TAX_PRICING_MODE %q, expected %q or %q", mode, TaxExclusive, TaxInclusive)
+		}
+		if _, err := parseBillNumberFormat(configInstance.Bill.NumberFormat, configInstance.Bill.NumberTenant); err != nil {
+			log.Fatal(err)
+		}
+		if err := configureMoney(configInstance); err != nil {
+			log.Fatal(err)
+		}
+		if support := configInstance.Related.MinSupport; support < 0 || support > 1 {
+			log.Fatalf("RELATED_MIN_SUPPORT have to be in range 0..1, got %v", support)
+		}
+		// background jobs tick by intervals, ticker cannot tick by non-positive one
+		for name, interval := range map[string]time.Duration{
+			"LOW_STOCK_CHECK_INTERVAL": configInstance.LowStock.CheckInterval,
+			"RELATED_REFRESH_INTERVAL": configInstance.Related.RefreshInterval,
+			"CART_CLEANUP_INTERVAL":    configInstance.Cart.CleanupInterval,
+		} {
+			if interval <= 0 {
+				log.Fatalf("%v have to be positive, got %v", name, interval)
+			}
+		}
 	})
 	return configInstance
 }
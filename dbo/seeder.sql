@@ -1,37 +1,72 @@
+-- Insert default data for TaxClass and TaxRate tables
+INSERT INTO TaxClass (name) VALUES
+('Standard'),
+('Reduced');
+
+INSERT INTO TaxRate (tax_class_id, rate, effective_from) VALUES
+(1, 2000, '2000-01-01'),
+(2, 500, '2000-01-01');
+
+-- Insert default data for ExchangeRate table
+INSERT INTO ExchangeRate (base, quote, rate, effective_from) VALUES
+('USD', 'EUR', 0.92, '2000-01-01'),
+('USD', 'GBP', 0.79, '2000-01-01');
+
 -- Insert default data for Product table
-INSERT INTO Product (name, description, price, quantity) VALUES
-('iPhone X', 'Apple iPhone X with OLED screen and Face ID', 999, 50),
-('Samsung Galaxy S9', 'Samsung Galaxy S9 with Infinity Display and Bixby', 799, 75),
-('MacBook Pro', 'Apple MacBook Pro with Retina Display and Touch Bar', 1999, 25),
-('Dell XPS 13', 'Dell XPS 13 with InfinityEdge Display and Windows 10', 1299, 30),
-('Nintendo Switch', 'Nintendo Switch with Joy-Con controllers and dock', 299, 100),
-('PlayStation 4 Pro', 'Sony PlayStation 4 Pro with 4K HDR gaming and streaming', 399, 80),
-('Xbox One X', 'Microsoft Xbox One X with 4K gaming and UHD Blu-ray player', 499, 70),
-('Amazon Echo Dot', 'Amazon Echo Dot with Alexa voice assistant and smart home control', 49, 150),
-('Google Home Mini', 'Google Home Mini with Google Assistant and Chromecast support', 39, 200),
-('Fitbit Charge 2', 'Fitbit Charge 2 with heart rate monitor and fitness tracking', 149, 125);
+INSERT INTO Product (name, description, price, currency, quantity, category, reorder_point, reorder_quantity) VALUES
+('iPhone X', 'Apple iPhone X with OLED screen and Face ID', 99900, 'USD', 50, 'phones', 10, 40),
+('Samsung Galaxy S9', 'Samsung Galaxy S9 with Infinity Display and Bixby', 79900, 'USD', 75, 'phones', 15, 50),
+('MacBook Pro', 'Apple MacBook Pro with Retina Display and Touch Bar', 199900, 'USD', 25, 'laptops', 5, 20),
+('Dell XPS 13', 'Dell XPS 13 with InfinityEdge Display and Windows 10', 129900, 'USD', 30, 'laptops', 5, 20),
+('Nintendo Switch', 'Nintendo Switch with Joy-Con controllers and dock', 29900, 'USD', 100, 'consoles', 20, 80),
+('PlayStation 4 Pro', 'Sony PlayStation 4 Pro with 4K HDR gaming and streaming', 39900, 'USD', 80, 'consoles', 15, 60),
+('Xbox One X', 'Microsoft Xbox One X with 4K gaming and UHD Blu-ray player', 49900, 'USD', 70, 'consoles', 15, 50),
+('Amazon Echo Dot', 'Amazon Echo Dot with Alexa voice assistant and smart home control', 4900, 'USD', 150, 'smart-home', 30, 100),
+('Google Home Mini', 'Google Home Mini with Google Assistant and Chromecast support', 3900, 'USD', 200, 'smart-home', 40, 150),
+('Fitbit Charge 2', 'Fitbit Charge 2 with heart rate monitor and fitness tracking', 14900, 'USD', 125, 'wearables', 25, 100);
+
+UPDATE Product SET tax_class_id = CASE WHEN category = 'smart-home' THEN 2 ELSE 1 END;
 
 -- Insert default data for Customer table
-INSERT INTO Customer (first_name, last_name) VALUES
-('John', 'Doe'),
-('Jane', 'Doe'),
-('Bob', 'Smith'),
-('Alice', 'Johnson'),
-('Tom', 'Jones');
+INSERT INTO Customer (first_name, last_name, email, phone) VALUES
+('John', 'Doe', 'john.doe@example.com', '+15550001001'),
+('Jane', 'Doe', 'jane.doe@example.com', '+15550001002'),
+('Bob', 'Smith', 'bob.smith@example.com', NULL),
+('Alice', 'Johnson', 'alice.johnson@example.com', '+15550001004'),
+('Tom', 'Jones', NULL, NULL);
+
+-- Insert default data for CustomerAddress table
+INSERT INTO CustomerAddress (customer_id, type, line1, city, postal_code, country) VALUES
+(1, 'billing', '12 Main Street', 'Springfield', '62701', 'US'),
+(1, 'shipping', '12 Main Street', 'Springfield', '62701', 'US'),
+(2, 'billing', '34 Oak Avenue', 'Portland', '97201', 'US'),
+(4, 'shipping', '7 Baker Street', 'London', 'NW1 6XE', 'GB');
 
--- Insert default data for Bill table
-INSERT INTO Bill (number, customer_id) VALUES
-('d511023c-d3a9-11ed-afa1-0242ac120002', 1),
-('d5110692-d3a9-11ed-afa1-0242ac120002', 2),
-('d511087c-d3a9-11ed-afa1-0242ac120002', 3),
-('d5110a34-d3a9-11ed-afa1-0242ac120002', 4),
-('d511102e-d3a9-11ed-afa1-0242ac120002', 5),
-('d5111240-d3a9-11ed-afa1-0242ac120002', 1),
-('d51114e8-d3a9-11ed-afa1-0242ac120002', 2),
-('d5111628-d3a9-11ed-afa1-0242ac120002', 3);
+-- Insert default data for Bill table, numbered by default INV-{YYYY}-{seq:06} format
+INSERT INTO Bill (number, customer_id)
+SELECT 'INV-' || TO_CHAR(LOCALTIMESTAMP, 'YYYY') || '-' || LPAD(seq::text, 6, '0'), customer_id FROM (VALUES
+(1, 1),
+(2, 2),
+(3, 3),
+(4, 4),
+(5, 5),
+(6, 1),
+(7, 2),
+(8, 3)
+) AS seeded (seq, customer_id)
+ORDER BY seq;
+
+INSERT INTO BillNumberCounter (scope, value) VALUES
+('INV-' || TO_CHAR(LOCALTIMESTAMP, 'YYYY') || '-{seq:06}', 8);
 
 -- Insert default data for ProductBill pivot table
-INSERT INTO ProductBill (product_id, bill_id, quantity) VALUES
+INSERT INTO ProductBill (product_id, bill_id, quantity, price, tax_rate)
+SELECT line.product_id, line.bill_id, line.quantity, Product.price, COALESCE((
+  SELECT TaxRate.rate FROM TaxRate
+  WHERE TaxRate.tax_class_id = Product.tax_class_id
+  ORDER BY TaxRate.effective_from DESC
+  LIMIT 1
+), 0) FROM (VALUES
 (1, 1, 2),
 (2, 1, 1),
 (4, 1, 3),
@@ -73,4 +108,38 @@ INSERT INTO ProductBill (product_id, bill_id, quantity) VALUES
 (3, 8, 1),
 (5, 8, 2),
 (7, 8, 3),
-(9, 8, 1);
\ No newline at end of file
+(9, 8, 1)
+) AS line (product_id, bill_id, quantity)
+JOIN Product ON Product.id = line.product_id;
+
+-- Store totals of seeded bills, they have no discounts and tax is added once per rate
+UPDATE Bill SET total = totals.total FROM (
+  SELECT bill_id, SUM(base + ROUND(base * tax_rate / 10000.0)) AS total FROM (
+    SELECT bill_id, tax_rate, SUM(quantity * price) AS base FROM ProductBill GROUP BY bill_id, tax_rate
+  ) AS rates
+  GROUP BY bill_id
+) AS totals
+WHERE Bill.id = totals.bill_id;
+
+-- Insert default data for Coupon table
+INSERT INTO Coupon (code, discount, usage_limit, usage_limit_per_customer, min_bill_total, categories) VALUES
+('WELCOME10', '{"type": "percent", "value": 10}', 0, 1, 0, NULL),
+('CONSOLES50', '{"type": "fixed", "value": 5000}', 100, 1, 30000, '{consoles}');
+
+-- Insert default data for Supplier table
+INSERT INTO Supplier (name, email, phone) VALUES
+('Gadget Wholesale', 'orders@gadget-wholesale.com', '+1 555 0100'),
+('Console Distribution', 'sales@console-distribution.com', '+1 555 0101');
+
+-- Insert default data for SupplierProduct price list table
+INSERT INTO SupplierProduct (supplier_id, product_id, price) VALUES
+(1, 1, 80000),
+(1, 2, 62000),
+(1, 3, 165000),
+(1, 4, 105000),
+(1, 8, 3000),
+(1, 9, 2500),
+(1, 10, 11000),
+(2, 5, 23000),
+(2, 6, 31000),
+(2, 7, 39000);
\ No newline at end of file
@@ -1,35 +1,281 @@
 BEGIN;
 
+-- Trigram matching is used for fuzzy search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Create TaxClass table
+CREATE TABLE TaxClass (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(50) NOT NULL
+);
+
+-- Create TaxRate table, rate is in hundredths of percent
+CREATE TABLE TaxRate (
+  id SERIAL PRIMARY KEY,
+  tax_class_id INTEGER NOT NULL REFERENCES TaxClass(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  rate INTEGER NOT NULL,
+  effective_from TIMESTAMP NOT NULL,
+  UNIQUE (tax_class_id, effective_from)
+);
+
+-- Create ExchangeRate table, rate is price of one unit of base currency in quote currency
+CREATE TABLE ExchangeRate (
+  id SERIAL PRIMARY KEY,
+  base CHAR(3) NOT NULL,
+  quote CHAR(3) NOT NULL,
+  rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+  effective_from TIMESTAMP NOT NULL,
+  UNIQUE (base, quote, effective_from)
+);
+
 -- Create Product table
 CREATE TABLE Product (
   id SERIAL PRIMARY KEY,
+  sku VARCHAR(64),
   name VARCHAR(50) NOT NULL,
   description TEXT,
-  price INTEGER NOT NULL,
-  quantity INTEGER NOT NULL
+  price BIGINT NOT NULL,
+  currency CHAR(3) NOT NULL DEFAULT 'USD',
+  quantity INTEGER NOT NULL,
+  category VARCHAR(50) NOT NULL DEFAULT '',
+  tax_class_id INTEGER REFERENCES TaxClass(id),
+  reorder_point INTEGER NOT NULL DEFAULT 0,
+  reorder_quantity INTEGER NOT NULL DEFAULT 0,
+  archived_at TIMESTAMP
 );
 
+-- Skus of active products are unique, archived product's sku may be reused
+CREATE UNIQUE INDEX product_sku_unique ON Product (sku) WHERE archived_at IS NULL;
+
+-- Product search finds candidates by stems of words and by trigrams of text before ranking them,
+-- expressions have to be the same as in search's query
+CREATE INDEX product_search_document_idx ON Product
+  USING GIN ((to_tsvector('english', name) || to_tsvector('english', COALESCE(description, ''))))
+  WHERE archived_at IS NULL;
+CREATE INDEX product_search_text_idx ON Product
+  USING GIN ((name || ' ' || COALESCE(description, '')) gin_trgm_ops)
+  WHERE archived_at IS NULL;
+
 -- Create Customer table
 CREATE TABLE Customer (
   id SERIAL PRIMARY KEY,
   first_name VARCHAR(50) NOT NULL,
-  last_name VARCHAR(50) NOT NULL
+  last_name VARCHAR(50) NOT NULL,
+  email VARCHAR(100),
+  phone VARCHAR(20),
+  deleted_at TIMESTAMP,
+  erased_at TIMESTAMP
+);
+
+-- Emails of active customers are unique regardless of their case
+CREATE UNIQUE INDEX customer_email_unique ON Customer (LOWER(email)) WHERE deleted_at IS NULL;
+
+-- Create CustomerAddress table
+CREATE TABLE CustomerAddress (
+  id SERIAL PRIMARY KEY,
+  customer_id INTEGER NOT NULL REFERENCES Customer(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  type VARCHAR(10) NOT NULL CHECK (type IN ('billing', 'shipping')),
+  line1 VARCHAR(100) NOT NULL,
+  line2 VARCHAR(100) NOT NULL DEFAULT '',
+  city VARCHAR(50) NOT NULL,
+  postal_code VARCHAR(20) NOT NULL,
+  country CHAR(2) NOT NULL
+);
+
+-- Create BillNumberCounter table, it keeps last sequence number of each numbering scope like INV-2023-{seq}
+CREATE TABLE BillNumberCounter (
+  scope VARCHAR(100) PRIMARY KEY,
+  value INTEGER NOT NULL
 );
 
 -- Create Bill table
 CREATE TABLE Bill (
   id SERIAL PRIMARY KEY,
-  number uuid NOT NULL,
+  number VARCHAR(100) NOT NULL UNIQUE,
   created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  customer_id INTEGER NOT NULL REFERENCES Customer(id)
+  customer_id INTEGER NOT NULL REFERENCES Customer(id),
+  currency CHAR(3) NOT NULL DEFAULT 'USD',
+  price_currency CHAR(3) NOT NULL DEFAULT 'USD',
+  exchange_rate NUMERIC(20, 10) NOT NULL DEFAULT 1,
+  exchange_rate_effective_from TIMESTAMP,
+  billing_address JSONB,
+  shipping_address JSONB,
+  discount JSONB,
+  tax_mode VARCHAR(10) NOT NULL DEFAULT 'exclusive',
+  status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'partially_paid', 'paid', 'void')),
+  total BIGINT NOT NULL DEFAULT 0
 );
 
--- Create ProductBill pivot table
+-- Indexes of bills listing's filters and sort orders, id breaks ties of cursor pagination
+CREATE INDEX bill_created_at_idx ON Bill (created_at, id);
+CREATE INDEX bill_total_idx ON Bill (total, id);
+CREATE INDEX bill_customer_id_idx ON Bill (customer_id, created_at);
+
+-- Create ProductBill pivot table, products of bills are archived instead of deleting
 CREATE TABLE ProductBill (
-  product_id INTEGER NOT NULL REFERENCES Product(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES Product(id) ON UPDATE CASCADE,
   bill_id INTEGER NOT NULL REFERENCES Bill(id) ON DELETE CASCADE ON UPDATE CASCADE,
   quantity INTEGER NOT NULL,
+  price BIGINT NOT NULL,
+  discount JSONB,
+  tax_rate INTEGER NOT NULL DEFAULT 0,
   PRIMARY KEY (product_id, bill_id)
 );
 
+-- Create ProductAssociation table, it caches how often products are bought together. Support is share of bills
+-- having both products, confidence is share of product's bills having related product and lift is ratio of confidence
+-- to share of bills having related product
+CREATE TABLE ProductAssociation (
+  product_id INTEGER NOT NULL REFERENCES Product(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  related_id INTEGER NOT NULL REFERENCES Product(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  bills INTEGER NOT NULL,
+  support DOUBLE PRECISION NOT NULL,
+  confidence DOUBLE PRECISION NOT NULL,
+  lift DOUBLE PRECISION NOT NULL,
+  computed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (product_id, related_id)
+);
+
+-- Create Cart table, cart belongs to customer or anonymous session until it's checked out into bill or expires
+CREATE TABLE Cart (
+  id SERIAL PRIMARY KEY,
+  customer_id INTEGER REFERENCES Customer(id),
+  session VARCHAR(100),
+  token CHAR(64) NOT NULL,
+  coupon VARCHAR(50) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  CHECK (customer_id IS NOT NULL OR session IS NOT NULL)
+);
+
+CREATE INDEX cart_expires_at_idx ON Cart (expires_at);
+
+-- Create CartItem table
+CREATE TABLE CartItem (
+  cart_id INTEGER NOT NULL REFERENCES Cart(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES Product(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  quantity INTEGER NOT NULL CHECK (quantity > 0),
+  PRIMARY KEY (cart_id, product_id)
+);
+
+-- Create Payment table, bills with payments cannot be deleted
+CREATE TABLE Payment (
+  id SERIAL PRIMARY KEY,
+  bill_id INTEGER NOT NULL REFERENCES Bill(id),
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  currency CHAR(3) NOT NULL,
+  method VARCHAR(10) NOT NULL CHECK (method IN ('cash', 'card', 'transfer')),
+  paid_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  reference VARCHAR(100) NOT NULL DEFAULT ''
+);
+
+-- Create CreditNote table, it returns products of bill
+CREATE TABLE CreditNote (
+  id SERIAL PRIMARY KEY,
+  bill_id INTEGER NOT NULL REFERENCES Bill(id),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  reason TEXT NOT NULL DEFAULT '',
+  restock BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Create CreditNoteLine table, amount is credited part of line's paid amount in bill's currency
+CREATE TABLE CreditNoteLine (
+  credit_note_id INTEGER NOT NULL REFERENCES CreditNote(id) ON DELETE CASCADE,
+  bill_id INTEGER NOT NULL,
+  product_id INTEGER NOT NULL,
+  quantity INTEGER NOT NULL CHECK (quantity > 0),
+  amount BIGINT NOT NULL,
+  PRIMARY KEY (credit_note_id, product_id),
+  FOREIGN KEY (product_id, bill_id) REFERENCES ProductBill(product_id, bill_id)
+);
+
+-- Create Refund table, refunds pay back credit notes
+CREATE TABLE Refund (
+  id SERIAL PRIMARY KEY,
+  credit_note_id INTEGER NOT NULL REFERENCES CreditNote(id),
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  currency CHAR(3) NOT NULL,
+  method VARCHAR(10) NOT NULL CHECK (method IN ('cash', 'card', 'transfer')),
+  refunded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  reference VARCHAR(100) NOT NULL DEFAULT ''
+);
+
+-- Create Coupon table. Empty products and categories mean coupon isn't restricted, coupon with currency applies only to bills in it
+CREATE TABLE Coupon (
+  id SERIAL PRIMARY KEY,
+  code VARCHAR(50) NOT NULL,
+  discount JSONB NOT NULL,
+  valid_from TIMESTAMP,
+  valid_to TIMESTAMP,
+  usage_limit INTEGER NOT NULL DEFAULT 0,
+  usage_limit_per_customer INTEGER NOT NULL DEFAULT 0,
+  currency CHAR(3),
+  min_bill_total BIGINT NOT NULL DEFAULT 0,
+  products INTEGER[],
+  categories VARCHAR(50)[]
+);
+
+CREATE UNIQUE INDEX coupon_code_unique ON Coupon (LOWER(code));
+
+-- Create CouponRedemption table, bill uses at most one coupon
+CREATE TABLE CouponRedemption (
+  coupon_id INTEGER NOT NULL REFERENCES Coupon(id),
+  bill_id INTEGER NOT NULL UNIQUE REFERENCES Bill(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  customer_id INTEGER NOT NULL REFERENCES Customer(id),
+  amount BIGINT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create Supplier table
+CREATE TABLE Supplier (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(100) NOT NULL,
+  email VARCHAR(100) NOT NULL DEFAULT '',
+  phone VARCHAR(30) NOT NULL DEFAULT ''
+);
+
+-- Create SupplierProduct price list table
+CREATE TABLE SupplierProduct (
+  supplier_id INTEGER NOT NULL REFERENCES Supplier(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES Product(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  price BIGINT NOT NULL,
+  currency CHAR(3) NOT NULL,
+  PRIMARY KEY (supplier_id, product_id)
+);
+
+-- Create PurchaseOrder table
+CREATE TABLE PurchaseOrder (
+  id SERIAL PRIMARY KEY,
+  supplier_id INTEGER NOT NULL REFERENCES Supplier(id),
+  status VARCHAR(20) NOT NULL DEFAULT 'draft',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create PurchaseOrderLine table
+CREATE TABLE PurchaseOrderLine (
+  purchase_order_id INTEGER NOT NULL REFERENCES PurchaseOrder(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES Product(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  quantity INTEGER NOT NULL,
+  received_quantity INTEGER NOT NULL DEFAULT 0,
+  price BIGINT NOT NULL,
+  currency CHAR(3) NOT NULL,
+  PRIMARY KEY (purchase_order_id, product_id)
+);
+
+-- Create Audit table, before and after keep only changed fields of entity's json snapshot
+CREATE TABLE Audit (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  actor VARCHAR(100) NOT NULL,
+  request_id VARCHAR(100) NOT NULL DEFAULT '',
+  entity VARCHAR(50) NOT NULL,
+  entity_id INTEGER NOT NULL,
+  action VARCHAR(10) NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+  before JSONB,
+  after JSONB
+);
+
+CREATE INDEX audit_entity_idx ON Audit (entity, entity_id, created_at);
+CREATE INDEX audit_created_at_idx ON Audit (created_at);
+
 END;
\ No newline at end of file
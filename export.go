@@ -0,0 +1,476 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Formats of exported files
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// exportContentTypes are content types of exported files by format
+var exportContentTypes = map[string]string{
+	ExportCSV:    "text/csv",
+	ExportNDJSON: "application/x-ndjson",
+	ExportXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportDecimal is money amount exported as number into spreadsheets and as decimal string elsewhere
+type exportDecimal string
+
+// exportColumn takes value of column from exported row. Values are strings, integers, exportDecimal,
+// time or nil for empty cell
+type exportColumn[T any] struct {
+	name  string
+	value func(row *T) any
+}
+
+// selectExportColumns returns columns in passed order, all columns are exported when none are passed
+func selectExportColumns[T any](columns []exportColumn[T], names []string) ([]exportColumn[T], error) {
+	if len(names) == 0 {
+		return columns, nil
+	}
+
+	byName := map[string]exportColumn[T]{}
+	all := make([]string, len(columns))
+	for i, column := range columns {
+		byName[column.name] = column
+		all[i] = column.name
+	}
+	selected := make([]exportColumn[T], len(names))
+	for i, name := range names {
+		column, ok := byName[name]
+		if !ok {
+			return nil, &ApiError{Err: fmt.Sprintf("Unknown column %q, expected some of %v", name, strings.Join(all, ","))}
+		}
+		selected[i] = column
+	}
+	return selected, nil
+}
+
+// exportRows streams rows of query scanned into T into file of passed format as they are read from database
+func exportRows[T any](ctx context.Context, q sqlx.QueryerContext, w io.Writer, options ExportOptions, columns []exportColumn[T], query string, args ...any) error {
+	columns, err := selectExportColumns(columns, options.Columns)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	writer, err := newExportWriter(options.Format, w, names)
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]any, len(columns))
+	for rows.Next() {
+		var row T
+		if err := rows.StructScan(&row); err != nil {
+			return err
+		}
+		for i, column := range columns {
+			values[i] = column.value(&row)
+		}
+		if err := writer.Write(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// exportWriter writes rows of exported file. Nothing is written until the first row or Close,
+// so errors of query are still reported before file is started
+type exportWriter interface {
+	Write(values []any) error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer, columns []string) (exportWriter, error) {
+	switch format {
+	case ExportCSV:
+		return &csvExportWriter{writer: csv.NewWriter(w), columns: columns}, nil
+	case ExportNDJSON:
+		return &ndjsonExportWriter{writer: bufio.NewWriter(w), columns: columns}, nil
+	case ExportXLSX:
+		return &xlsxExportWriter{w: w, columns: columns}, nil
+	default:
+		return nil, &ApiError{Err: fmt.Sprintf("Unknown export format %q, expected csv, ndjson or xlsx", format)}
+	}
+}
+
+// formatExportValue formats value as text of csv's cell
+func formatExportValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case exportDecimal:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvExportWriter struct {
+	writer  *csv.Writer
+	columns []string
+	record  []string
+	started bool
+}
+
+func (c *csvExportWriter) start() error {
+	c.started = true
+	c.record = make([]string, len(c.columns))
+	return c.writer.Write(c.columns)
+}
+
+func (c *csvExportWriter) Write(values []any) error {
+	if !c.started {
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+	for i, value := range values {
+		c.record[i] = formatExportValue(value)
+	}
+	return c.writer.Write(c.record)
+}
+
+func (c *csvExportWriter) Close() error {
+	if !c.started {
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// ndjsonExportWriter writes every row as json object with selected columns in their order
+type ndjsonExportWriter struct {
+	writer  *bufio.Writer
+	columns []string
+	buf     bytes.Buffer
+}
+
+// encode writes value as json without escaping of HTML characters
+func (n *ndjsonExportWriter) encode(value any) error {
+	n.buf.Reset()
+	encoder := json.NewEncoder(&n.buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	_, err := n.writer.Write(bytes.TrimSuffix(n.buf.Bytes(), []byte("\n")))
+	return err
+}
+
+func (n *ndjsonExportWriter) Write(values []any) error {
+	n.writer.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			n.writer.WriteByte(',')
+		}
+		if v, ok := value.(exportDecimal); ok {
+			value = string(v)
+		}
+		if err := n.encode(n.columns[i]); err != nil {
+			return err
+		}
+		n.writer.WriteByte(':')
+		if err := n.encode(value); err != nil {
+			return err
+		}
+	}
+	_, err := n.writer.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return n.writer.Flush()
+}
+
+// xlsxExportWriter streams single sheet workbook. Zip entries are written sequentially and strings are inlined
+// into cells instead of shared strings table, so rows never have to be kept in memory
+type xlsxExportWriter struct {
+	w       io.Writer
+	columns []string
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	row     int
+}
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// the second cell format shows dates, it is referenced by s="1"
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+func (x *xlsxExportWriter) start() error {
+	x.archive = zip.NewWriter(x.w)
+	for _, part := range xlsxParts {
+		f, err := x.archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := x.archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]any, len(x.columns))
+	for i, column := range x.columns {
+		header[i] = column
+	}
+	return x.writeRow(header)
+}
+
+func (x *xlsxExportWriter) writeRow(values []any) error {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	for i, value := range values {
+		ref := xlsxColumn(i) + strconv.Itoa(x.row)
+		switch v := value.(type) {
+		case nil:
+		case int, int64:
+			fmt.Fprintf(x.sheet, `<c r="%v"><v>%v</v></c>`, ref, v)
+		case exportDecimal:
+			fmt.Fprintf(x.sheet, `<c r="%v"><v>%v</v></c>`, ref, v)
+		case time.Time:
+			fmt.Fprintf(x.sheet, `<c r="%v" s="1"><v>%v</v></c>`, ref, xlsxSerialDate(v))
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%v" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			xml.EscapeText(x.sheet, []byte(formatExportValue(v)))
+			x.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxExportWriter) Write(values []any) error {
+	if x.archive == nil {
+		if err := x.start(); err != nil {
+			return err
+		}
+	}
+	return x.writeRow(values)
+}
+
+func (x *xlsxExportWriter) Close() error {
+	if x.archive == nil {
+		if err := x.start(); err != nil {
+			return err
+		}
+	}
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+// xlsxColumn returns spreadsheet's name of column by its index from zero: A, B, ..., Z, AA, AB, ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSerialDate converts wall clock of time into days since 1899-12-30, spreadsheets' representation of dates
+func xlsxSerialDate(t time.Time) string {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	days := float64(wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC))) / float64(24*time.Hour)
+	return strconv.FormatFloat(days, 'f', -1, 64)
+}
+
+var productExportColumns = []exportColumn[Product]{
+	{"id", func(p *Product) any { return p.Id }},
+	{"sku", func(p *Product) any { return p.Sku }},
+	{"name", func(p *Product) any { return p.Name }},
+	{"description", func(p *Product) any { return p.Description }},
+	{"price", func(p *Product) any { return exportDecimal(p.Price.String()) }},
+	{"currency", func(p *Product) any { return p.Price.Currency }},
+	{"quantity", func(p *Product) any { return p.Quantity }},
+	{"category", func(p *Product) any { return p.Category }},
+	{"tax_class", func(p *Product) any { return p.TaxClass }},
+	{"reorder_point", func(p *Product) any { return p.ReorderPoint }},
+	{"reorder_quantity", func(p *Product) any { return p.ReorderQuantity }},
+}
+
+// ExportProducts streams all not archived products ordered by id
+func (s *Service) ExportProducts(ctx context.Context, w io.Writer, options ExportOptions) error {
+	return exportRows(ctx, s.db, w, options, productExportColumns, `
+	SELECT `+productColumns+` FROM product
+	WHERE product.archived_at IS NULL
+	ORDER BY product.id
+	`)
+}
+
+var customerExportColumns = []exportColumn[Customer]{
+	{"id", func(c *Customer) any { return c.Id }},
+	{"first_name", func(c *Customer) any { return c.FirstName }},
+	{"last_name", func(c *Customer) any { return c.LastName }},
+	{"email", func(c *Customer) any { return c.Email }},
+	{"phone", func(c *Customer) any { return c.Phone }},
+}
+
+// ExportCustomers streams customers ordered by id or, when q is passed, customers found by it from the best match
+func (s *Service) ExportCustomers(ctx context.Context, w io.Writer, options ExportOptions, q string) error {
+	if q != "" {
+		return exportRows(ctx, s.db, w, options, customerExportColumns, `
+		SELECT `+customerColumns+` FROM customer
+		WHERE customer.deleted_at IS NULL AND `+searchCustomersCondition+`
+		ORDER BY `+searchCustomersOrder+`
+		`, searchCustomersArgs(q)...)
+	}
+	return exportRows(ctx, s.db, w, options, customerExportColumns, `
+	SELECT `+customerColumns+` FROM customer
+	WHERE customer.deleted_at IS NULL
+	ORDER BY customer.id
+	`)
+}
+
+// billExportRow is bill's line together with its bill, bill without lines is exported by single row with empty line
+type billExportRow struct {
+	Bill
+	CustomerName  string `db:"customer_name"`
+	CustomerEmail string `db:"customer_email"`
+
+	Product  *int    `db:"line_product"`
+	Sku      *string `db:"line_sku"`
+	Name     *string `db:"line_name"`
+	Quantity *int    `db:"line_quantity"`
+	Price    *int64  `db:"line_price"`
+	TaxRate  *int    `db:"line_tax_rate"`
+}
+
+// exportNullable returns value of nullable column or nil for empty cell
+func exportNullable[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+var billExportColumns = []exportColumn[billExportRow]{
+	{"id", func(b *billExportRow) any { return b.Id }},
+	{"number", func(b *billExportRow) any { return b.Number }},
+	{"created_at", func(b *billExportRow) any { return b.CreatedAt }},
+	{"customer", func(b *billExportRow) any { return b.Customer }},
+	{"customer_name", func(b *billExportRow) any { return b.CustomerName }},
+	{"customer_email", func(b *billExportRow) any { return b.CustomerEmail }},
+	{"status", func(b *billExportRow) any { return b.Status }},
+	{"currency", func(b *billExportRow) any { return b.Currency }},
+	{"total", func(b *billExportRow) any { return exportDecimal(b.Total.String()) }},
+	{"line_product", func(b *billExportRow) any { return exportNullable(b.Product) }},
+	{"line_sku", func(b *billExportRow) any { return exportNullable(b.Sku) }},
+	{"line_name", func(b *billExportRow) any { return exportNullable(b.Name) }},
+	{"line_quantity", func(b *billExportRow) any { return exportNullable(b.Quantity) }},
+	{"line_price", func(b *billExportRow) any {
+		if b.Price == nil {
+			return nil
+		}
+		return exportDecimal(NewMoney(*b.Price, b.Currency).String())
+	}},
+	{"line_tax_rate", func(b *billExportRow) any { return exportNullable(b.TaxRate) }},
+}
+
+// ExportBills streams lines of bills selected by filter like GetBills does, ignoring its cursor and limit.
+// Lines of the same bill follow each other ordered by product's id
+func (s *Service) ExportBills(ctx context.Context, w io.Writer, options ExportOptions, filter BillsFilter) error {
+	if err := validateBillsFilter(filter); err != nil {
+		return err
+	}
+	if filter.Sort == "" {
+		filter.Sort = "-" + BillSortCreatedAt
+	}
+	order, direction, _, err := billsOrder(filter.Sort)
+	if err != nil {
+		return err
+	}
+
+	return exportRows(ctx, s.db, w, options, billExportColumns, `
+	SELECT `+billColumns+`,
+		customer.first_name || ' ' || customer.last_name AS customer_name, COALESCE(customer.email, '') AS customer_email,
+		productbill.product_id AS line_product, product.sku AS line_sku, product.name AS line_name,
+		productbill.quantity AS line_quantity, productbill.price AS line_price, productbill.tax_rate AS line_tax_rate
+	FROM bill
+	JOIN customer ON customer.id = bill.customer_id
+	LEFT JOIN productbill ON productbill.bill_id = bill.id
+	LEFT JOIN product ON product.id = productbill.product_id
+	WHERE `+billsFilterCondition+`
+	ORDER BY `+order.column+` `+direction+`, bill.id `+direction+`, productbill.product_id
+	`, billsFilterArgs(filter)...)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeExport writes rows by selected columns like exportRows does with rows of query
+func writeExport[T any](t *testing.T, format string, columns []exportColumn[T], names []string, rows []T) []byte {
+	columns, err := selectExportColumns(columns, names)
+	if err != nil {
+		t.Fatalf("Cannot select columns: %+v", err)
+	}
+	names = make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+
+	buf := &bytes.Buffer{}
+	writer, err := newExportWriter(format, buf, names)
+	if err != nil {
+		t.Fatalf("Cannot create %v writer: %+v", format, err)
+	}
+	for _, row := range rows {
+		values := make([]any, len(columns))
+		for i, column := range columns {
+			values[i] = column.value(&row)
+		}
+		if err := writer.Write(values); err != nil {
+			t.Fatalf("Cannot write %v row: %+v", format, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Cannot close %v writer: %+v", format, err)
+	}
+	return buf.Bytes()
+}
+
+// xlsxCell is cell of worksheet with either value or inline string
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Style  string `xml:"s,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+// readXLSXSheet returns cells of the first worksheet by their references
+func readXLSXSheet(t *testing.T, file []byte) map[string]xlsxCell {
+	archive, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("Cannot open xlsx: %+v", err)
+	}
+
+	parts := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Cannot open %v: %+v", f.Name, err)
+		}
+		if parts[f.Name], err = io.ReadAll(r); err != nil {
+			t.Fatalf("Cannot read %v: %+v", f.Name, err)
+		}
+		// every part have to be well-formed xml
+		var v struct{}
+		if err := xml.Unmarshal(parts[f.Name], &v); err != nil {
+			t.Errorf("Part %v is not xml: %+v", f.Name, err)
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if parts[name] == nil {
+			t.Errorf("Part %v is missing", name)
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatalf("Cannot parse sheet: %+v", err)
+	}
+	cells := map[string]xlsxCell{}
+	for _, row := range sheet.Rows {
+		for _, cell := range row.Cells {
+			cells[cell.Ref] = cell
+		}
+	}
+	return cells
+}
+
+func TestExportWriters(t *testing.T) {
+	products := []Product{
+		{Id: 1, Sku: "PH-1", Name: `Phone "X", <new>`, Price: NewMoney(99900, "USD"), Quantity: 5},
+		{Id: 2, Name: "Book", Price: NewMoney(1250, "JPY"), Quantity: 1},
+	}
+	names := []string{"id", "name", "price", "currency", "sku"}
+
+	csv := writeExport(t, ExportCSV, productExportColumns, names, products)
+	expected := "id,name,price,currency,sku\n1,\"Phone \"\"X\"\", <new>\",999.00,USD,PH-1\n2,Book,1250,JPY,\n"
+	if string(csv) != expected {
+		t.Errorf("Invalid csv:\n have to be %q\n got %q", expected, csv)
+	}
+
+	ndjson := writeExport(t, ExportNDJSON, productExportColumns, names, products)
+	expected = `{"id":1,"name":"Phone \"X\", <new>","price":"999.00","currency":"USD","sku":"PH-1"}` + "\n" +
+		`{"id":2,"name":"Book","price":"1250","currency":"JPY","sku":""}` + "\n"
+	if string(ndjson) != expected {
+		t.Errorf("Invalid ndjson:\n have to be %q\n got %q", expected, ndjson)
+	}
+
+	cells := readXLSXSheet(t, writeExport(t, ExportXLSX, productExportColumns, names, products))
+	expectedCells := map[string]xlsxCell{
+		"A1": {Ref: "A1", Type: "inlineStr", Inline: "id"},
+		"E1": {Ref: "E1", Type: "inlineStr", Inline: "sku"},
+		"A2": {Ref: "A2", Value: "1"},
+		"B2": {Ref: "B2", Type: "inlineStr", Inline: `Phone "X", <new>`},
+		"C2": {Ref: "C2", Value: "999.00"},
+		"E3": {Ref: "E3", Type: "inlineStr"},
+	}
+	for ref, cell := range expectedCells {
+		if !cmp.Equal(cells[ref], cell) {
+			t.Errorf("Invalid xlsx cell %v: have to be %+v, got %+v", ref, cell, cells[ref])
+		}
+	}
+
+	// Dates are numbers formatted by the second style
+	rows := []billExportRow{{Bill: Bill{Id: 1, CreatedAt: time.Date(2023, 4, 7, 12, 0, 0, 0, time.UTC), Total: NewMoney(500, "USD")}}}
+	cells = readXLSXSheet(t, writeExport(t, ExportXLSX, billExportColumns, []string{"created_at", "line_product"}, rows))
+	if cells["A2"].Value != "45023.5" || cells["A2"].Style != "1" {
+		t.Errorf("Invalid xlsx date: %+v", cells["A2"])
+	}
+	if _, ok := cells["B2"]; ok {
+		t.Errorf("Bill without lines have to have empty line's cells, got %+v", cells["B2"])
+	}
+
+	// Header is written even without rows
+	if csv := writeExport(t, ExportCSV, productExportColumns, []string{"id", "sku"}, nil); string(csv) != "id,sku\n" {
+		t.Errorf("Empty csv have to contain header, got %q", csv)
+	}
+
+	if _, err := selectExportColumns(productExportColumns, []string{"id", "weight"}); err == nil || !strings.Contains(err.Error(), "weight") {
+		t.Errorf("Unknown column have to be reported, got %+v", err)
+	}
+	if _, err := newExportWriter("xml", io.Discard, nil); err == nil {
+		t.Errorf("Unknown format have to be reported")
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if column := xlsxColumn(i); column != expected {
+			t.Errorf("Column %v have to be %v, got %v", i, expected, column)
+		}
+	}
+}
@@ -5,7 +5,6 @@ go 1.19
 require (
 	github.com/caarlos0/env/v7 v7.1.0
 	github.com/go-playground/validator v9.31.0+incompatible
-	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/joho/godotenv v1.5.1
@@ -1,13 +1,18 @@
 package main
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/gorilla/mux"
@@ -40,31 +45,153 @@ func NewHandler(s Service, v *validator.Validate) *Handler {
 }
 
 func (h *Handler) RegisterHandlers(r *mux.Router) {
+	r.Use(auditMiddleware)
+
 	r.HandleFunc("/product", errorHandler(h.handleGetProducts)).Methods("GET")
+	r.HandleFunc("/product/export", errorHandler(h.handleExportProducts)).Methods("GET")
+	r.HandleFunc("/product/low-stock", errorHandler(h.handleGetLowStockProducts)).Methods("GET")
+	r.HandleFunc("/product/archived", errorHandler(h.handleGetArchivedProducts)).Methods("GET")
+	r.HandleFunc("/product/search", errorHandler(h.handleSearchProducts)).Methods("GET")
 	r.HandleFunc("/product/{id}", errorHandler(h.handleGetProductById)).Methods("GET")
+	r.HandleFunc("/product/{id}/related", errorHandler(h.handleGetRelatedProducts)).Methods("GET")
 	r.HandleFunc("/product", errorHandler(h.handleAddProduct)).Methods("POST")
+	r.HandleFunc("/product/import", errorHandler(h.handleImportProducts)).Methods("POST")
 	r.HandleFunc("/product/{id}", errorHandler(h.handleUpdateProductById)).Methods("PATCH")
 	r.HandleFunc("/product/{id}", errorHandler(h.handleDeleteProductById)).Methods("DELETE")
+	r.HandleFunc("/product/{id}/restore", errorHandler(h.handleRestoreProductById)).Methods("POST")
 
 	r.HandleFunc("/customer", errorHandler(h.handleGetCustomers)).Methods("GET")
+	r.HandleFunc("/customer/export", errorHandler(h.handleExportCustomers)).Methods("GET")
 	r.HandleFunc("/customer/{id}", errorHandler(h.handleGetCustomerById)).Methods("GET")
 	r.HandleFunc("/customer", errorHandler(h.handleAddCustomer)).Methods("POST")
 	r.HandleFunc("/customer/{id}", errorHandler(h.handleUpdateCustomerById)).Methods("PATCH")
 	r.HandleFunc("/customer/{id}", errorHandler(h.handleDeleteCustomerById)).Methods("DELETE")
+	r.HandleFunc("/customer/{id}/bills", errorHandler(h.handleGetCustomerBills)).Methods("GET")
+	r.HandleFunc("/customer/{id}/summary", errorHandler(h.handleGetCustomerSummary)).Methods("GET")
+	r.HandleFunc("/customer/{id}/export", errorHandler(h.handleExportCustomer)).Methods("GET")
+	r.HandleFunc("/customer/{id}/erase", errorHandler(h.handleEraseCustomer)).Methods("POST")
+
+	r.HandleFunc("/customer/{id}/address", errorHandler(h.handleGetCustomerAddresses)).Methods("GET")
+	r.HandleFunc("/customer/{id}/address", errorHandler(h.handleAddCustomerAddress)).Methods("POST")
+	r.HandleFunc("/customer/{customer_id}/address/{address_id}", errorHandler(h.handleUpdateCustomerAddressById)).Methods("PATCH")
+	r.HandleFunc("/customer/{customer_id}/address/{address_id}", errorHandler(h.handleDeleteCustomerAddressById)).Methods("DELETE")
 
 	r.HandleFunc("/bill", errorHandler(h.handleGetBills)).Methods("GET")
+	r.HandleFunc("/bill/export", errorHandler(h.handleExportBills)).Methods("GET")
 	r.HandleFunc("/bill/{id}", errorHandler(h.handleGetBillById)).Methods("GET")
+	r.HandleFunc("/bill/by-number/{number}", errorHandler(h.handleGetBillByNumber)).Methods("GET")
+	r.HandleFunc("/bill/{id}/invoice.pdf", errorHandler(h.handleGetBillInvoice)).Methods("GET")
+	r.HandleFunc("/bill/{id}/receipt", errorHandler(h.handleGetBillReceipt)).Methods("GET")
 	r.HandleFunc("/bill", errorHandler(h.handleAddBill)).Methods("POST")
 	r.HandleFunc("/bill/{id}", errorHandler(h.handleUpdateBillById)).Methods("PATCH")
 	r.HandleFunc("/bill/{id}", errorHandler(h.handleDeleteBillById)).Methods("DELETE")
 
 	r.HandleFunc("/bill/{id}/product", errorHandler(h.handleGetBillProducts)).Methods("GET")
 	r.HandleFunc("/bill/{id}/product", errorHandler(h.handleAddProductToBill)).Methods("POST")
+	r.HandleFunc("/bill/{bill_id}/product/{product_id}", errorHandler(h.handleUpdateBillProduct)).Methods("PATCH")
 	r.HandleFunc("/bill/{bill_id}/product/{product_id}", errorHandler(h.handleDeleteProductFromBill)).Methods("DELETE")
+
+	r.HandleFunc("/bill/{id}/payment", errorHandler(h.handleGetBillPayments)).Methods("GET")
+	r.HandleFunc("/bill/{id}/payment", errorHandler(h.handleAddPayment)).Methods("POST")
+	r.HandleFunc("/bill/{bill_id}/payment/{payment_id}", errorHandler(h.handleDeletePayment)).Methods("DELETE")
+
+	r.HandleFunc("/bill/{id}/credit-note", errorHandler(h.handleGetBillCreditNotes)).Methods("GET")
+	r.HandleFunc("/bill/{id}/credit-note", errorHandler(h.handleAddCreditNote)).Methods("POST")
+	r.HandleFunc("/credit-note/{id}", errorHandler(h.handleGetCreditNoteById)).Methods("GET")
+	r.HandleFunc("/credit-note/{id}/refund", errorHandler(h.handleAddRefund)).Methods("POST")
+
+	r.HandleFunc("/cart", errorHandler(h.handleAddCart)).Methods("POST")
+	r.HandleFunc("/cart/{id}", errorHandler(h.handleGetCartById)).Methods("GET")
+	r.HandleFunc("/cart/{id}", errorHandler(h.handleUpdateCartById)).Methods("PATCH")
+	r.HandleFunc("/cart/{id}", errorHandler(h.handleDeleteCartById)).Methods("DELETE")
+	r.HandleFunc("/cart/{id}/item", errorHandler(h.handleAddCartItem)).Methods("POST")
+	r.HandleFunc("/cart/{cart_id}/item/{product_id}", errorHandler(h.handleUpdateCartItem)).Methods("PATCH")
+	r.HandleFunc("/cart/{cart_id}/item/{product_id}", errorHandler(h.handleDeleteCartItem)).Methods("DELETE")
+	r.HandleFunc("/cart/{id}/checkout", errorHandler(h.handleCheckoutCart)).Methods("POST")
+
+	r.HandleFunc("/tax-class", errorHandler(h.handleGetTaxClasses)).Methods("GET")
+	r.HandleFunc("/tax-class/{id}", errorHandler(h.handleGetTaxClassById)).Methods("GET")
+	r.HandleFunc("/tax-class", errorHandler(h.handleAddTaxClass)).Methods("POST")
+	r.HandleFunc("/tax-class/{id}", errorHandler(h.handleDeleteTaxClassById)).Methods("DELETE")
+	r.HandleFunc("/tax-class/{id}/rate", errorHandler(h.handleAddTaxRate)).Methods("POST")
+	r.HandleFunc("/tax-class/{class_id}/rate/{rate_id}", errorHandler(h.handleDeleteTaxRate)).Methods("DELETE")
+
+	r.HandleFunc("/exchange-rate", errorHandler(h.handleGetExchangeRates)).Methods("GET")
+	r.HandleFunc("/exchange-rate", errorHandler(h.handleAddExchangeRate)).Methods("POST")
+	r.HandleFunc("/exchange-rate/import", errorHandler(h.handleImportExchangeRates)).Methods("POST")
+	r.HandleFunc("/exchange-rate/{id}", errorHandler(h.handleDeleteExchangeRateById)).Methods("DELETE")
+
+	r.HandleFunc("/coupon", errorHandler(h.handleGetCoupons)).Methods("GET")
+	r.HandleFunc("/coupon/{id}", errorHandler(h.handleGetCouponById)).Methods("GET")
+	r.HandleFunc("/coupon", errorHandler(h.handleAddCoupon)).Methods("POST")
+	r.HandleFunc("/coupon/{id}", errorHandler(h.handleUpdateCouponById)).Methods("PATCH")
+	r.HandleFunc("/coupon/{id}", errorHandler(h.handleDeleteCouponById)).Methods("DELETE")
+
+	r.HandleFunc("/supplier", errorHandler(h.handleGetSuppliers)).Methods("GET")
+	r.HandleFunc("/supplier/{id}", errorHandler(h.handleGetSupplierById)).Methods("GET")
+	r.HandleFunc("/supplier", errorHandler(h.handleAddSupplier)).Methods("POST")
+	r.HandleFunc("/supplier/{id}", errorHandler(h.handleUpdateSupplierById)).Methods("PATCH")
+	r.HandleFunc("/supplier/{id}", errorHandler(h.handleDeleteSupplierById)).Methods("DELETE")
+
+	r.HandleFunc("/supplier/{id}/product", errorHandler(h.handleGetSupplierProducts)).Methods("GET")
+	r.HandleFunc("/supplier/{id}/product", errorHandler(h.handleSetSupplierProduct)).Methods("POST")
+	r.HandleFunc("/supplier/{supplier_id}/product/{product_id}", errorHandler(h.handleDeleteSupplierProduct)).Methods("DELETE")
+	r.HandleFunc("/supplier/{id}/reorder-suggestion", errorHandler(h.handleGetReorderSuggestion)).Methods("GET")
+
+	r.HandleFunc("/purchase-order", errorHandler(h.handleGetPurchaseOrders)).Methods("GET")
+	r.HandleFunc("/purchase-order/{id}", errorHandler(h.handleGetPurchaseOrderById)).Methods("GET")
+	r.HandleFunc("/purchase-order", errorHandler(h.handleAddPurchaseOrder)).Methods("POST")
+	r.HandleFunc("/purchase-order/{id}", errorHandler(h.handleUpdatePurchaseOrderById)).Methods("PATCH")
+	r.HandleFunc("/purchase-order/{id}", errorHandler(h.handleDeletePurchaseOrderById)).Methods("DELETE")
+	r.HandleFunc("/purchase-order/{id}/order", errorHandler(h.handleOrderPurchaseOrder)).Methods("POST")
+	r.HandleFunc("/purchase-order/{id}/cancel", errorHandler(h.handleCancelPurchaseOrder)).Methods("POST")
+	r.HandleFunc("/purchase-order/{id}/receive", errorHandler(h.handleReceivePurchaseOrder)).Methods("POST")
+
+	r.HandleFunc("/report/sales", errorHandler(h.handleGetSalesReport)).Methods("GET")
+	r.HandleFunc("/report/products/top", errorHandler(h.handleGetTopProductsReport)).Methods("GET")
+	r.HandleFunc("/report/customers/top", errorHandler(h.handleGetTopCustomersReport)).Methods("GET")
+
+	r.HandleFunc("/audit", errorHandler(h.handleGetAuditEntries)).Methods("GET")
+}
+
+// auditHeaderValue is valid actor or request id, they are stored in columns of 100 characters
+var auditHeaderValue = regexp.MustCompile(`^[\p{L}\p{N}._:@-]{1,100}$`)
+
+// auditMiddleware passes actor and id of request to changes made by it. Actor is taken from X-Actor header,
+// request id from X-Request-Id header or generated when absent. Request id is echoed in response.
+// Request with invalid header is rejected before it changes anything
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range []string{"X-Actor", "X-Request-Id"} {
+			if value := r.Header.Get(header); value != "" && !auditHeaderValue.MatchString(value) {
+				writeJSON(w, http.StatusBadRequest, &ApiError{Err: fmt.Sprintf(
+					"%v header have to be at most 100 letters, digits or characters . _ : @ -", header)})
+				return
+			}
+		}
+
+		actor := r.Header.Get("X-Actor")
+		if actor == "" {
+			actor = AuditAnonymousActor
+		}
+		requestId := r.Header.Get("X-Request-Id")
+		if requestId == "" {
+			id := make([]byte, 16)
+			if _, err := rand.Read(id); err != nil {
+				log.Println(err)
+				writeJSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			requestId = hex.EncodeToString(id)
+		}
+
+		w.Header().Set("X-Request-Id", requestId)
+		next.ServeHTTP(w, r.WithContext(WithAuditActor(r.Context(), actor, requestId)))
+	})
 }
 
 func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) error {
-	products, err := h.s.GetProducts(context.TODO())
+	products, err := h.s.GetProducts(r.Context())
 	log.Println(products)
 	if err != nil {
 		return err
@@ -74,6 +201,45 @@ func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) erro
 	return nil
 }
 
+func (h *Handler) handleGetLowStockProducts(w http.ResponseWriter, r *http.Request) error {
+	products, err := h.s.GetLowStockProducts(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, products)
+	return nil
+}
+
+func (h *Handler) handleGetArchivedProducts(w http.ResponseWriter, r *http.Request) error {
+	products, err := h.s.GetArchivedProducts(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, products)
+	return nil
+}
+
+func (h *Handler) handleSearchProducts(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" {
+		return &ApiError{Err: "q query parameter is required"}
+	}
+	limit, offset, err := queryPage(r)
+	if err != nil {
+		return err
+	}
+
+	products, err := h.s.SearchProducts(r.Context(), q, limit, offset)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, products)
+	return nil
+}
+
 func (h *Handler) handleGetProductById(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -81,7 +247,7 @@ func (h *Handler) handleGetProductById(w http.ResponseWriter, r *http.Request) e
 		return &ApiError{Err: "Invalid product's id"}
 	}
 
-	product, err := h.s.GetProductById(context.TODO(), id)
+	product, err := h.s.GetProductById(r.Context(), id)
 	if err != nil {
 		return err
 	}
@@ -90,13 +256,40 @@ func (h *Handler) handleGetProductById(w http.ResponseWriter, r *http.Request) e
 	return nil
 }
 
+func (h *Handler) handleGetRelatedProducts(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = RelatedSortConfidence
+	}
+	limit, err := queryInt(r, "limit", defaultTopLimit)
+	if err != nil {
+		return err
+	}
+	if limit <= 0 || limit > maxPageLimit {
+		return &ApiError{Err: fmt.Sprintf("limit query parameter have to be in range 1..%v", maxPageLimit)}
+	}
+
+	products, err := h.s.GetRelatedProducts(r.Context(), id, sort, limit)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, products)
+	return nil
+}
+
 func (h *Handler) handleAddProduct(w http.ResponseWriter, r *http.Request) error {
 	var dto ProductDTOAdd
 	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
 		return err
 	}
 
-	product, err := h.s.AddProduct(context.TODO(), dto)
+	product, err := h.s.AddProduct(r.Context(), dto)
 	if err != nil {
 		return err
 	}
@@ -105,6 +298,79 @@ func (h *Handler) handleAddProduct(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// importFormats maps content types of imported files to import formats
+var importFormats = map[string]string{
+	"text/csv":             ProductImportCSV,
+	"application/x-ndjson": ProductImportNDJSON,
+	"application/jsonl":    ProductImportNDJSON,
+}
+
+// handleImportProducts streams report of import as rows are processed:
+// {"rows": [row, ...], "summary": summary}
+func (h *Handler) handleImportProducts(w http.ResponseWriter, r *http.Request) error {
+	options := ProductImportOptions{
+		Format: r.URL.Query().Get("format"),
+		Mode:   r.URL.Query().Get("mode"),
+		Validate: func(dto ProductDTOAdd) error {
+			return h.v.Struct(dto)
+		},
+	}
+	if options.Format == "" {
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		options.Format = importFormats[contentType]
+	}
+	if options.Format == "" {
+		return &ApiError{Err: "Import format have to be passed by format query parameter or Content-Type header"}
+	}
+	if options.Mode == "" {
+		options.Mode = ProductImportAllOrNothing
+	}
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return &ApiError{Err: "dry_run query parameter have to be boolean"}
+		}
+		options.DryRun = dryRun
+	}
+
+	// Response is started by the first row, so errors of file's header are still returned as usual
+	encoder := json.NewEncoder(w)
+	started := false
+	start := func() {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"rows":[`)
+		started = true
+	}
+	summary, err := h.s.ImportProducts(r.Context(), r.Body, options, func(row ProductImportRow) error {
+		if started {
+			io.WriteString(w, ",")
+		} else {
+			start()
+		}
+		return encoder.Encode(row)
+	})
+	if err != nil && summary == nil {
+		return err
+	}
+	if err != nil {
+		summary.Error = "Import is aborted by internal error"
+		if apiErr, ok := err.(*ApiError); ok {
+			summary.Error = apiErr.Err
+		} else {
+			log.Println(err)
+		}
+	}
+
+	if !started {
+		start()
+	}
+	io.WriteString(w, `],"summary":`)
+	encoder.Encode(summary)
+	_, err = io.WriteString(w, "}")
+	return err
+}
+
 func (h *Handler) handleUpdateProductById(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -118,7 +384,7 @@ func (h *Handler) handleUpdateProductById(w http.ResponseWriter, r *http.Request
 	}
 	dto.Id = id
 
-	if err := h.s.UpdateProductById(context.TODO(), dto); err != nil {
+	if err := h.s.UpdateProductById(r.Context(), dto); err != nil {
 		return err
 	}
 
@@ -133,7 +399,7 @@ func (h *Handler) handleDeleteProductById(w http.ResponseWriter, r *http.Request
 		return &ApiError{Err: "Invalid product's id"}
 	}
 
-	if err := h.s.DeleteProductById(context.TODO(), id); err != nil {
+	if err := h.s.DeleteProductById(r.Context(), id); err != nil {
 		return err
 	}
 
@@ -141,8 +407,30 @@ func (h *Handler) handleDeleteProductById(w http.ResponseWriter, r *http.Request
 	return nil
 }
 
+func (h *Handler) handleRestoreProductById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+
+	product, err := h.s.RestoreProductById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, product)
+	return nil
+}
+
 func (h *Handler) handleGetCustomers(w http.ResponseWriter, r *http.Request) error {
-	customers, err := h.s.GetCustomers(context.TODO())
+	var customers []Customer
+	var err error
+	if q := r.URL.Query().Get("q"); q != "" {
+		customers, err = h.s.SearchCustomers(r.Context(), q)
+	} else {
+		customers, err = h.s.GetCustomers(r.Context())
+	}
 	if err != nil {
 		return err
 	}
@@ -158,7 +446,7 @@ func (h *Handler) handleGetCustomerById(w http.ResponseWriter, r *http.Request)
 		return &ApiError{Err: "Invalid customer's id"}
 	}
 
-	customer, err := h.s.GetCustomerById(context.TODO(), id)
+	customer, err := h.s.GetCustomerById(r.Context(), id)
 	if err != nil {
 		return err
 	}
@@ -173,7 +461,7 @@ func (h *Handler) handleAddCustomer(w http.ResponseWriter, r *http.Request) erro
 		return err
 	}
 
-	customer, err := h.s.AddCustomer(context.TODO(), dto)
+	customer, err := h.s.AddCustomer(r.Context(), dto)
 	if err != nil {
 		return err
 	}
@@ -195,7 +483,7 @@ func (h *Handler) handleUpdateCustomerById(w http.ResponseWriter, r *http.Reques
 	}
 	dto.Id = id
 
-	if err := h.s.UpdateCustomerById(context.TODO(), dto); err != nil {
+	if err := h.s.UpdateCustomerById(r.Context(), dto); err != nil {
 		return err
 	}
 
@@ -210,7 +498,7 @@ func (h *Handler) handleDeleteCustomerById(w http.ResponseWriter, r *http.Reques
 		return &ApiError{Err: "Invalid customer's id"}
 	}
 
-	if err := h.s.DeleteCustomerById(context.TODO(), id); err != nil {
+	if err := h.s.DeleteCustomerById(r.Context(), id); err != nil {
 		return err
 	}
 
@@ -218,8 +506,57 @@ func (h *Handler) handleDeleteCustomerById(w http.ResponseWriter, r *http.Reques
 	return nil
 }
 
-func (h *Handler) handleGetBills(w http.ResponseWriter, r *http.Request) error {
-	bills, err := h.s.GetBills(context.TODO())
+func (h *Handler) handleExportCustomer(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+
+	export, err := h.s.ExportCustomer(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="customer-%d.json"`, id))
+	writeJSON(w, http.StatusOK, export)
+	return nil
+}
+
+func (h *Handler) handleEraseCustomer(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+
+	if err := h.s.EraseCustomer(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetCustomerBills(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+
+	filter := CustomerBillsFilter{Customer: id}
+	if filter.From, err = queryTime(r, "from"); err != nil {
+		return err
+	}
+	if filter.To, err = queryTime(r, "to"); err != nil {
+		return err
+	}
+	if filter.Limit, filter.Offset, err = queryPage(r); err != nil {
+		return err
+	}
+
+	bills, err := h.s.GetCustomerBills(r.Context(), filter)
 	if err != nil {
 		return err
 	}
@@ -228,51 +565,79 @@ func (h *Handler) handleGetBills(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func (h *Handler) handleGetBillById(w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) handleGetCustomerSummary(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		return &ApiError{Err: "Invalid bill's id"}
+		return &ApiError{Err: "Invalid customer's id"}
 	}
 
-	bill, err := h.s.GetBillById(context.TODO(), id)
+	summary, err := h.s.GetCustomerSummary(r.Context(), id)
 	if err != nil {
 		return err
 	}
 
-	writeJSON(w, http.StatusOK, bill)
+	writeJSON(w, http.StatusOK, summary)
 	return nil
 }
 
-func (h *Handler) handleAddBill(w http.ResponseWriter, r *http.Request) error {
-	var dto BillDTOAdd
+func (h *Handler) handleGetCustomerAddresses(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+
+	addresses, err := h.s.GetCustomerAddresses(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, addresses)
+	return nil
+}
+
+func (h *Handler) handleAddCustomerAddress(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+
+	var dto CustomerAddressDTOAdd
 	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
 		return err
 	}
+	dto.Customer = id
 
-	bill, err := h.s.AddBill(context.TODO(), dto)
+	address, err := h.s.AddCustomerAddress(r.Context(), dto)
 	if err != nil {
 		return err
 	}
 
-	writeJSON(w, http.StatusCreated, bill)
+	writeJSON(w, http.StatusCreated, address)
 	return nil
 }
 
-func (h *Handler) handleUpdateBillById(w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) handleUpdateCustomerAddressById(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	customer_id, err := strconv.Atoi(vars["customer_id"])
 	if err != nil {
-		return &ApiError{Err: "Invalid bill's id"}
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+	address_id, err := strconv.Atoi(vars["address_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid address's id"}
 	}
 
-	var dto BillDTOUpdate
+	var dto CustomerAddressDTOUpdate
 	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
 		return err
 	}
-	dto.Id = id
+	dto.Id = address_id
+	dto.Customer = customer_id
 
-	if err := h.s.UpdateBillById(context.TODO(), dto); err != nil {
+	if err := h.s.UpdateCustomerAddressById(r.Context(), dto); err != nil {
 		return err
 	}
 
@@ -280,14 +645,18 @@ func (h *Handler) handleUpdateBillById(w http.ResponseWriter, r *http.Request) e
 	return nil
 }
 
-func (h *Handler) handleDeleteBillById(w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) handleDeleteCustomerAddressById(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	customer_id, err := strconv.Atoi(vars["customer_id"])
 	if err != nil {
-		return &ApiError{Err: "Invalid bill's id"}
+		return &ApiError{Err: "Invalid customer's id"}
+	}
+	address_id, err := strconv.Atoi(vars["address_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid address's id"}
 	}
 
-	if err := h.s.DeleteBillById(context.TODO(), id); err != nil {
+	if err := h.s.DeleteCustomerAddressById(r.Context(), customer_id, address_id); err != nil {
 		return err
 	}
 
@@ -295,81 +664,1388 @@ func (h *Handler) handleDeleteBillById(w http.ResponseWriter, r *http.Request) e
 	return nil
 }
 
-func (h *Handler) handleAddProductToBill(w http.ResponseWriter, r *http.Request) error {
+// queryBillsFilter parses filters and sort of bills listing
+func queryBillsFilter(r *http.Request) (filter BillsFilter, err error) {
+	query := r.URL.Query()
+	filter = BillsFilter{Status: query.Get("status"), Currency: query.Get("currency"), Sort: query.Get("sort")}
+	if filter.From, err = queryTime(r, "from"); err != nil {
+		return
+	}
+	if filter.To, err = queryTime(r, "to"); err != nil {
+		return
+	}
+	if filter.Customer, err = queryInt(r, "customer", 0); err != nil {
+		return
+	}
+	if filter.MinTotal, err = queryInt64(r, "min_total"); err != nil {
+		return
+	}
+	if filter.MaxTotal, err = queryInt64(r, "max_total"); err != nil {
+		return
+	}
+	switch filter.Status {
+	case "", BillOpen, BillPartiallyPaid, BillPaid, BillVoid:
+	default:
+		err = &ApiError{Err: fmt.Sprintf("Unknown status %q, expected %v, %v, %v or %v", filter.Status,
+			BillOpen, BillPartiallyPaid, BillPaid, BillVoid)}
+	}
+	return
+}
+
+func (h *Handler) handleGetBills(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	filter, err := queryBillsFilter(r)
+	if err != nil {
+		return err
+	}
+	filter.Cursor = query.Get("cursor")
+	if filter.Limit, err = queryInt(r, "limit", defaultPageLimit); err != nil {
+		return err
+	}
+	if filter.Limit <= 0 || filter.Limit > maxPageLimit {
+		return &ApiError{Err: fmt.Sprintf("limit query parameter have to be in range 1..%v", maxPageLimit)}
+	}
+
+	var expand BillExpand
+	if value := query.Get("expand"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			switch part {
+			case "customer":
+				expand.Customer = true
+			case "lines":
+				expand.Lines = true
+			default:
+				return &ApiError{Err: fmt.Sprintf("Unknown expand %q, expected customer or lines", part)}
+			}
+		}
+	}
+
+	var bills any
+	var next string
+	if expand.Customer || expand.Lines {
+		bills, next, err = h.s.GetBillsVerbose(r.Context(), filter, expand)
+	} else {
+		bills, next, err = h.s.GetBills(r.Context(), filter)
+	}
+	if err != nil {
+		return err
+	}
+
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	writeJSON(w, http.StatusOK, bills)
+	return nil
+}
+
+func (h *Handler) handleGetBillById(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		return &ApiError{Err: "Invalid bill's id"}
 	}
 
-	var dto BillDtoAddProduct
-	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+	bill, err := h.s.GetBillById(r.Context(), id)
+	if err != nil {
 		return err
 	}
-	dto.Id = id
 
-	err = h.s.AddProductToBill(context.TODO(), dto)
+	writeJSON(w, http.StatusOK, bill)
+	return nil
+}
+
+func (h *Handler) handleGetBillByNumber(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	bill, err := h.s.GetBillByNumber(r.Context(), vars["number"])
 	if err != nil {
 		return err
 	}
 
-	writeJSON(w, http.StatusCreated, nil)
+	writeJSON(w, http.StatusOK, bill)
 	return nil
 }
 
-func (h *Handler) handleGetBillProducts(w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) handleGetBillInvoice(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		return &ApiError{Err: "Invalid bill's id"}
 	}
 
-	products, err := h.s.GetBillProducts(context.TODO(), id)
+	bill, pdf, err := h.s.RenderInvoice(r.Context(), id)
 	if err != nil {
 		return err
 	}
 
-	writeJSON(w, http.StatusOK, products)
-	return nil
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", bill.Number+".pdf"))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(pdf)
+	return err
 }
 
-func (h *Handler) handleDeleteProductFromBill(w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) handleGetBillReceipt(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
-	bill_id, err := strconv.Atoi(vars["bill_id"])
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		return &ApiError{Err: "Invalid bill's id"}
 	}
-	product_id, err := strconv.Atoi(vars["product_id"])
-	if err != nil {
-		return &ApiError{Err: "Invalid product's id"}
+
+	contentType := negotiateContentType(r.Header.Get("Accept"), ReceiptText, ReceiptHTML)
+	if contentType == "" {
+		return writeJSON(w, http.StatusNotAcceptable, &ApiError{Err: "Receipt is available as text/plain or text/html"})
 	}
 
-	if err := h.s.DeleteProductFromBill(context.TODO(), bill_id, product_id); err != nil {
+	receipt, err := h.s.RenderReceipt(r.Context(), id, contentType)
+	if err != nil {
 		return err
 	}
 
-	writeJSON(w, http.StatusOK, nil)
-	return nil
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(receipt)
+	return err
 }
 
-func decodeAndValidate[T any](object T, r io.Reader, v *validator.Validate, addFields func(object T) error) error {
-	if err := json.NewDecoder(r).Decode(object); err != nil {
-		return &ApiError{Err: "Cannot parse json data"}
-	}
-
-	if addFields != nil {
-		if err := addFields(object); err != nil {
-			return err
-		}
+func (h *Handler) handleAddBill(w http.ResponseWriter, r *http.Request) error {
+	var dto BillDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
 	}
 
-	if err := v.Struct(object); err != nil {
-		return &ApiError{Err: fmt.Sprintf("Invalid passed data err: %v", err.Error())}
+	bill, err := h.s.AddBill(r.Context(), dto)
+	if err != nil {
+		return err
 	}
 
+	writeJSON(w, http.StatusCreated, bill)
 	return nil
 }
 
+func (h *Handler) handleUpdateBillById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	var dto BillDTOUpdate
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	if err := h.s.UpdateBillById(r.Context(), dto); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleDeleteBillById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	if err := h.s.DeleteBillById(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleAddProductToBill(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	var dto BillDtoAddProduct
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	err = h.s.AddProductToBill(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, nil)
+	return nil
+}
+
+func (h *Handler) handleGetBillProducts(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	products, err := h.s.GetBillProducts(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, products)
+	return nil
+}
+
+func (h *Handler) handleUpdateBillProduct(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	bill_id, err := strconv.Atoi(vars["bill_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+	product_id, err := strconv.Atoi(vars["product_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+
+	var dto BillDTOUpdateProduct
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Bill, dto.Product = bill_id, product_id
+
+	line, err := h.s.UpdateBillProduct(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, line)
+	return nil
+}
+
+func (h *Handler) handleDeleteProductFromBill(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	bill_id, err := strconv.Atoi(vars["bill_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+	product_id, err := strconv.Atoi(vars["product_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+
+	if err := h.s.DeleteProductFromBill(r.Context(), bill_id, product_id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetBillPayments(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	payments, err := h.s.GetBillPayments(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, payments)
+	return nil
+}
+
+func (h *Handler) handleAddPayment(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	var dto PaymentDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Bill = id
+
+	payment, err := h.s.AddPayment(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, payment)
+	return nil
+}
+
+func (h *Handler) handleDeletePayment(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	bill_id, err := strconv.Atoi(vars["bill_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+	payment_id, err := strconv.Atoi(vars["payment_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid payment's id"}
+	}
+
+	if err := h.s.DeletePayment(r.Context(), bill_id, payment_id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetBillCreditNotes(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	notes, err := h.s.GetBillCreditNotes(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, notes)
+	return nil
+}
+
+func (h *Handler) handleAddCreditNote(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid bill's id"}
+	}
+
+	var dto CreditNoteDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Bill = id
+
+	note, err := h.s.AddCreditNote(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, note)
+	return nil
+}
+
+func (h *Handler) handleGetCreditNoteById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid credit note's id"}
+	}
+
+	note, err := h.s.GetCreditNoteById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, note)
+	return nil
+}
+
+func (h *Handler) handleAddRefund(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid credit note's id"}
+	}
+
+	var dto RefundDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.CreditNote = id
+
+	refund, err := h.s.AddRefund(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, refund)
+	return nil
+}
+
+func (h *Handler) handleGetTaxClasses(w http.ResponseWriter, r *http.Request) error {
+	classes, err := h.s.GetTaxClasses(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, classes)
+	return nil
+}
+
+func (h *Handler) handleGetTaxClassById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid tax class's id"}
+	}
+
+	class, err := h.s.GetTaxClassById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, class)
+	return nil
+}
+
+func (h *Handler) handleAddTaxClass(w http.ResponseWriter, r *http.Request) error {
+	var dto TaxClassDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+
+	class, err := h.s.AddTaxClass(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, class)
+	return nil
+}
+
+func (h *Handler) handleDeleteTaxClassById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid tax class's id"}
+	}
+
+	if err := h.s.DeleteTaxClassById(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleAddTaxRate(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid tax class's id"}
+	}
+
+	var dto TaxRateDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.TaxClass = id
+
+	rate, err := h.s.AddTaxRate(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, rate)
+	return nil
+}
+
+func (h *Handler) handleDeleteTaxRate(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	class_id, err := strconv.Atoi(vars["class_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid tax class's id"}
+	}
+	rate_id, err := strconv.Atoi(vars["rate_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid tax rate's id"}
+	}
+
+	if err := h.s.DeleteTaxRate(r.Context(), class_id, rate_id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetExchangeRates(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	rates, err := h.s.GetExchangeRates(r.Context(), query.Get("base"), query.Get("quote"))
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, rates)
+	return nil
+}
+
+func (h *Handler) handleAddExchangeRate(w http.ResponseWriter, r *http.Request) error {
+	var dto ExchangeRateDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+
+	rate, err := h.s.AddExchangeRate(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, rate)
+	return nil
+}
+
+func (h *Handler) handleImportExchangeRates(w http.ResponseWriter, r *http.Request) error {
+	result, err := h.s.ImportExchangeRates(r.Context(), r.Body)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, result)
+	return nil
+}
+
+func (h *Handler) handleDeleteExchangeRateById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid exchange rate's id"}
+	}
+
+	if err := h.s.DeleteExchangeRateById(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetCoupons(w http.ResponseWriter, r *http.Request) error {
+	coupons, err := h.s.GetCoupons(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, coupons)
+	return nil
+}
+
+func (h *Handler) handleGetCouponById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid coupon's id"}
+	}
+
+	coupon, err := h.s.GetCouponById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, coupon)
+	return nil
+}
+
+func (h *Handler) handleAddCoupon(w http.ResponseWriter, r *http.Request) error {
+	var dto CouponDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+
+	coupon, err := h.s.AddCoupon(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, coupon)
+	return nil
+}
+
+func (h *Handler) handleUpdateCouponById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid coupon's id"}
+	}
+
+	var dto CouponDTOUpdate
+	if err := decodeAndValidate(&dto.CouponDTOAdd, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	if err := h.s.UpdateCouponById(r.Context(), dto); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleDeleteCouponById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid coupon's id"}
+	}
+
+	if err := h.s.DeleteCouponById(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetSuppliers(w http.ResponseWriter, r *http.Request) error {
+	suppliers, err := h.s.GetSuppliers(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, suppliers)
+	return nil
+}
+
+func (h *Handler) handleGetSupplierById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+
+	supplier, err := h.s.GetSupplierById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, supplier)
+	return nil
+}
+
+func (h *Handler) handleAddSupplier(w http.ResponseWriter, r *http.Request) error {
+	var dto SupplierDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+
+	supplier, err := h.s.AddSupplier(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, supplier)
+	return nil
+}
+
+func (h *Handler) handleUpdateSupplierById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+
+	var dto SupplierDTOUpdate
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	if err := h.s.UpdateSupplierById(r.Context(), dto); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleDeleteSupplierById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+
+	if err := h.s.DeleteSupplierById(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetSupplierProducts(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+
+	products, err := h.s.GetSupplierProducts(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, products)
+	return nil
+}
+
+func (h *Handler) handleSetSupplierProduct(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+
+	var dto SupplierDTOSetProduct
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	if err := h.s.SetSupplierProduct(r.Context(), dto); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleDeleteSupplierProduct(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	supplier_id, err := strconv.Atoi(vars["supplier_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+	product_id, err := strconv.Atoi(vars["product_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+
+	if err := h.s.DeleteSupplierProduct(r.Context(), supplier_id, product_id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleGetReorderSuggestion(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid supplier's id"}
+	}
+
+	suggestion, err := h.s.GetReorderSuggestion(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, suggestion)
+	return nil
+}
+
+func (h *Handler) handleGetPurchaseOrders(w http.ResponseWriter, r *http.Request) error {
+	orders, err := h.s.GetPurchaseOrders(r.Context())
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, orders)
+	return nil
+}
+
+func (h *Handler) handleGetPurchaseOrderById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid purchase order's id"}
+	}
+
+	order, err := h.s.GetPurchaseOrderById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, order)
+	return nil
+}
+
+func (h *Handler) handleAddPurchaseOrder(w http.ResponseWriter, r *http.Request) error {
+	var dto PurchaseOrderDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+
+	order, err := h.s.AddPurchaseOrder(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, order)
+	return nil
+}
+
+func (h *Handler) handleUpdatePurchaseOrderById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid purchase order's id"}
+	}
+
+	var dto PurchaseOrderDTOUpdate
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	if err := h.s.UpdatePurchaseOrderById(r.Context(), dto); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleDeletePurchaseOrderById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid purchase order's id"}
+	}
+
+	if err := h.s.DeletePurchaseOrderById(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleOrderPurchaseOrder(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid purchase order's id"}
+	}
+
+	if err := h.s.OrderPurchaseOrder(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleCancelPurchaseOrder(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid purchase order's id"}
+	}
+
+	if err := h.s.CancelPurchaseOrder(r.Context(), id); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid purchase order's id"}
+	}
+
+	var dto PurchaseOrderDTOReceive
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id = id
+
+	order, err := h.s.ReceivePurchaseOrder(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, order)
+	return nil
+}
+
+func (h *Handler) handleAddCart(w http.ResponseWriter, r *http.Request) error {
+	var dto CartDTOAdd
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+
+	cart, err := h.s.AddCart(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, cart)
+	return nil
+}
+
+func (h *Handler) handleGetCartById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+
+	cart, err := h.s.GetCartById(r.Context(), id, cartToken(r))
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, cart)
+	return nil
+}
+
+func (h *Handler) handleUpdateCartById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+
+	var dto CartDTOUpdate
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Id, dto.Token = id, cartToken(r)
+
+	if err := h.s.UpdateCartById(r.Context(), dto); err != nil {
+		return err
+	}
+
+	return h.writeCart(w, r, id)
+}
+
+func (h *Handler) handleDeleteCartById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+
+	if err := h.s.DeleteCartById(r.Context(), id, cartToken(r)); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+	return nil
+}
+
+func (h *Handler) handleAddCartItem(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+
+	var dto CartItemDTO
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Cart, dto.Token = id, cartToken(r)
+
+	if err := h.s.AddCartItem(r.Context(), dto); err != nil {
+		return err
+	}
+
+	return h.writeCart(w, r, id)
+}
+
+func (h *Handler) handleUpdateCartItem(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	cart_id, err := strconv.Atoi(vars["cart_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+	product_id, err := strconv.Atoi(vars["product_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+
+	// product is taken from path
+	dto := CartItemDTO{Product: product_id}
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Cart, dto.Product, dto.Token = cart_id, product_id, cartToken(r)
+
+	if err := h.s.UpdateCartItem(r.Context(), dto); err != nil {
+		return err
+	}
+
+	return h.writeCart(w, r, cart_id)
+}
+
+func (h *Handler) handleDeleteCartItem(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	cart_id, err := strconv.Atoi(vars["cart_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+	product_id, err := strconv.Atoi(vars["product_id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid product's id"}
+	}
+
+	if err := h.s.DeleteCartItem(r.Context(), cart_id, product_id, cartToken(r)); err != nil {
+		return err
+	}
+
+	return h.writeCart(w, r, cart_id)
+}
+
+// cartToken returns token of cart passed by X-Cart-Token header
+func cartToken(r *http.Request) string {
+	return r.Header.Get("X-Cart-Token")
+}
+
+// writeCart responds with preview of changed cart
+func (h *Handler) writeCart(w http.ResponseWriter, r *http.Request, id int) error {
+	cart, err := h.s.GetCartById(r.Context(), id, cartToken(r))
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, cart)
+	return nil
+}
+
+func (h *Handler) handleCheckoutCart(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return &ApiError{Err: "Invalid cart's id"}
+	}
+
+	var dto CartCheckoutDTO
+	if err := decodeAndValidate(&dto, r.Body, h.v, nil); err != nil {
+		return err
+	}
+	dto.Cart, dto.Token = id, cartToken(r)
+
+	bill, err := h.s.CheckoutCart(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, bill)
+	return nil
+}
+
+// queryReport parses date range and limit of report and its format, which is json or csv
+func queryReport(r *http.Request) (filter ReportFilter, format string, err error) {
+	if filter.From, err = queryTime(r, "from"); err != nil {
+		return
+	}
+	if filter.To, err = queryTime(r, "to"); err != nil {
+		return
+	}
+	if filter.From != nil && filter.To != nil && !filter.To.After(*filter.From) {
+		err = &ApiError{Err: "to query parameter have to be after from"}
+		return
+	}
+	if filter.Limit, err = queryInt(r, "limit", defaultTopLimit); err != nil {
+		return
+	}
+	if filter.Limit <= 0 || filter.Limit > maxPageLimit {
+		err = &ApiError{Err: fmt.Sprintf("limit query parameter have to be in range 1..%v", maxPageLimit)}
+		return
+	}
+
+	switch format = r.URL.Query().Get("format"); format {
+	case "":
+		format = "json"
+	case "json", ExportCSV:
+	default:
+		err = &ApiError{Err: fmt.Sprintf("Unknown report format %q, expected json or csv", format)}
+	}
+	return
+}
+
+// writeReport writes rows of report as json or as csv file named after report
+func writeReport[T any](w http.ResponseWriter, format, name string, columns []exportColumn[T], rows []T) error {
+	if format != ExportCSV {
+		writeJSON(w, http.StatusOK, rows)
+		return nil
+	}
+	return writeReportCSV(&exportResponse{ResponseWriter: w, format: ExportCSV, name: name}, columns, rows)
+}
+
+func (h *Handler) handleGetSalesReport(w http.ResponseWriter, r *http.Request) error {
+	filter, format, err := queryReport(r)
+	if err != nil {
+		return err
+	}
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		group = ReportDay
+	}
+
+	rows, err := h.s.GetSalesReport(r.Context(), filter, group)
+	if err != nil {
+		return err
+	}
+
+	return writeReport(w, format, "sales", salesReportColumns, rows)
+}
+
+func (h *Handler) handleGetTopProductsReport(w http.ResponseWriter, r *http.Request) error {
+	filter, format, err := queryReport(r)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.s.GetTopProductsReport(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	return writeReport(w, format, "top-products", topProductReportColumns, rows)
+}
+
+func (h *Handler) handleGetTopCustomersReport(w http.ResponseWriter, r *http.Request) error {
+	filter, format, err := queryReport(r)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.s.GetTopCustomersReport(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	return writeReport(w, format, "top-customers", topCustomerReportColumns, rows)
+}
+
+func (h *Handler) handleGetAuditEntries(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Entity:    query.Get("entity"),
+		Actor:     query.Get("actor"),
+		Action:    query.Get("action"),
+		RequestId: query.Get("request_id"),
+	}
+	var err error
+	if filter.EntityId, err = queryInt(r, "entity_id", 0); err != nil {
+		return err
+	}
+	if filter.From, err = queryTime(r, "from"); err != nil {
+		return err
+	}
+	if filter.To, err = queryTime(r, "to"); err != nil {
+		return err
+	}
+	if filter.Limit, filter.Offset, err = queryPage(r); err != nil {
+		return err
+	}
+
+	entries, err := h.s.GetAuditEntries(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+	return nil
+}
+
+func decodeAndValidate[T any](object T, r io.Reader, v *validator.Validate, addFields func(object T) error) error {
+	if err := json.NewDecoder(r).Decode(object); err != nil {
+		return &ApiError{Err: "Cannot parse json data"}
+	}
+
+	if addFields != nil {
+		if err := addFields(object); err != nil {
+			return err
+		}
+	}
+
+	if err := v.Struct(object); err != nil {
+		return &ApiError{Err: fmt.Sprintf("Invalid passed data err: %v", err.Error())}
+	}
+
+	return nil
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	// defaultTopLimit is number of rows of top reports unless limit is passed
+	defaultTopLimit = 10
+)
+
+// queryInt parses integer query parameter, returning def when it's absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &ApiError{Err: fmt.Sprintf("Invalid %v query parameter", name)}
+	}
+	return i, nil
+}
+
+// queryInt64 parses optional integer query parameter, returning nil when it's absent
+func queryInt64(r *http.Request, name string) (*int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, &ApiError{Err: fmt.Sprintf("Invalid %v query parameter", name)}
+	}
+	return &i, nil
+}
+
+// queryTime parses query parameter passed either as date (2006-01-02) or RFC 3339 timestamp
+func queryTime(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, &ApiError{Err: fmt.Sprintf("Invalid %v query parameter, expected date or RFC 3339 timestamp", name)}
+}
+
+// queryPage parses limit and offset query parameters
+func queryPage(r *http.Request) (limit, offset int, err error) {
+	if limit, err = queryInt(r, "limit", defaultPageLimit); err != nil {
+		return
+	}
+	if offset, err = queryInt(r, "offset", 0); err != nil {
+		return
+	}
+	if limit <= 0 || limit > maxPageLimit {
+		err = &ApiError{Err: fmt.Sprintf("limit query parameter have to be in range 1..%v", maxPageLimit)}
+	} else if offset < 0 {
+		err = &ApiError{Err: "offset query parameter cannot be negative"}
+	}
+	return
+}
+
+// exportResponse starts response of exported file by its first write,
+// so errors found before any row is written are still returned as usual
+type exportResponse struct {
+	http.ResponseWriter
+	format, name string
+	started      bool
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.Header().Set("Content-Type", exportContentTypes[e.format])
+		e.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.name+"."+e.format))
+		e.WriteHeader(http.StatusOK)
+	}
+	return e.ResponseWriter.Write(p)
+}
+
+// export streams file written by passed export, failure after file is started can only be logged
+// and interrupts response
+func (h *Handler) export(w http.ResponseWriter, r *http.Request, name string, export func(w io.Writer, options ExportOptions) error) error {
+	options := ExportOptions{Format: r.URL.Query().Get("format")}
+	if options.Format == "" {
+		options.Format = ExportCSV
+	}
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		options.Columns = strings.Split(columns, ",")
+	}
+
+	response := &exportResponse{ResponseWriter: w, format: options.Format, name: name}
+	if err := export(response, options); err != nil {
+		if !response.started {
+			return err
+		}
+		log.Println(err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+func (h *Handler) handleExportProducts(w http.ResponseWriter, r *http.Request) error {
+	return h.export(w, r, "products", func(w io.Writer, options ExportOptions) error {
+		return h.s.ExportProducts(r.Context(), w, options)
+	})
+}
+
+func (h *Handler) handleExportCustomers(w http.ResponseWriter, r *http.Request) error {
+	return h.export(w, r, "customers", func(w io.Writer, options ExportOptions) error {
+		return h.s.ExportCustomers(r.Context(), w, options, r.URL.Query().Get("q"))
+	})
+}
+
+func (h *Handler) handleExportBills(w http.ResponseWriter, r *http.Request) error {
+	filter, err := queryBillsFilter(r)
+	if err != nil {
+		return err
+	}
+	return h.export(w, r, "bills", func(w io.Writer, options ExportOptions) error {
+		return h.s.ExportBills(r.Context(), w, options, filter)
+	})
+}
+
+// negotiateContentType returns offered content type most preferred by Accept header, the first offer wins ties.
+// Quality of offer is taken from the most specific media range matching it. Empty result means that no offer is acceptable
+func negotiateContentType(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		quality := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(params[0]))] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		offerType, _, _ := strings.Cut(offer, "/")
+		for _, mediaRange := range []string{offer, offerType + "/*", "*/*"} {
+			if quality, ok := qualities[mediaRange]; ok {
+				if quality > bestQuality {
+					best, bestQuality = offer, quality
+				}
+				break
+			}
+		}
+	}
+	return best
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) error {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// DejaVu fonts are distributed under Bitstream Vera license, see fonts/LICENSE
+//
+//go:embed fonts/DejaVuSans.ttf fonts/DejaVuSans-Bold.ttf
+var fontFiles embed.FS
+
+var (
+	loadFontsOnce         sync.Once
+	regularFont, boldFont *trueTypeFont
+	errLoadFonts          error
+)
+
+// loadFonts parses embedded fonts once, they are shared by all rendered documents
+func loadFonts() (regular, bold *trueTypeFont, err error) {
+	loadFontsOnce.Do(func() {
+		load := func(name, file string) *trueTypeFont {
+			data, err := fontFiles.ReadFile(file)
+			if err == nil {
+				var font *trueTypeFont
+				if font, err = parseTrueType(name, data); err == nil {
+					return font
+				}
+			}
+			errLoadFonts = fmt.Errorf("cannot load font %v: %w", file, err)
+			return nil
+		}
+		regularFont = load("DejaVuSans", "fonts/DejaVuSans.ttf")
+		boldFont = load("DejaVuSans-Bold", "fonts/DejaVuSans-Bold.ttf")
+	})
+	return regularFont, boldFont, errLoadFonts
+}
+
+// readTemplate returns template with passed name from configured templates directory,
+// templates missing there are taken from embedded defaults
+func (s *Service) readTemplate(name string) (string, error) {
+	if dir := s.config.Templates.Path; dir != "" {
+		buf, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return string(buf), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	buf, err := defaultTemplates.ReadFile("templates/" + name)
+	return string(buf), err
+}
+
+// Seller is issuer of bills printed on invoices
+type Seller struct {
+	Name    string
+	Address string
+	TaxId   string
+	Email   string
+	Phone   string
+}
+
+// billDocument is data bill's documents are rendered from
+type billDocument struct {
+	Seller Seller
+	Bill   *BillVerbose
+}
+
+var documentFuncs = template.FuncMap{
+	// inc numbers lines from one
+	"inc": func(i int) int {
+		return i + 1
+	},
+	"money": func(m Money) string {
+		return m.String() + " " + m.Currency
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+	// percent formats tax rate in hundredths of percent, e.g. 550 as 5.5%
+	"percent": func(rate int) string {
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%d.%02d", rate/100, rate%100), "0"), ".") + "%"
+	},
+	"neg": func(m Money) Money {
+		return NewMoney(-m.Amount, m.Currency)
+	},
+}
+
+// layoutFuncs are available to templates producing PDF layout
+var layoutFuncs = template.FuncMap{
+	// escape inserts value into layout literally
+	"escape": escapeLayout,
+}
+
+func (s *Service) billDocument(ctx context.Context, id int) (*billDocument, error) {
+	bill, err := s.GetBillById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &billDocument{Seller: Seller(s.config.Seller), Bill: bill}, nil
+}
+
+// RenderInvoice renders bill's invoice as PDF by invoice.tmpl template, see renderPDFLayout for its format
+func (s *Service) RenderInvoice(ctx context.Context, id int) (*BillVerbose, []byte, error) {
+	document, err := s.billDocument(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	text, err := s.readTemplate("invoice.tmpl")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pdf, err := renderInvoice(text, document)
+	if err != nil {
+		return nil, nil, err
+	}
+	return document.Bill, pdf, nil
+}
+
+func renderInvoice(text string, document *billDocument) ([]byte, error) {
+	tmpl, err := template.New("invoice.tmpl").Funcs(documentFuncs).Funcs(layoutFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	layout := &bytes.Buffer{}
+	if err := tmpl.Execute(layout, document); err != nil {
+		return nil, err
+	}
+
+	regular, bold, err := loadFonts()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := renderPDFLayout("Invoice "+document.Bill.Number, layout.String(), regular, bold)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := &bytes.Buffer{}
+	if _, err := doc.WriteTo(pdf); err != nil {
+		return nil, err
+	}
+	return pdf.Bytes(), nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	pdfObject     = regexp.MustCompile(`(?s)(\d+) 0 obj\n(.*?)\nendobj`)
+	pdfStreamData = regexp.MustCompile(`(?s)^(<<.*?>>)\nstream\n(.*)\nendstream$`)
+	pdfReference  = regexp.MustCompile(`/(\w+) (\d+) 0 R`)
+	pdfBfchar     = regexp.MustCompile(`<([0-9A-F]{4})> <([0-9A-F]+)>`)
+	pdfTextOp     = regexp.MustCompile(`/(F\d+) [\d.]+ Tf|<([0-9A-F]*)> Tj`)
+)
+
+// extractPDFText returns text drawn by Tj operators of PDF's pages, decoding glyphs by fonts' ToUnicode maps
+func extractPDFText(t *testing.T, pdf []byte) []string {
+	objects := map[string][]byte{}
+	dicts := map[string]string{}
+	for _, match := range pdfObject.FindAllSubmatch(pdf, -1) {
+		id, body := string(match[1]), match[2]
+		dicts[id] = string(body)
+
+		// xref have to point to every object
+		if !bytes.Contains(pdf, []byte(fmtOffset(bytes.Index(pdf, match[0])))) {
+			t.Errorf("Object %v is not in xref", id)
+		}
+		if stream := pdfStreamData.FindSubmatch(body); stream != nil {
+			dicts[id] = string(stream[1])
+			r, err := zlib.NewReader(bytes.NewReader(stream[2]))
+			if err != nil {
+				t.Fatalf("Cannot inflate stream %v: %+v", id, err)
+			}
+			if objects[id], err = io.ReadAll(r); err != nil {
+				t.Fatalf("Cannot inflate stream %v: %+v", id, err)
+			}
+		}
+	}
+
+	var text []string
+	for id, dict := range dicts {
+		if !strings.Contains(dict, "/Type /Page ") {
+			continue
+		}
+
+		toUnicode := map[string]map[string]string{}
+		var contents string
+		for _, ref := range pdfReference.FindAllStringSubmatch(dict, -1) {
+			if ref[1] == "Contents" {
+				contents = ref[2]
+				continue
+			}
+			for _, fontRef := range pdfReference.FindAllStringSubmatch(dicts[ref[2]], -1) {
+				if fontRef[1] == "ToUnicode" {
+					toUnicode[ref[1]] = map[string]string{}
+					for _, pair := range pdfBfchar.FindAllStringSubmatch(string(objects[fontRef[2]]), -1) {
+						toUnicode[ref[1]][pair[1]] = pair[2]
+					}
+				}
+			}
+		}
+		if contents == "" {
+			t.Fatalf("Page %v has no contents", id)
+		}
+
+		var font string
+		for _, op := range pdfTextOp.FindAllStringSubmatch(string(objects[contents]), -1) {
+			if op[1] != "" {
+				font = op[1]
+				continue
+			}
+			var units []uint16
+			for i := 0; i+4 <= len(op[2]); i += 4 {
+				mapped, ok := toUnicode[font][op[2][i:i+4]]
+				if !ok {
+					t.Errorf("Glyph %v of font %v is not mapped to unicode", op[2][i:i+4], font)
+					continue
+				}
+				buf, _ := hex.DecodeString(mapped)
+				for j := 0; j+2 <= len(buf); j += 2 {
+					units = append(units, binary.BigEndian.Uint16(buf[j:]))
+				}
+			}
+			text = append(text, string(utf16.Decode(units)))
+		}
+	}
+	return text
+}
+
+func fmtOffset(offset int) string {
+	s := strconv.Itoa(offset)
+	return strings.Repeat("0", 10-len(s)) + s + " 00000 n"
+}
+
+// testBillDocument returns document of bill with non-ASCII text and two tax rates
+func testBillDocument() *billDocument {
+	lines := []BillLine{
+		{Product: 1, Name: "Café crème", Quantity: 2, Price: NewMoney(450, "EUR"), TaxRate: 2000},
+		{Product: 2, Name: "Cookbook", Quantity: 1, Price: NewMoney(1999, "EUR"), TaxRate: 550},
+	}
+	totals := calculateBillTotals(lines, nil, 0, "EUR")
+	totals = applyTaxes(totals, lines, nil, TaxExclusive)
+	return &billDocument{
+		Seller: Seller{Name: "Gadget Shop Ltd.", Address: "1 Market Square, Springfield", TaxId: "US123456789"},
+		Bill: &BillVerbose{
+			Number:    "INV-2023-000042",
+			CreatedAt: time.Date(2023, 4, 7, 12, 0, 0, 0, time.UTC),
+			Customer:  Customer{FirstName: "Zoë", LastName: "Łukasiewicz", Email: "zoe@example.com"},
+			Currency:  "EUR",
+			Products:  lines,
+			Totals:    &totals,
+			BillingAddress: &AddressSnapshot{
+				Line1: "12 Main Street", City: "Springfield", PostalCode: "62701", Country: "US",
+			},
+		},
+	}
+}
+
+func TestRenderInvoice(t *testing.T) {
+	text, err := defaultTemplates.ReadFile("templates/invoice.tmpl")
+	if err != nil {
+		t.Fatalf("Cannot read default template: %+v", err)
+	}
+	pdf, err := renderInvoice(string(text), testBillDocument())
+	if err != nil {
+		t.Fatalf("Cannot render invoice: %+v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Errorf("Rendered invoice is not PDF")
+	}
+
+	extracted := strings.Join(extractPDFText(t, pdf), "\n")
+	for _, expected := range []string{
+		"Invoice INV-2023-000042",
+		"Date: 2023-04-07",
+		"Gadget Shop Ltd.",
+		"Tax ID: US123456789",
+		"Zoë Łukasiewicz",
+		"62701 Springfield, US",
+		"1\nCafé crème\n2\n4.50 EUR\n20%\n9.00 EUR",
+		"2\nCookbook\n1\n19.99 EUR\n5.5%\n19.99 EUR",
+		"Subtotal\n28.99 EUR",
+		"Tax 5.5% of 19.99 EUR\n1.10 EUR",
+		"Tax 20% of 9.00 EUR\n1.80 EUR",
+		"Total\n31.89 EUR",
+	} {
+		if !strings.Contains(extracted, expected) {
+			t.Errorf("Invoice have to contain %q, got:\n%v", expected, extracted)
+		}
+	}
+}
+
+func TestRenderInvoiceEscaping(t *testing.T) {
+	text, err := defaultTemplates.ReadFile("templates/invoice.tmpl")
+	if err != nil {
+		t.Fatalf("Cannot read default template: %+v", err)
+	}
+	document := testBillDocument()
+	document.Bill.Products[0].Name = "Cable USB-C | Lightning"
+	document.Bill.Customer.FirstName = "@dmin"
+	document.Bill.BillingAddress.Line1 = "12 Main Street\n@hr"
+	document.Seller.Name = `Gadget \ Shop`
+	pdf, err := renderInvoice(string(text), document)
+	if err != nil {
+		t.Fatalf("Cannot render invoice: %+v", err)
+	}
+
+	extracted := strings.Join(extractPDFText(t, pdf), "\n")
+	for _, expected := range []string{
+		`Gadget \ Shop`,
+		"@dmin Łukasiewicz",
+		"12 Main Street @hr",
+		"1\nCable USB-C | Lightning\n2\n4.50 EUR",
+	} {
+		if !strings.Contains(extracted, expected) {
+			t.Errorf("Invoice have to contain %q, got:\n%v", expected, extracted)
+		}
+	}
+}
+
+func TestTrueTypeSubset(t *testing.T) {
+	regular, _, err := loadFonts()
+	if err != nil {
+		t.Fatalf("Cannot load fonts: %+v", err)
+	}
+
+	// é is composite glyph of e and acute accent
+	used := map[uint16]bool{regular.glyph('a'): true, regular.glyph('é'): true}
+	subset, err := parseTrueType("subset", regular.subset(used))
+	if err != nil {
+		t.Fatalf("Cannot parse subset: %+v", err)
+	}
+	if trueTypeChecksum(regular.subset(used)) != 0xB1B0AFBA {
+		t.Errorf("Invalid checksum of subset")
+	}
+
+	outline := func(f *trueTypeFont, glyph uint16) []byte {
+		return f.tables["glyf"][f.loca[glyph]:f.loca[glyph+1]]
+	}
+	for _, glyph := range append(compositeComponents(outline(regular, regular.glyph('é'))), regular.glyph('a'), regular.glyph('é')) {
+		// Outlines of subset are padded to four bytes
+		if !bytes.HasPrefix(outline(subset, glyph), outline(regular, glyph)) {
+			t.Errorf("Outline of glyph %v differs in subset", glyph)
+		}
+	}
+	if len(outline(subset, regular.glyph('b'))) != 0 {
+		t.Errorf("Unused glyph have to be dropped from subset")
+	}
+	if len(compositeComponents(outline(regular, regular.glyph('é')))) == 0 {
+		t.Errorf("é have to be composite glyph")
+	}
+}
+
+func TestRenderPDFLayoutErrors(t *testing.T) {
+	regular, bold, err := loadFonts()
+	if err != nil {
+		t.Fatalf("Cannot load fonts: %+v", err)
+	}
+
+	for _, layout := range []string{
+		"@unknown text",
+		"@columns 50 50\n@row a | b | c",
+		"@row a",
+		"@columns 50 wide",
+	} {
+		if _, err := renderPDFLayout("", layout, regular, bold); err == nil {
+			t.Errorf("Rendering layout %q have to fail", layout)
+		}
+	}
+}
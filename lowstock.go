@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notifier delivers low stock alerts to the outer world
+type Notifier interface {
+	Notify(ctx context.Context, alert LowStockAlert) error
+}
+
+// LogNotifier writes alerts into the standard logger
+type LogNotifier struct{}
+
+func (n LogNotifier) Notify(ctx context.Context, alert LowStockAlert) error {
+	log.Printf("Low stock: product id:%v %q has %v items left (reorder point %v, reorder quantity %v)",
+		alert.Product.Id, alert.Product.Name, alert.Product.Quantity,
+		alert.Product.ReorderPoint, alert.Product.ReorderQuantity)
+	return nil
+}
+
+// WebhookNotifier posts alerts as json to the configured url
+type WebhookNotifier struct {
+	Url    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{Url: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert LowStockAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %v", n.Url, resp.StatusCode)
+	}
+	return nil
+}
+
+// ChanNotifier sends alerts into in-process channel. Mostly useful in tests
+type ChanNotifier struct {
+	C chan LowStockAlert
+}
+
+func NewChanNotifier(size int) *ChanNotifier {
+	return &ChanNotifier{C: make(chan LowStockAlert, size)}
+}
+
+func (n *ChanNotifier) Notify(ctx context.Context, alert LowStockAlert) error {
+	select {
+	case n.C <- alert:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewNotifier constructs notifier by its configured kind
+func NewNotifier(config *config) (Notifier, error) {
+	switch config.LowStock.Notifier {
+	case "log":
+		return LogNotifier{}, nil
+	case "webhook":
+		if config.LowStock.WebhookUrl == "" {
+			return nil, fmt.Errorf("webhook notifier requires LOW_STOCK_WEBHOOK_URL")
+		}
+		return NewWebhookNotifier(config.LowStock.WebhookUrl), nil
+	default:
+		return nil, fmt.Errorf("unknown low stock notifier %q", config.LowStock.Notifier)
+	}
+}
+
+// LowStockChecker periodically looks for products below their reorder point.
+// Each product is reported once until its stock is replenished
+type LowStockChecker struct {
+	s        *Service
+	n        Notifier
+	interval time.Duration
+
+	alerted map[int]bool
+}
+
+func NewLowStockChecker(s *Service, n Notifier, interval time.Duration) *LowStockChecker {
+	return &LowStockChecker{s: s, n: n, interval: interval, alerted: map[int]bool{}}
+}
+
+func (c *LowStockChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Check(ctx); err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *LowStockChecker) Check(ctx context.Context) error {
+	products, err := c.s.GetLowStockProducts(ctx)
+	if err != nil {
+		return err
+	}
+
+	low := make(map[int]bool, len(products))
+	now := time.Now()
+	for _, product := range products {
+		low[product.Id] = true
+		if c.alerted[product.Id] {
+			continue
+		}
+
+		if err := c.n.Notify(ctx, LowStockAlert{Product: product, CheckedAt: now}); err != nil {
+			return err
+		}
+		c.alerted[product.Id] = true
+	}
+
+	// Forget products which were restocked, so they are reported again next time
+	for id := range c.alerted {
+		if !low[id] {
+			delete(c.alerted, id)
+		}
+	}
+
+	return nil
+}
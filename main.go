@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -20,7 +21,16 @@ func main() {
 
 	InitDB(config, db)
 
-	service := NewService(db)
+	service := NewService(db, config)
+
+	notifier, err := NewNotifier(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go NewLowStockChecker(service, notifier, config.LowStock.CheckInterval).Run(context.Background())
+	go NewRelatedProductsRefresher(service, config.Related.MinSupport, config.Related.RefreshInterval).Run(context.Background())
+	go NewCartCleaner(service, config.Cart.CleanupInterval).Run(context.Background())
+
 	v := validator.New()
 	handler := NewHandler(*service, v)
 
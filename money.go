@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MoneyFormatDecimal encodes money as {"amount":"9.99","currency":"USD"}
+	MoneyFormatDecimal = "decimal"
+	// MoneyFormatLegacy encodes money as bare integer of minor units
+	MoneyFormatLegacy = "legacy"
+)
+
+// moneyFormat and defaultCurrency are configured once on startup, see configureMoney
+var (
+	moneyFormat     = MoneyFormatDecimal
+	defaultCurrency = "USD"
+)
+
+// currencyExponents is number of minor units digits of supported ISO 4217 currencies
+var currencyExponents = map[string]int{
+	"AUD": 2, "BHD": 3, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2,
+	"INR": 2, "JPY": 0, "KRW": 0, "KWD": 3, "NOK": 2, "NZD": 2, "PLN": 2, "RUB": 2, "SEK": 2, "USD": 2,
+}
+
+func configureMoney(config *config) error {
+	if _, ok := currencyExponents[config.Money.DefaultCurrency]; !ok {
+		return fmt.Errorf("unsupported DEFAULT_CURRENCY %q", config.Money.DefaultCurrency)
+	}
+	if config.Money.JSONFormat != MoneyFormatDecimal && config.Money.JSONFormat != MoneyFormatLegacy {
+		return fmt.Errorf("unknown MONEY_JSON_FORMAT %q, expected %q or %q",
+			config.Money.JSONFormat, MoneyFormatDecimal, MoneyFormatLegacy)
+	}
+
+	moneyFormat = config.Money.JSONFormat
+	defaultCurrency = config.Money.DefaultCurrency
+	return nil
+}
+
+// Money is amount in minor units (cents for USD) of ISO 4217 currency
+type Money struct {
+	Amount   int64  `db:"amount"`
+	Currency string `db:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney parses decimal amount like "9.99" exactly, without floating point arithmetic.
+// Amount cannot have more fractional digits than currency has minor units
+func ParseMoney(amount, currency string) (Money, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+
+	negative := strings.HasPrefix(amount, "-")
+	digits := strings.TrimPrefix(amount, "-")
+	whole, fraction, hasFraction := strings.Cut(digits, ".")
+	if whole == "" || (hasFraction && fraction == "") || len(fraction) > exponent {
+		return Money{}, fmt.Errorf("invalid %v amount %q", currency, amount)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return Money{}, fmt.Errorf("invalid %v amount %q", currency, amount)
+		}
+	}
+
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid %v amount %q", currency, amount)
+	}
+	if negative {
+		value = -value
+	}
+	return NewMoney(value, currency), nil
+}
+
+// String formats amount as decimal without currency, e.g. "9.99"
+func (m Money) String() string {
+	exponent := currencyExponents[m.Currency]
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	if moneyFormat == MoneyFormatLegacy {
+		return []byte(strconv.FormatInt(m.Amount, 10)), nil
+	}
+
+	amount, _ := json.Marshal(m.String())
+	return json.Marshal(moneyJSON{Amount: amount, Currency: m.Currency})
+}
+
+// UnmarshalJSON accepts both formats regardless of configured one. Legacy integer is treated as minor units
+// of default currency, decimal amount may be passed either as string or as number
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		amount, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return fmt.Errorf("money have to be integer of minor units or object, got %s", data)
+		}
+		*m = NewMoney(amount, defaultCurrency)
+		return nil
+	}
+
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Currency == "" {
+		v.Currency = defaultCurrency
+	}
+
+	// Number is taken by its literal text, so it never passes through float
+	amount := string(bytes.TrimSpace(v.Amount))
+	if strings.HasPrefix(amount, `"`) {
+		if err := json.Unmarshal(v.Amount, &amount); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseMoney(amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// maxRateDigits is precision exchange rates are stored with
+const maxRateDigits = 10
+
+// parseRate parses positive decimal exchange rate like "0.9215" exactly
+func parseRate(rate string) (*big.Rat, error) {
+	whole, fraction, hasFraction := strings.Cut(rate, ".")
+	valid := whole != "" && (!hasFraction || fraction != "") && len(fraction) <= maxRateDigits
+	for _, r := range whole + fraction {
+		valid = valid && r >= '0' && r <= '9'
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid exchange rate %q, expected decimal with at most %v fractional digits", rate, maxRateDigits)
+	}
+
+	value, _ := new(big.Rat).SetString(rate)
+	if value.Sign() <= 0 {
+		return nil, fmt.Errorf("exchange rate have to be positive, got %q", rate)
+	}
+	return value, nil
+}
+
+// normalizeRate strips trailing zeros of rate padded up to numeric column's scale
+func normalizeRate(rate string) string {
+	if !strings.Contains(rate, ".") {
+		return rate
+	}
+	return strings.TrimRight(strings.TrimRight(rate, "0"), ".")
+}
+
+// Convert converts money into another currency by rate of one unit of its currency.
+// Result is rounded half away from zero to minor units of target currency
+func (m Money) Convert(rate *big.Rat, currency string) Money {
+	value := new(big.Rat).SetInt64(m.Amount)
+	value.Mul(value, rate)
+
+	shift := currencyExponents[currency] - currencyExponents[m.Currency]
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		value.Mul(value, scale)
+	} else {
+		value.Quo(value, scale)
+	}
+
+	// Adding half to absolute value and truncating rounds half away from zero
+	num, denom := new(big.Int).Abs(value.Num()), value.Denom()
+	num.Mul(num, big.NewInt(2)).Add(num, denom)
+	num.Quo(num, new(big.Int).Mul(denom, big.NewInt(2)))
+	if value.Sign() < 0 {
+		num.Neg(num)
+	}
+	return NewMoney(num.Int64(), currency)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// parseExchangeRatesCSV reads rates from csv with header base,quote,rate,effective_from.
+// Effective time is accepted as date (2006-01-02) or RFC 3339 timestamp
+func parseExchangeRatesCSV(r io.Reader) ([]ExchangeRateDTOAdd, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &ApiError{Err: "csv is empty"}
+	}
+	if err != nil {
+		return nil, &ApiError{Err: err.Error()}
+	}
+	if strings.Join(header, ",") != "base,quote,rate,effective_from" {
+		return nil, &ApiError{Err: "csv header have to be base,quote,rate,effective_from"}
+	}
+
+	rates := []ExchangeRateDTOAdd{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ApiError{Err: err.Error()}
+		}
+
+		line, _ := reader.FieldPos(0)
+		var effectiveFrom time.Time
+		for _, layout := range []string{"2006-01-02", time.RFC3339} {
+			if effectiveFrom, err = time.Parse(layout, record[3]); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, &ApiError{Err: fmt.Sprintf("line %v: invalid effective_from %q", line, record[3])}
+		}
+
+		rate := ExchangeRateDTOAdd{Base: record[0], Quote: record[1], Rate: record[2], EffectiveFrom: effectiveFrom}
+		if err := rate.validate(); err != nil {
+			return nil, &ApiError{Err: fmt.Sprintf("line %v: %v", line, err)}
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
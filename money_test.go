@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		amount   string
+		currency string
+		minor    int64
+	}{
+		{"9.99", "USD", 999},
+		{"0.1", "USD", 10},
+		{"0.29", "USD", 29}, // 0.29 * 100 is 28.999999999999996 in float64
+		{"1.15", "EUR", 115},
+		{"4.35", "USD", 435}, // 4.35 * 100 is 434.99999999999994 in float64
+		{"-0.05", "USD", -5},
+		{"12", "USD", 1200},
+		{"1000", "JPY", 1000},
+		{"1.234", "KWD", 1234},
+		{"92233720368547758.07", "USD", 9223372036854775807},
+	}
+
+	for _, c := range cases {
+		money, err := ParseMoney(c.amount, c.currency)
+		if err != nil {
+			t.Errorf("Cannot parse %v %v: %+v", c.amount, c.currency, err)
+			continue
+		}
+		if money.Amount != c.minor || money.Currency != c.currency {
+			t.Errorf("Invalid parsed %v %v: have to be %v minor units, got %+v", c.amount, c.currency, c.minor, money)
+		}
+	}
+
+	for _, c := range []struct{ amount, currency string }{
+		{"1.005", "USD"}, // sub-cent amounts are never rounded silently
+		{"1.5", "JPY"},
+		{"1.", "USD"},
+		{".5", "USD"},
+		{"1e2", "USD"},
+		{"1,50", "USD"},
+		{"", "USD"},
+		{"1.00", "XXX"},
+		{"92233720368547758.08", "USD"},
+	} {
+		if _, err := ParseMoney(c.amount, c.currency); err == nil {
+			t.Errorf("Parsing %q %v have to fail", c.amount, c.currency)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		money Money
+		str   string
+	}{
+		{NewMoney(999, "USD"), "9.99"},
+		{NewMoney(5, "USD"), "0.05"},
+		{NewMoney(0, "USD"), "0.00"},
+		{NewMoney(-1205, "EUR"), "-12.05"},
+		{NewMoney(1000, "JPY"), "1000"},
+		{NewMoney(1, "KWD"), "0.001"},
+	}
+
+	for _, c := range cases {
+		if str := c.money.String(); str != c.str {
+			t.Errorf("Invalid string of %+v: have to be %v, got %v", c.money, c.str, str)
+		}
+		if parsed, err := ParseMoney(c.str, c.money.Currency); err != nil || parsed != c.money {
+			t.Errorf("%v doesn't round trip: got %+v (%v)", c.str, parsed, err)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	buf, err := json.Marshal(NewMoney(999, "USD"))
+	if err != nil || string(buf) != `{"amount":"9.99","currency":"USD"}` {
+		t.Errorf("Invalid decimal json: %s (%v)", buf, err)
+	}
+
+	moneyFormat = MoneyFormatLegacy
+	buf, err = json.Marshal(NewMoney(999, "USD"))
+	moneyFormat = MoneyFormatDecimal
+	if err != nil || string(buf) != `999` {
+		t.Errorf("Invalid legacy json: %s (%v)", buf, err)
+	}
+
+	cases := []struct {
+		json  string
+		money Money
+	}{
+		{`{"amount":"9.99","currency":"USD"}`, NewMoney(999, "USD")},
+		{`{"amount":0.29,"currency":"USD"}`, NewMoney(29, "USD")},
+		{`{"amount":"5","currency":"JPY"}`, NewMoney(5, "JPY")},
+		{`{"amount":"1.10"}`, NewMoney(110, defaultCurrency)},
+		{`999`, NewMoney(999, defaultCurrency)},
+	}
+	for _, c := range cases {
+		var money Money
+		if err := json.Unmarshal([]byte(c.json), &money); err != nil || money != c.money {
+			t.Errorf("Invalid money of %v: have to be %+v, got %+v (%v)", c.json, c.money, money, err)
+		}
+	}
+
+	for _, invalid := range []string{`9.99`, `"9.99"`, `{"amount":"1.001","currency":"USD"}`, `{"amount":"1","currency":"usd"}`} {
+		var money Money
+		if err := json.Unmarshal([]byte(invalid), &money); err == nil {
+			t.Errorf("Unmarshalling %v have to fail, got %+v", invalid, money)
+		}
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	cases := []struct {
+		money    Money
+		rate     string
+		currency string
+		expected Money
+	}{
+		{NewMoney(999, "USD"), "0.92", "EUR", NewMoney(919, "EUR")},    // 919.08
+		{NewMoney(1000, "USD"), "0.9215", "EUR", NewMoney(922, "EUR")}, // 921.5 is rounded half up
+		{NewMoney(-1000, "USD"), "0.9215", "EUR", NewMoney(-922, "EUR")},
+		{NewMoney(999, "USD"), "151.37", "JPY", NewMoney(1512, "JPY")},  // 1512.1863
+		{NewMoney(1000, "JPY"), "0.0066", "USD", NewMoney(660, "USD")},  // minor units are scaled up
+		{NewMoney(1000, "USD"), "0.3077", "KWD", NewMoney(3077, "KWD")}, // 10.00 USD is 3.077 KWD
+		{NewMoney(1, "USD"), "0.0000000001", "EUR", NewMoney(0, "EUR")},
+	}
+
+	for _, c := range cases {
+		rate, err := parseRate(c.rate)
+		if err != nil {
+			t.Errorf("Cannot parse rate %v: %+v", c.rate, err)
+			continue
+		}
+		if converted := c.money.Convert(rate, c.currency); converted != c.expected {
+			t.Errorf("Invalid conversion of %+v by %v: have to be %+v, got %+v", c.money, c.rate, c.expected, converted)
+		}
+	}
+
+	for _, invalid := range []string{"0", "-1", "1/3", "1e3", "0.12345678901", ".5", ""} {
+		if _, err := parseRate(invalid); err == nil {
+			t.Errorf("Parsing rate %q have to fail", invalid)
+		}
+	}
+}
+
+func TestParseExchangeRatesCSV(t *testing.T) {
+	rates, err := parseExchangeRatesCSV(strings.NewReader(
+		"base,quote,rate,effective_from\nUSD,EUR,0.9215,2023-04-01\nUSD,GBP,0.8041,2023-04-01T12:00:00Z\n",
+	))
+	if err != nil || len(rates) != 2 {
+		t.Fatalf("Cannot parse rates: %+v (%+v)", err, rates)
+	}
+	if rates[1].Quote != "GBP" || rates[1].Rate != "0.8041" || !rates[1].EffectiveFrom.Equal(time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid parsed rate: %+v", rates[1])
+	}
+
+	for _, invalid := range []string{
+		"",
+		"from,to,rate,date\nUSD,EUR,0.92,2023-04-01\n",
+		"base,quote,rate,effective_from\nUSD,EUR,0.92\n",
+		"base,quote,rate,effective_from\nUSD,XXX,0.92,2023-04-01\n",
+		"base,quote,rate,effective_from\nUSD,USD,1,2023-04-01\n",
+		"base,quote,rate,effective_from\nUSD,EUR,0.92,yesterday\n",
+	} {
+		if _, err := parseExchangeRatesCSV(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Parsing csv %q have to fail", invalid)
+		}
+	}
+}
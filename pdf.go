@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 page size and margins in points
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 50
+)
+
+// pdfFont is TrueType font embedded into PDF document with glyphs it was used for
+type pdfFont struct {
+	ttf  *trueTypeFont
+	used map[uint16]rune
+}
+
+// pdfDocument writes PDF with text drawn by embedded TrueType fonts. Text is encoded by glyph ids (Identity-H),
+// ToUnicode maps them back, so text can be searched and extracted
+type pdfDocument struct {
+	title string
+	fonts []*pdfFont
+	pages []*bytes.Buffer
+}
+
+func newPDFDocument(title string, fonts ...*trueTypeFont) *pdfDocument {
+	d := &pdfDocument{title: title}
+	for _, font := range fonts {
+		d.fonts = append(d.fonts, &pdfFont{ttf: font, used: map[uint16]rune{}})
+	}
+	return d
+}
+
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.addPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// text draws text by font with passed index, baseline starts at x, y from the bottom left corner of page
+func (d *pdfDocument) text(font int, size, x, y float64, text string) {
+	f := d.fonts[font]
+	glyphs := &strings.Builder{}
+	for _, r := range text {
+		glyph := f.ttf.glyph(r)
+		if _, ok := f.used[glyph]; !ok {
+			f.used[glyph] = r
+		}
+		fmt.Fprintf(glyphs, "%04X", glyph)
+	}
+	fmt.Fprintf(d.page(), "BT /F%d %s Tf %s %s Td <%s> Tj ET\n", font+1, pdfNumber(size), pdfNumber(x), pdfNumber(y), glyphs)
+}
+
+func (d *pdfDocument) line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s m %s %s l S\n", pdfNumber(width), pdfNumber(x1), pdfNumber(y1), pdfNumber(x2), pdfNumber(y2))
+}
+
+// WriteTo writes document with subsets of its fonts
+func (d *pdfDocument) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.addPage()
+	}
+
+	// Objects are numbered from 1: catalog, page tree, info, then fonts' objects and pages with their contents
+	objects := [][]byte{nil, nil, []byte(fmt.Sprintf("<< /Title %s /Producer (products) >>", pdfTextString(d.title)))}
+	add := func(object []byte) int {
+		objects = append(objects, object)
+		return len(objects)
+	}
+
+	fonts := &strings.Builder{}
+	for i, font := range d.fonts {
+		fmt.Fprintf(fonts, "/F%d %d 0 R ", i+1, d.writeFont(font, add))
+	}
+
+	kids := &strings.Builder{}
+	for _, page := range d.pages {
+		contents := add(pdfStream("", page.Bytes()))
+		object := add([]byte(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << %s>> >> /Contents %d 0 R >>",
+			pdfNumber(pdfPageWidth), pdfNumber(pdfPageHeight), fonts, contents)))
+		fmt.Fprintf(kids, "%d 0 R ", object)
+	}
+	objects[0] = []byte("<< /Type /Catalog /Pages 2 0 R >>")
+	objects[1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(d.pages)))
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n", i+1)
+		buf.Write(object)
+		buf.WriteString("\nendobj\n")
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.WriteTo(w)
+}
+
+// writeFont adds objects of Type0 font with its CID font, descriptor, subset and ToUnicode map
+func (d *pdfDocument) writeFont(font *pdfFont, add func([]byte) int) int {
+	glyphs := make([]int, 0, len(font.used))
+	used := make(map[uint16]bool, len(font.used))
+	for glyph := range font.used {
+		glyphs = append(glyphs, int(glyph))
+		used[glyph] = true
+	}
+	sort.Ints(glyphs)
+
+	// Subset is tagged by six letters derived from its glyphs, as PDF requires
+	hash := sha1.New()
+	for _, glyph := range glyphs {
+		fmt.Fprintf(hash, "%d,", glyph)
+	}
+	tag := []byte{}
+	for _, b := range hash.Sum(nil)[:6] {
+		tag = append(tag, 'A'+b%26)
+	}
+	name := string(tag) + "+" + font.ttf.name
+
+	ttf := font.ttf
+	subset := ttf.subset(used)
+	file := add(pdfStream(fmt.Sprintf("/Length1 %d ", len(subset)), subset))
+	descriptor := add([]byte(fmt.Sprintf(
+		"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, ttf.scale(ttf.bbox[0]), ttf.scale(ttf.bbox[1]), ttf.scale(ttf.bbox[2]), ttf.scale(ttf.bbox[3]),
+		ttf.scale(ttf.ascent), ttf.scale(ttf.descent), ttf.scale(ttf.ascent), file)))
+
+	widths := &strings.Builder{}
+	for _, glyph := range glyphs {
+		fmt.Fprintf(widths, "%d [%d] ", glyph, ttf.width(uint16(glyph)))
+	}
+	cidFont := add([]byte(fmt.Sprintf(
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /W [%s] /CIDToGIDMap /Identity >>",
+		name, descriptor, widths)))
+
+	cmap := &strings.Builder{}
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(glyphs); start += 100 {
+		end := minInt(start+100, len(glyphs))
+		fmt.Fprintf(cmap, "%d beginbfchar\n", end-start)
+		for _, glyph := range glyphs[start:end] {
+			fmt.Fprintf(cmap, "<%04X> <", glyph)
+			for _, unit := range utf16.Encode([]rune{font.used[uint16(glyph)]}) {
+				fmt.Fprintf(cmap, "%04X", unit)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMapResource defineresource pop\nend\nend\n")
+	toUnicode := add(pdfStream("", []byte(cmap.String())))
+
+	return add([]byte(fmt.Sprintf(
+		"<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		name, cidFont, toUnicode)))
+}
+
+// pdfStream returns stream object compressed by Flate with extra entries of its dictionary
+func pdfStream(entries string, data []byte) []byte {
+	compressed := &bytes.Buffer{}
+	zw := zlib.NewWriter(compressed)
+	zw.Write(data)
+	zw.Close()
+
+	stream := &bytes.Buffer{}
+	fmt.Fprintf(stream, "<< /Length %d /Filter /FlateDecode %s>>\nstream\n", compressed.Len(), entries)
+	stream.Write(compressed.Bytes())
+	stream.WriteString("\nendstream")
+	return stream.Bytes()
+}
+
+// pdfTextString encodes text string as UTF-16BE hex string, so it may contain any characters
+func pdfTextString(s string) string {
+	buf := &strings.Builder{}
+	buf.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(buf, "%04X", unit)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
+
+func pdfNumber(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(strconv.FormatFloat(v, 'f', 2, 64), "0"), ".")
+}
+
+// Font sizes and line spacing of PDF layout
+const (
+	layoutTextSize  = 10
+	layoutTitleSize = 18
+	layoutLeading   = 1.4
+	layoutCellGap   = 6
+)
+
+// renderPDFLayout draws layout produced by template into PDF document. Layout is line based, every line is
+// either plain text, which is wrapped by words, or one of directives:
+//
+//	@title text           large bold text
+//	@bold text            bold text
+//	@right text           text aligned to the right margin
+//	@columns 10 50 20r    widths of table's columns in percents of page width, r aligns column to the right
+//	@row a | b | c        table row, cells which don't fit their columns are truncated
+//	@boldrow a | b | c    table row drawn by bold font
+//	@hr                   horizontal rule
+//
+// Backslash escapes the next character, so \| is literal | in cell and line starting with \@ is plain text.
+// Values are inserted into layout by escapeLayout. Empty line adds vertical space. Pages are added when text
+// reaches the bottom margin
+func renderPDFLayout(title, layout string, regular, bold *trueTypeFont) (*pdfDocument, error) {
+	const (
+		regularFont = iota
+		boldFont
+	)
+	doc := newPDFDocument(title, regular, bold)
+	width := pdfPageWidth - 2*pdfMargin
+	y := pdfPageHeight - pdfMargin
+
+	// advance moves cursor to the next line of passed size, breaking page when it doesn't fit
+	advance := func(size float64) float64 {
+		if len(doc.pages) == 0 || y-size*layoutLeading < pdfMargin {
+			doc.addPage()
+			y = pdfPageHeight - pdfMargin
+		}
+		y -= size * layoutLeading
+		return y + size*(layoutLeading-1)
+	}
+
+	type column struct {
+		x, width float64
+		right    bool
+	}
+	var columns []column
+
+	for n, line := range strings.Split(strings.TrimRight(layout, "\n"), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		directive, text, _ := strings.Cut(line, " ")
+		if !strings.HasPrefix(directive, "@") {
+			directive, text = "", line
+		}
+
+		switch directive {
+		case "":
+			if strings.TrimSpace(text) == "" {
+				y -= layoutTextSize * layoutLeading / 2
+				continue
+			}
+			for _, wrapped := range wrapText(regular, unescapeLayout(strings.TrimSpace(text)), layoutTextSize, width) {
+				doc.text(regularFont, layoutTextSize, pdfMargin, advance(layoutTextSize), wrapped)
+			}
+		case "@title":
+			doc.text(boldFont, layoutTitleSize, pdfMargin, advance(layoutTitleSize), unescapeLayout(text))
+		case "@bold":
+			doc.text(boldFont, layoutTextSize, pdfMargin, advance(layoutTextSize), unescapeLayout(text))
+		case "@right":
+			text = unescapeLayout(text)
+			doc.text(regularFont, layoutTextSize, pdfMargin+width-regular.textWidth(text, layoutTextSize), advance(layoutTextSize), text)
+		case "@hr":
+			y -= layoutTextSize * (layoutLeading - 1)
+			doc.line(pdfMargin, y, pdfMargin+width, y, 0.5)
+		case "@columns":
+			columns = columns[:0]
+			x := float64(pdfMargin)
+			for _, field := range strings.Fields(text) {
+				percent, err := strconv.ParseFloat(strings.TrimSuffix(field, "r"), 64)
+				if err != nil || percent <= 0 {
+					return nil, fmt.Errorf("layout line %d: invalid column width %q", n+1, field)
+				}
+				columns = append(columns, column{x: x, width: width * percent / 100, right: strings.HasSuffix(field, "r")})
+				x += width * percent / 100
+			}
+		case "@row", "@boldrow":
+			font, ttf := regularFont, regular
+			if directive == "@boldrow" {
+				font, ttf = boldFont, bold
+			}
+			cells := splitLayoutRow(text)
+			if len(cells) > len(columns) {
+				return nil, fmt.Errorf("layout line %d: row has %d cells, but %d columns are declared", n+1, len(cells), len(columns))
+			}
+			baseline := advance(layoutTextSize)
+			for i, cell := range cells {
+				cell = truncateText(ttf, strings.TrimSpace(cell), layoutTextSize, columns[i].width-layoutCellGap)
+				x := columns[i].x
+				if columns[i].right {
+					x += columns[i].width - ttf.textWidth(cell, layoutTextSize)
+				}
+				doc.text(font, layoutTextSize, x, baseline, cell)
+			}
+		default:
+			return nil, fmt.Errorf("layout line %d: unknown directive %v", n+1, directive)
+		}
+	}
+	return doc, nil
+}
+
+// layoutEscaper escapes characters of value which have meaning in layout, line breaks would start new layout line
+var layoutEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+
+// escapeLayout makes value render literally wherever it is inserted into layout
+func escapeLayout(value string) string {
+	value = layoutEscaper.Replace(value)
+	if strings.HasPrefix(value, "@") {
+		value = `\` + value
+	}
+	return value
+}
+
+// unescapeLayout removes backslashes escaping characters of text
+func unescapeLayout(text string) string {
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+	var unescaped strings.Builder
+	escaped := false
+	for _, r := range text {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		unescaped.WriteRune(r)
+	}
+	return unescaped.String()
+}
+
+// splitLayoutRow splits text of row into unescaped cells by | which isn't escaped
+func splitLayoutRow(text string) []string {
+	var cells []string
+	var cell strings.Builder
+	escaped := false
+	for _, r := range text {
+		switch {
+		case escaped:
+			cell.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '|':
+			cells = append(cells, cell.String())
+			cell.Reset()
+		default:
+			cell.WriteRune(r)
+		}
+	}
+	return append(cells, cell.String())
+}
+
+// wrapText splits text into lines fitting width by words, too long words are kept on their own lines
+func wrapText(font *trueTypeFont, text string, size, width float64) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if line != "" && font.textWidth(line+" "+word, size) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+// truncateText shortens text by ellipsis until it fits width
+func truncateText(font *trueTypeFont, text string, size, width float64) string {
+	if font.textWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && font.textWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
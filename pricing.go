@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+const (
+	// TaxExclusive prices don't include tax, it is added on top of bill's total
+	TaxExclusive = "exclusive"
+	// TaxInclusive prices already include tax, it is extracted from bill's total
+	TaxInclusive = "inclusive"
+)
+
+// Discount reduces price either by percent (0-100) or by fixed amount. It is stored as json
+type Discount struct {
+	Type  string `json:"type" validate:"required,oneof=percent fixed"`
+	Value int    `json:"value" validate:"required,gt=0"`
+}
+
+func (d *Discount) validate() error {
+	if d != nil && d.Type == DiscountPercent && d.Value > 100 {
+		return &ApiError{Err: fmt.Sprintf("percent discount cannot exceed 100, got %v", d.Value)}
+	}
+	return nil
+}
+
+// Apply returns discount off passed base. Percents are rounded half up, result never exceeds base.
+// Fixed discount is in minor units of bill's currency
+func (d *Discount) Apply(base int64) int64 {
+	if d == nil || base <= 0 {
+		return 0
+	}
+
+	var amount int64
+	switch d.Type {
+	case DiscountPercent:
+		amount = (base*int64(d.Value) + 50) / 100
+	case DiscountFixed:
+		amount = int64(d.Value)
+	}
+
+	if amount > base {
+		return base
+	}
+	return amount
+}
+
+func (d *Discount) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("cannot scan %T into Discount", src)
+	}
+}
+
+// arg converts discount into query argument. Absent discount is stored as NULL
+func (d *Discount) arg() any {
+	if d == nil {
+		return nil
+	}
+	buf, _ := json.Marshal(d)
+	return string(buf)
+}
+
+// equal reports whether discounts reduce price the same way, absent discounts are equal
+func (d *Discount) equal(other *Discount) bool {
+	if d == nil || other == nil {
+		return d == other
+	}
+	return *d == *other
+}
+
+// mergeBillProducts merges products passed into bill several times by summing their quantities, keeping order of
+// first occurrence. Duplicates with different discounts are ambiguous and rejected
+func mergeBillProducts(products []BillProduct) ([]BillProduct, error) {
+	merged := make([]BillProduct, 0, len(products))
+	index := map[int]int{}
+	for _, billProduct := range products {
+		i, ok := index[billProduct.Product]
+		if !ok {
+			index[billProduct.Product] = len(merged)
+			merged = append(merged, billProduct)
+			continue
+		}
+		if !merged[i].Discount.equal(billProduct.Discount) {
+			return nil, &ApiError{Err: fmt.Sprintf("product id:%v is passed several times with different discounts", billProduct.Product)}
+		}
+		merged[i].Quantity += billProduct.Quantity
+	}
+	return merged, nil
+}
+
+// BillLine is bill's product with price snapshotted when it was added to bill
+type BillLine struct {
+	Product  int       `json:"product" db:"product_id"`
+	Name     string    `json:"name" db:"name"`
+	Category string    `json:"category" db:"category"`
+	Quantity int       `json:"quantity" db:"quantity"`
+	Price    Money     `json:"price" db:"price"`
+	Discount *Discount `json:"discount" db:"discount"`
+	TaxRate  int       `json:"tax_rate" db:"tax_rate"`
+
+	Amount         Money `json:"amount" db:"-"`
+	DiscountAmount Money `json:"discount_amount" db:"-"`
+	Total          Money `json:"total" db:"-"`
+}
+
+// TaxSubtotal is tax of bill's lines with the same rate. Rates are in hundredths of percent
+type TaxSubtotal struct {
+	Rate int   `json:"rate"`
+	Base Money `json:"base"`
+	Tax  Money `json:"tax"`
+}
+
+// BillTotals is breakdown of bill's total in bill's currency
+type BillTotals struct {
+	Subtotal       Money         `json:"subtotal"`
+	LineDiscount   Money         `json:"line_discount"`
+	BillDiscount   Money         `json:"bill_discount"`
+	CouponDiscount Money         `json:"coupon_discount"`
+	TaxMode        string        `json:"tax_mode"`
+	Tax            Money         `json:"tax"`
+	Taxes          []TaxSubtotal `json:"taxes"`
+	Total          Money         `json:"total"`
+}
+
+// calculateLines fills amounts of bill's lines and returns their sum after line discounts
+func calculateLines(lines []BillLine, currency string) BillTotals {
+	var subtotal, lineDiscount int64
+	for i := range lines {
+		line := &lines[i]
+		amount := line.Price.Amount * int64(line.Quantity)
+		discount := line.Discount.Apply(amount)
+
+		line.Amount = NewMoney(amount, currency)
+		line.DiscountAmount = NewMoney(discount, currency)
+		line.Total = NewMoney(amount-discount, currency)
+
+		subtotal += amount
+		lineDiscount += discount
+	}
+
+	zero := NewMoney(0, currency)
+	return BillTotals{
+		Subtotal:       NewMoney(subtotal, currency),
+		LineDiscount:   NewMoney(lineDiscount, currency),
+		BillDiscount:   zero,
+		CouponDiscount: zero,
+		Tax:            zero,
+		Total:          NewMoney(subtotal-lineDiscount, currency),
+	}
+}
+
+// calculateBillTotals applies line discounts, then bill's discount and then already calculated coupon's one
+func calculateBillTotals(lines []BillLine, discount *Discount, couponDiscount int64, currency string) BillTotals {
+	totals := calculateLines(lines, currency)
+
+	totals.BillDiscount.Amount = discount.Apply(totals.Total.Amount)
+	totals.Total.Amount -= totals.BillDiscount.Amount
+
+	if couponDiscount > totals.Total.Amount {
+		couponDiscount = totals.Total.Amount
+	}
+	totals.CouponDiscount.Amount = couponDiscount
+	totals.Total.Amount -= couponDiscount
+	return totals
+}
+
+// couponDiscount calculates discount of coupon over bill's lines with already applied line and bill discounts.
+// Restricted coupon discounts only lines with its products or categories. Coupon with currency applies only to bills in it
+func couponDiscount(coupon *Coupon, lines []BillLine, discount *Discount, currency string) (int64, error) {
+	if coupon.Currency != "" && coupon.Currency != currency {
+		return 0, &ApiError{Err: fmt.Sprintf("coupon %v applies only to bills in %v", coupon.Code, coupon.Currency)}
+	}
+	totals := calculateBillTotals(lines, discount, 0, currency)
+	if totals.Total.Amount < coupon.MinBillTotal {
+		return 0, &ApiError{Err: fmt.Sprintf("coupon %v requires bill total at least %v, got %v",
+			coupon.Code, NewMoney(coupon.MinBillTotal, currency), totals.Total)}
+	}
+
+	var eligible int64
+	for _, line := range lines {
+		if coupon.appliesTo(line) {
+			eligible += line.Total.Amount
+		}
+	}
+	if eligible == 0 {
+		return 0, &ApiError{Err: fmt.Sprintf("coupon %v isn't applicable to bill's products", coupon.Code)}
+	}
+
+	// Bill's discount is spread over eligible lines proportionally
+	if totals.BillDiscount.Amount > 0 {
+		eligible -= totals.BillDiscount.Amount * eligible / (totals.Total.Amount + totals.BillDiscount.Amount)
+	}
+
+	amount := coupon.Discount.Apply(eligible)
+	if amount > totals.Total.Amount {
+		amount = totals.Total.Amount
+	}
+	return amount, nil
+}
+
+// allocate splits amount between weights proportionally. Remainder left after rounding down goes to
+// weights with the largest fractional parts, earlier weights win ties
+func allocate(amount int64, weights []int64) []int64 {
+	shares := make([]int64, len(weights))
+	var sum int64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum == 0 || amount == 0 {
+		return shares
+	}
+
+	remainders := make([]int64, len(weights))
+	left := amount
+	for i, w := range weights {
+		shares[i] = amount * w / sum
+		remainders[i] = amount * w % sum
+		left -= shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; left > 0; i++ {
+		shares[order[i%len(order)]]++
+		left--
+	}
+	return shares
+}
+
+// divRound divides non-negative numbers rounding half up
+func divRound(a, b int64) int64 {
+	return (a*2 + b) / (b * 2)
+}
+
+// discountedLineAmounts spreads bill's discount over all lines and coupon's one over lines it applies to.
+// Returned amounts sum to bill's total before tax
+func discountedLineAmounts(totals BillTotals, lines []BillLine, coupon *Coupon) []int64 {
+	amounts := make([]int64, len(lines))
+	for i, line := range lines {
+		amounts[i] = line.Total.Amount
+	}
+	for i, share := range allocate(totals.BillDiscount.Amount, amounts) {
+		amounts[i] -= share
+	}
+
+	// Coupon's restrictions may be changed after it was applied, then its discount is spread over all lines
+	eligible := make([]int64, len(lines))
+	var eligibleSum int64
+	for i, line := range lines {
+		if coupon == nil || coupon.appliesTo(line) {
+			eligible[i] = amounts[i]
+			eligibleSum += amounts[i]
+		}
+	}
+	if eligibleSum == 0 {
+		copy(eligible, amounts)
+	}
+	for i, share := range allocate(totals.CouponDiscount.Amount, eligible) {
+		amounts[i] -= share
+	}
+	return amounts
+}
+
+// applyTaxes adds taxes to totals calculated by calculateBillTotals. Discounts are spread over lines first,
+// then lines are grouped by tax rate and tax is rounded once per group
+func applyTaxes(totals BillTotals, lines []BillLine, coupon *Coupon, mode string) BillTotals {
+	currency := totals.Total.Currency
+	totals.TaxMode = mode
+	totals.Taxes = []TaxSubtotal{}
+
+	amounts := discountedLineAmounts(totals, lines, coupon)
+	bases := map[int]int64{}
+	for i, line := range lines {
+		bases[line.TaxRate] += amounts[i]
+	}
+	for rate, base := range bases {
+		net, tax := base, divRound(base*int64(rate), 10000)
+		if mode == TaxInclusive {
+			net = divRound(base*10000, 10000+int64(rate))
+			tax = base - net
+		}
+		totals.Taxes = append(totals.Taxes, TaxSubtotal{Rate: rate, Base: NewMoney(net, currency), Tax: NewMoney(tax, currency)})
+		totals.Tax.Amount += tax
+	}
+	sort.Slice(totals.Taxes, func(a, b int) bool {
+		return totals.Taxes[a].Rate < totals.Taxes[b].Rate
+	})
+
+	if mode != TaxInclusive {
+		totals.Total.Amount += totals.Tax.Amount
+	}
+	return totals
+}
+
+// paidLineAmounts splits bill's total calculated by applyTaxes between its lines: each line gets its share
+// of discounts and of tax of its rate. Returned amounts sum exactly to bill's total
+func paidLineAmounts(totals BillTotals, lines []BillLine, coupon *Coupon) []int64 {
+	amounts := discountedLineAmounts(totals, lines, coupon)
+	if totals.TaxMode == TaxInclusive {
+		return amounts
+	}
+
+	for _, subtotal := range totals.Taxes {
+		weights := make([]int64, len(lines))
+		for i, line := range lines {
+			if line.TaxRate == subtotal.Rate {
+				weights[i] = amounts[i]
+			}
+		}
+		for i, share := range allocate(subtotal.Tax.Amount, weights) {
+			amounts[i] += share
+		}
+	}
+	return amounts
+}
+
+// creditAmount returns part of line's paid amount for returned quantity. Previous returns are taken into
+// account, so returning the whole quantity in several parts credits exactly the paid amount
+func creditAmount(paid int64, sold, returned, quantity int) int64 {
+	return divRound(paid*int64(returned+quantity), int64(sold)) - divRound(paid*int64(returned), int64(sold))
+}
+
+// lineCredit is quantity and amount of bill's line returned by credit notes
+type lineCredit struct {
+	Bill     int   `db:"bill_id"`
+	Product  int   `db:"product_id"`
+	Quantity int   `db:"quantity"`
+	Amount   int64 `db:"amount"`
+}
+
+// topBillProducts sums products of calculated bills by product and currency. Spend is line's share of bill's total,
+// so it includes discounts, coupon and taxes; returned quantities and credited amounts are subtracted and fully
+// returned products are omitted. Products are ordered by quantity and spend, at most limit are returned
+func topBillProducts(bills map[int]*billCalculation, credits []lineCredit, limit int) []CustomerTopProduct {
+	type lineKey struct{ bill, product int }
+	credited := map[lineKey]lineCredit{}
+	for _, credit := range credits {
+		credited[lineKey{credit.Bill, credit.Product}] = credit
+	}
+
+	type productKey struct {
+		product  int
+		currency string
+	}
+	sums := map[productKey]*CustomerTopProduct{}
+	for id, bill := range bills {
+		paid := paidLineAmounts(bill.totals, bill.lines, bill.coupon)
+		for i, line := range bill.lines {
+			key := productKey{line.Product, bill.totals.Total.Currency}
+			sum, ok := sums[key]
+			if !ok {
+				sum = &CustomerTopProduct{Product: line.Product, Name: line.Name, Spend: NewMoney(0, key.currency)}
+				sums[key] = sum
+			}
+			credit := credited[lineKey{id, line.Product}]
+			sum.Quantity += line.Quantity - credit.Quantity
+			sum.Spend.Amount += paid[i] - credit.Amount
+		}
+	}
+
+	products := []CustomerTopProduct{}
+	for _, sum := range sums {
+		if sum.Quantity > 0 {
+			products = append(products, *sum)
+		}
+	}
+	sort.Slice(products, func(a, b int) bool {
+		pa, pb := products[a], products[b]
+		if pa.Quantity != pb.Quantity {
+			return pa.Quantity > pb.Quantity
+		}
+		if pa.Spend.Amount != pb.Spend.Amount {
+			return pa.Spend.Amount > pb.Spend.Amount
+		}
+		if pa.Product != pb.Product {
+			return pa.Product < pb.Product
+		}
+		return pa.Spend.Currency < pb.Spend.Currency
+	})
+	if len(products) > limit {
+		products = products[:limit]
+	}
+	return products
+}
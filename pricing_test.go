@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lib/pq"
+)
+
+func TestDiscountApply(t *testing.T) {
+	cases := []struct {
+		discount *Discount
+		base     int64
+		amount   int64
+	}{
+		{nil, 1000, 0},
+		{&Discount{Type: DiscountPercent, Value: 10}, 1000, 100},
+		{&Discount{Type: DiscountPercent, Value: 10}, 995, 100}, // 99.5 is rounded half up
+		{&Discount{Type: DiscountPercent, Value: 10}, 994, 99},
+		{&Discount{Type: DiscountPercent, Value: 100}, 1234, 1234},
+		{&Discount{Type: DiscountFixed, Value: 300}, 1000, 300},
+		{&Discount{Type: DiscountFixed, Value: 300}, 200, 200}, // never exceeds base
+	}
+
+	for _, c := range cases {
+		if amount := c.discount.Apply(c.base); amount != c.amount {
+			t.Errorf("Invalid discount %+v of %v: have to be %v, got %v", c.discount, c.base, c.amount, amount)
+		}
+	}
+}
+
+func TestMergeBillProducts(t *testing.T) {
+	percent := func(value int) *Discount { return &Discount{Type: DiscountPercent, Value: value} }
+
+	merged, err := mergeBillProducts([]BillProduct{
+		{Product: 2, Quantity: 1},
+		{Product: 1, Quantity: 2, Discount: percent(10)},
+		{Product: 2, Quantity: 3},
+		{Product: 1, Quantity: 1, Discount: percent(10)},
+	})
+	expected := []BillProduct{
+		{Product: 2, Quantity: 4},
+		{Product: 1, Quantity: 3, Discount: percent(10)},
+	}
+	if err != nil || !cmp.Equal(merged, expected) {
+		t.Errorf("Invalid merged products: have to be %+v, got %+v (%v)", expected, merged, err)
+	}
+
+	for _, products := range [][]BillProduct{
+		{{Product: 1, Quantity: 1}, {Product: 1, Quantity: 1, Discount: percent(10)}},
+		{{Product: 1, Quantity: 1, Discount: percent(5)}, {Product: 1, Quantity: 1, Discount: percent(10)}},
+	} {
+		if _, err := mergeBillProducts(products); err == nil {
+			t.Errorf("Merging duplicates with different discounts %+v have to fail", products)
+		}
+	}
+}
+
+func TestCalculateBillTotals(t *testing.T) {
+	lines := []BillLine{
+		{Product: 1, Quantity: 2, Price: NewMoney(500, "USD"), Discount: &Discount{Type: DiscountPercent, Value: 10}},
+		{Product: 2, Quantity: 1, Price: NewMoney(300, "USD"), Discount: &Discount{Type: DiscountFixed, Value: 50}},
+		{Product: 3, Quantity: 3, Price: NewMoney(100, "USD")},
+	}
+
+	totals := calculateBillTotals(lines, &Discount{Type: DiscountFixed, Value: 100}, 80, "USD")
+	expected := BillTotals{
+		Subtotal:       NewMoney(1600, "USD"),
+		LineDiscount:   NewMoney(150, "USD"),
+		BillDiscount:   NewMoney(100, "USD"),
+		CouponDiscount: NewMoney(80, "USD"),
+		Tax:            NewMoney(0, "USD"),
+		Total:          NewMoney(1270, "USD"),
+	}
+	if !cmp.Equal(totals, expected) {
+		t.Errorf("Invalid bill totals: have to be %+v, got %+v", expected, totals)
+	}
+	if lines[0].Total.Amount != 900 || lines[1].Total.Amount != 250 || lines[2].Total.Amount != 300 {
+		t.Errorf("Invalid line totals: %+v", lines)
+	}
+}
+
+func TestCouponDiscount(t *testing.T) {
+	lines := []BillLine{
+		{Product: 1, Category: "consoles", Quantity: 1, Price: NewMoney(400, "USD")},
+		{Product: 2, Category: "phones", Quantity: 1, Price: NewMoney(600, "USD")},
+	}
+
+	// Unrestricted coupon discounts whole bill
+	coupon := &Coupon{Code: "ALL10", Discount: Discount{Type: DiscountPercent, Value: 10}}
+	if amount, err := couponDiscount(coupon, lines, nil, "USD"); err != nil || amount != 100 {
+		t.Errorf("Invalid unrestricted coupon discount: have to be 100, got %v (%v)", amount, err)
+	}
+
+	// Restricted coupon discounts only eligible lines
+	coupon = &Coupon{Code: "CONSOLES10", Discount: Discount{Type: DiscountPercent, Value: 10}, Categories: pq.StringArray{"consoles"}}
+	if amount, err := couponDiscount(coupon, lines, nil, "USD"); err != nil || amount != 40 {
+		t.Errorf("Invalid restricted coupon discount: have to be 40, got %v (%v)", amount, err)
+	}
+
+	// Bill's discount is spread over eligible lines before coupon applies
+	if amount, err := couponDiscount(coupon, lines, &Discount{Type: DiscountPercent, Value: 50}, "USD"); err != nil || amount != 20 {
+		t.Errorf("Invalid restricted coupon discount after bill's discount: have to be 20, got %v (%v)", amount, err)
+	}
+
+	coupon = &Coupon{Code: "LAPTOPS", Discount: Discount{Type: DiscountFixed, Value: 50}, Currency: "USD", Categories: pq.StringArray{"laptops"}}
+	if _, err := couponDiscount(coupon, lines, nil, "USD"); err == nil {
+		t.Errorf("Coupon without eligible lines have to fail")
+	}
+
+	coupon = &Coupon{Code: "BIG", Discount: Discount{Type: DiscountFixed, Value: 50}, Currency: "USD", MinBillTotal: 1001}
+	if _, err := couponDiscount(coupon, lines, nil, "USD"); err == nil {
+		t.Errorf("Coupon with unreached minimal bill total have to fail")
+	}
+
+	coupon = &Coupon{Code: "EURO", Discount: Discount{Type: DiscountFixed, Value: 50}, Currency: "EUR"}
+	if _, err := couponDiscount(coupon, lines, nil, "USD"); err == nil {
+		t.Errorf("Coupon in another currency than bill's one have to fail")
+	}
+}
+
+func TestCreditAmount(t *testing.T) {
+	lines := []BillLine{
+		{Product: 1, Quantity: 3, Price: NewMoney(333, "USD"), TaxRate: 2000},
+		{Product: 2, Quantity: 1, Price: NewMoney(250, "USD"), TaxRate: 500},
+		{Product: 3, Quantity: 7, Price: NewMoney(99, "USD"), TaxRate: 2000},
+	}
+
+	for _, mode := range []string{TaxExclusive, TaxInclusive} {
+		totals := calculateBillTotals(lines, &Discount{Type: DiscountPercent, Value: 15}, 0, "USD")
+		totals = applyTaxes(totals, lines, nil, mode)
+
+		var sum int64
+		paid := paidLineAmounts(totals, lines, nil)
+		for _, amount := range paid {
+			sum += amount
+		}
+		if sum != totals.Total.Amount {
+			t.Errorf("Paid line amounts %v have to sum to %v total %v, got %v", paid, mode, totals.Total.Amount, sum)
+		}
+
+		// Returning line in several parts credits exactly its paid amount
+		for i, line := range lines {
+			var credited int64
+			for returned := 0; returned < line.Quantity; returned++ {
+				credited += creditAmount(paid[i], line.Quantity, returned, 1)
+			}
+			if credited != paid[i] {
+				t.Errorf("Returning line %+v one by one have to credit %v, got %v", line, paid[i], credited)
+			}
+		}
+	}
+}
+
+func TestTopBillProducts(t *testing.T) {
+	calculate := func(currency string, discount *Discount, lines ...BillLine) *billCalculation {
+		totals := calculateBillTotals(lines, discount, 0, currency)
+		totals = applyTaxes(totals, lines, nil, TaxExclusive)
+		return &billCalculation{lines: lines, totals: totals}
+	}
+	bills := map[int]*billCalculation{
+		1: calculate("USD", &Discount{Type: DiscountPercent, Value: 10},
+			BillLine{Product: 1, Name: "Phone", Quantity: 2, Price: NewMoney(500, "USD"), TaxRate: 1000},
+			BillLine{Product: 2, Name: "Case", Quantity: 3, Price: NewMoney(100, "USD")},
+		),
+		2: calculate("EUR", nil, BillLine{Product: 1, Name: "Phone", Quantity: 1, Price: NewMoney(450, "EUR")}),
+		3: calculate("USD", nil, BillLine{Product: 3, Name: "Cable", Quantity: 5, Price: NewMoney(10, "USD")}),
+	}
+	credits := []lineCredit{
+		{Bill: 1, Product: 2, Quantity: 1, Amount: 90},
+		{Bill: 3, Product: 3, Quantity: 5, Amount: 50},
+	}
+
+	// Spend includes bill's discount and tax less credited returns, fully returned product is omitted
+	expected := []CustomerTopProduct{
+		{Product: 1, Name: "Phone", Quantity: 2, Spend: NewMoney(990, "USD")},
+		{Product: 2, Name: "Case", Quantity: 2, Spend: NewMoney(180, "USD")},
+		{Product: 1, Name: "Phone", Quantity: 1, Spend: NewMoney(450, "EUR")},
+	}
+	if products := topBillProducts(bills, credits, 5); !cmp.Equal(products, expected) {
+		t.Errorf("Invalid top products: have to be %+v, got %+v", expected, products)
+	}
+	if products := topBillProducts(bills, credits, 1); len(products) != 1 || products[0].Product != 1 {
+		t.Errorf("Top products have to be limited: %+v", products)
+	}
+}
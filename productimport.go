@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// productImportColumns are columns of products' csv, the first five are required
+var productImportColumns = []string{"sku", "name", "description", "price", "quantity",
+	"currency", "category", "tax_class", "reorder_point", "reorder_quantity"}
+
+// productImportReader reads imported products one by one, so file is never loaded into memory entirely.
+// Next returns line where row starts and io.EOF after the last row. Invalid row is reported by *ApiError and reading
+// may continue, any other error aborts import. Columns returns productImportColumns set by the last row,
+// existing product keeps values of the other columns
+type productImportReader interface {
+	Next() (line int, dto ProductDTOAdd, err error)
+	Columns() map[string]bool
+}
+
+func newProductImportReader(format string, r io.Reader) (productImportReader, error) {
+	switch format {
+	case ProductImportCSV:
+		return newCSVProductReader(r)
+	case ProductImportNDJSON:
+		return &ndjsonProductReader{reader: bufio.NewReader(r)}, nil
+	default:
+		return nil, &ApiError{Err: fmt.Sprintf("unsupported import format %q", format)}
+	}
+}
+
+// csvProductReader reads products from csv with header of productImportColumns in any order.
+// Price is decimal amount in currency, which is default currency when omitted
+type csvProductReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVProductReader(r io.Reader) (*csvProductReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &ApiError{Err: "csv is empty"}
+	}
+	if err != nil {
+		return nil, &ApiError{Err: err.Error()}
+	}
+	reader.FieldsPerRecord = len(header)
+
+	known := map[string]bool{}
+	for _, column := range productImportColumns {
+		known[column] = true
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !known[column] {
+			return nil, &ApiError{Err: fmt.Sprintf("unknown csv column %q, columns are %v", column, strings.Join(productImportColumns, ","))}
+		}
+		if _, ok := columns[column]; ok {
+			return nil, &ApiError{Err: fmt.Sprintf("csv column %q is duplicated", column)}
+		}
+		columns[column] = i
+	}
+	for _, column := range productImportColumns[:5] {
+		if _, ok := columns[column]; !ok {
+			return nil, &ApiError{Err: fmt.Sprintf("csv have to contain %q column", column)}
+		}
+	}
+
+	return &csvProductReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvProductReader) Columns() map[string]bool {
+	columns := map[string]bool{}
+	for column := range c.columns {
+		columns[column] = true
+	}
+	return columns
+}
+
+func (c *csvProductReader) Next() (line int, dto ProductDTOAdd, err error) {
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return 0, dto, err
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.StartLine, dto, &ApiError{Err: parseErr.Err.Error()}
+		}
+		return 0, dto, err
+	}
+	line, _ = c.reader.FieldPos(0)
+
+	value := func(column string) string {
+		if i, ok := c.columns[column]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	integer := func(column string) (int, error) {
+		if value(column) == "" {
+			return 0, nil
+		}
+		v, err := strconv.Atoi(value(column))
+		if err != nil {
+			return 0, &ApiError{Err: fmt.Sprintf("invalid %v %q", column, value(column))}
+		}
+		return v, nil
+	}
+
+	dto = ProductDTOAdd{
+		Sku:         value("sku"),
+		Name:        value("name"),
+		Description: value("description"),
+		Category:    value("category"),
+	}
+	currency := value("currency")
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if dto.Price, err = ParseMoney(value("price"), currency); err != nil {
+		return line, dto, &ApiError{Err: err.Error()}
+	}
+	for _, field := range []struct {
+		column string
+		value  *int
+	}{
+		{"quantity", &dto.Quantity},
+		{"tax_class", &dto.TaxClass},
+		{"reorder_point", &dto.ReorderPoint},
+		{"reorder_quantity", &dto.ReorderQuantity},
+	} {
+		if *field.value, err = integer(field.column); err != nil {
+			return line, dto, err
+		}
+	}
+	return line, dto, nil
+}
+
+// ndjsonProductReader reads products from json lines, every line is product like in POST /product request.
+// Empty lines are skipped
+type ndjsonProductReader struct {
+	reader  *bufio.Reader
+	line    int
+	columns map[string]bool
+}
+
+func (n *ndjsonProductReader) Columns() map[string]bool {
+	return n.columns
+}
+
+func (n *ndjsonProductReader) Next() (line int, dto ProductDTOAdd, err error) {
+	for {
+		buf, err := n.reader.ReadBytes('\n')
+		if len(buf) == 0 && err != nil {
+			return 0, dto, err
+		}
+		n.line++
+
+		buf = bytes.TrimSpace(buf)
+		if len(buf) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(buf, &dto); err != nil {
+			return n.line, ProductDTOAdd{}, &ApiError{Err: fmt.Sprintf("invalid json: %v", err)}
+		}
+
+		// keys are matched to fields case-insensitively like by json.Unmarshal
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(buf, &object); err != nil {
+			return n.line, ProductDTOAdd{}, &ApiError{Err: fmt.Sprintf("invalid json: %v", err)}
+		}
+		n.columns = map[string]bool{}
+		for key, value := range object {
+			n.columns[strings.ToLower(key)] = true
+
+			// currency passed within price is its column, price without it is in default currency
+			var price map[string]json.RawMessage
+			if strings.EqualFold(key, "price") && json.Unmarshal(value, &price) == nil {
+				if _, ok := price["currency"]; ok {
+					n.columns["currency"] = true
+				}
+			}
+		}
+		return n.line, dto, nil
+	}
+}
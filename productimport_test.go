@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readImportedProducts reads all rows of import, row errors are collected by line
+func readImportedProducts(t *testing.T, format, file string) ([]ProductDTOAdd, map[int]string) {
+	reader, err := newProductImportReader(format, strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("Cannot read %v header: %+v", format, err)
+	}
+
+	dtos := []ProductDTOAdd{}
+	errs := map[int]string{}
+	for {
+		line, dto, err := reader.Next()
+		if err == io.EOF {
+			return dtos, errs
+		}
+		if apiErr, ok := err.(*ApiError); ok {
+			errs[line] = apiErr.Err
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Cannot read %v: %+v", format, err)
+		}
+		dtos = append(dtos, dto)
+	}
+}
+
+func TestProductImportReader(t *testing.T) {
+	expected := []ProductDTOAdd{
+		{Sku: "PH-1", Name: "Phone", Description: "Smart, phone", Price: NewMoney(19999, "USD"), Quantity: 5, Category: "phones"},
+		{Sku: "LP-1", Name: "Laptop", Description: "Light", Price: NewMoney(99900, "EUR"), Quantity: 2, TaxClass: 1, ReorderPoint: 1},
+	}
+
+	dtos, errs := readImportedProducts(t, ProductImportCSV, `SKU,name,description,price,quantity,currency,category,tax_class,reorder_point
+PH-1,Phone,"Smart, phone",199.99,5,,phones,,
+PH-2,Phone,Broken,9.999,1,,,,
+LP-1,Laptop,Light,999,2,EUR,,1,1
+LP-2,Laptop,Short
+LP-3,Laptop,Heavy,999,many,,,,
+`)
+	if !cmp.Equal(dtos, expected) {
+		t.Errorf("Invalid products of csv:\n have to be %+v\n got %+v", expected, dtos)
+	}
+	if len(errs) != 3 || errs[3] == "" || errs[5] == "" || !strings.Contains(errs[6], "quantity") {
+		t.Errorf("Invalid rows of csv have to be reported by lines 3, 5 and 6, got %v", errs)
+	}
+
+	dtos, errs = readImportedProducts(t, ProductImportNDJSON, `{"sku": "PH-1", "name": "Phone", "description": "Smart, phone", "price": {"amount": "199.99"}, "quantity": 5, "category": "phones"}
+
+{"sku": "PH-2", "price":
+{"sku": "LP-1", "name": "Laptop", "description": "Light", "price": {"amount": 999, "currency": "EUR"}, "quantity": 2, "tax_class": 1, "reorder_point": 1}`)
+	if !cmp.Equal(dtos, expected) {
+		t.Errorf("Invalid products of ndjson:\n have to be %+v\n got %+v", expected, dtos)
+	}
+	if len(errs) != 1 || errs[3] == "" {
+		t.Errorf("Invalid row of ndjson have to be reported by line 3, got %v", errs)
+	}
+
+	// Only columns present in file update existing product
+	reader, err := newProductImportReader(ProductImportCSV, strings.NewReader("sku,name,description,price,quantity,Category\nPH-1,Phone,Smart,199,5,phones"))
+	if err != nil {
+		t.Fatalf("Cannot read csv header: %+v", err)
+	}
+	if _, _, err := reader.Next(); err != nil {
+		t.Fatalf("Cannot read csv: %+v", err)
+	}
+	expectedColumns := map[string]bool{"sku": true, "name": true, "description": true, "price": true, "quantity": true, "category": true}
+	if !cmp.Equal(reader.Columns(), expectedColumns) {
+		t.Errorf("Columns of csv have to be %v, got %v", expectedColumns, reader.Columns())
+	}
+	reader, _ = newProductImportReader(ProductImportNDJSON, strings.NewReader(`{"sku": "PH-1", "Name": "Phone", "reorder_point": 0}
+{"sku": "PH-2"}
+{"sku": "PH-3", "price": {"amount": "9.99"}}
+{"sku": "PH-4", "price": {"amount": "9.99", "currency": "EUR"}}`))
+	for _, expected := range []map[string]bool{{"sku": true, "name": true, "reorder_point": true}, {"sku": true},
+		{"sku": true, "price": true}, {"sku": true, "price": true, "currency": true}} {
+		if _, _, err := reader.Next(); err != nil {
+			t.Fatalf("Cannot read ndjson: %+v", err)
+		}
+		if !cmp.Equal(reader.Columns(), expected) {
+			t.Errorf("Columns of ndjson have to be %v, got %v", expected, reader.Columns())
+		}
+	}
+
+	for _, header := range []string{"", "sku,name,description,price", "sku,name,description,price,quantity,weight", "sku,sku,name,description,price,quantity"} {
+		if _, err := newProductImportReader(ProductImportCSV, strings.NewReader(header)); err == nil {
+			t.Errorf("Reading csv with header %q have to fail", header)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// productSearchSimilarity is the least trigram similarity of misspelled word to word of product
+	productSearchSimilarity = 0.4
+	// productSearchDescriptionWeight is weight of description's matches, name's matches weigh 1
+	productSearchDescriptionWeight = 0.4
+	// productSearchSnippetWords is the most words of description's snippet
+	productSearchSnippetWords = 30
+)
+
+// productSearchStopWords are too common to be searched for
+var productSearchStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true, "by": true,
+	"for": true, "from": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true, "not": true,
+	"of": true, "on": true, "or": true, "so": true, "such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true, "to": true, "was": true, "will": true,
+	"with": true,
+}
+
+// searchTextWords splits text into lower-cased words of letters and digits like pg_trgm does
+func searchTextWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// productSearchWords returns distinct words of query without stop words
+func productSearchWords(q string) []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, word := range searchTextWords(q) {
+		if !productSearchStopWords[word] && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// stemWord strips common English inflections, so "phones" and "phone" or "charged" and "charging" have the same stem.
+// It's lighter than Snowball stemmer of Postgres, but agrees with it on plurals and verb forms
+func stemWord(word string) string {
+	switch n := len(word); {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:n-2]
+	case strings.HasSuffix(word, "ies") && n > 4:
+		word = word[:n-3] + "y"
+	case strings.HasSuffix(word, "s") && n > 3 &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = word[:n-1]
+	}
+
+	for _, suffix := range []string{"ing", "ed"} {
+		stem := strings.TrimSuffix(word, suffix)
+		if stem == word || len(stem) < 3 || !strings.ContainsAny(stem, "aeiouy") {
+			continue
+		}
+		// running -> run, but falling -> fall
+		if n := len(stem); stem[n-1] == stem[n-2] && !strings.ContainsRune("lsz", rune(stem[n-1])) {
+			stem = stem[:n-1]
+		}
+		word = stem
+		break
+	}
+
+	if len(word) > 3 {
+		word = strings.TrimSuffix(word, "e")
+	}
+	return word
+}
+
+// wordTrigrams returns trigrams of word padded by two spaces in front and one behind like pg_trgm does
+func wordTrigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	trigrams := map[string]bool{}
+	for i := 0; i+3 <= len(runes); i++ {
+		trigrams[string(runes[i:i+3])] = true
+	}
+	return trigrams
+}
+
+// trigramSimilarity is ratio of common trigrams of words to all their trigrams, like similarity() of pg_trgm
+func trigramSimilarity(a, b string) float64 {
+	trigramsA, trigramsB := wordTrigrams(a), wordTrigrams(b)
+	common := 0
+	for trigram := range trigramsA {
+		if trigramsB[trigram] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(trigramsA)+len(trigramsB)-common)
+}
+
+// searchWordMatch tells how well word of query matches word of text: 1 when their stems are equal,
+// their similarity when word is probably misspelled and 0 otherwise
+func searchWordMatch(query, word string) float64 {
+	if stemWord(query) == stemWord(word) {
+		return 1
+	}
+	if similarity := trigramSimilarity(query, word); similarity >= productSearchSimilarity {
+		return similarity
+	}
+	return 0
+}
+
+// searchTextMatch is the best match of word of query in text
+func searchTextMatch(query, text string) (match float64) {
+	for _, word := range searchTextWords(text) {
+		if m := searchWordMatch(query, word); m > match {
+			match = m
+		}
+	}
+	return
+}
+
+// rankProduct returns average of the best matches of query's words in product's name or weighted description.
+// Product matches only when every word matches
+func rankProduct(product Product, words []string) (rank float64, ok bool) {
+	if len(words) == 0 {
+		return 0, false
+	}
+	for _, word := range words {
+		name := searchTextMatch(word, product.Name)
+		description := searchTextMatch(word, product.Description)
+		if name == 0 && description == 0 {
+			return 0, false
+		}
+		if description*productSearchDescriptionWeight > name {
+			name = description * productSearchDescriptionWeight
+		}
+		rank += name
+	}
+	return rank / float64(len(words)), true
+}
+
+// searchProducts is in-memory counterpart of SearchProducts, it ranks products the same way
+// except that stemming is lighter
+func searchProducts(products []Product, q string, limit, offset int) []ProductSearchResult {
+	words := productSearchWords(q)
+	results := []ProductSearchResult{}
+	for _, product := range products {
+		if rank, ok := rankProduct(product, words); ok {
+			results = append(results, ProductSearchResult{Product: product, Rank: rank})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Id < results[j].Id
+	})
+
+	if offset >= len(results) {
+		return []ProductSearchResult{}
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Highlight = highlightProduct(results[i].Product, words)
+	}
+	return results
+}
+
+func highlightProduct(product Product, words []string) ProductSearchHighlight {
+	return ProductSearchHighlight{
+		Name:        highlightText(product.Name, words, 0),
+		Description: highlightText(product.Description, words, productSearchSnippetWords),
+	}
+}
+
+// highlightText escapes text and wraps its words matching query's words in <b> tags. When maxWords is positive
+// text is cut to maxWords words around the first match
+func highlightText(text string, words []string, maxWords int) string {
+	type span struct {
+		start, end int
+		match      bool
+	}
+	spans := []span{}
+	start := -1
+	for i, r := range text + " " {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			word := strings.ToLower(text[start:i])
+			match := false
+			for _, query := range words {
+				if searchWordMatch(query, word) > 0 {
+					match = true
+					break
+				}
+			}
+			spans = append(spans, span{start, i, match})
+			start = -1
+		}
+	}
+	if len(spans) == 0 {
+		return html.EscapeString(text)
+	}
+
+	first, last := 0, len(spans)-1
+	if maxWords > 0 && len(spans) > maxWords {
+		for i, s := range spans {
+			if s.match {
+				// leave a third of snippet for context before the match
+				first = i - maxWords/3
+				break
+			}
+		}
+		if first > len(spans)-maxWords {
+			first = len(spans) - maxWords
+		}
+		if first < 0 {
+			first = 0
+		}
+		last = first + maxWords - 1
+	}
+
+	var b strings.Builder
+	if first > 0 {
+		b.WriteString("… ")
+	}
+	offset := spans[first].start
+	if first == 0 {
+		offset = 0
+	}
+	for _, s := range spans[first : last+1] {
+		b.WriteString(html.EscapeString(text[offset:s.start]))
+		if s.match {
+			b.WriteString("<b>" + html.EscapeString(text[s.start:s.end]) + "</b>")
+		} else {
+			b.WriteString(html.EscapeString(text[s.start:s.end]))
+		}
+		offset = s.end
+	}
+	if last < len(spans)-1 {
+		b.WriteString(" …")
+	} else {
+		b.WriteString(html.EscapeString(text[offset:]))
+	}
+	return b.String()
+}
+
+// searchProductsMatch is match of term's word in the field: 1 when full-text search finds the word in it,
+// the best trigram similarity of the word to field's words when it's at least $2 and 0 otherwise
+const searchProductsMatch = `CASE WHEN to_tsvector('english', %[1]s) @@ term.tsquery THEN 1
+		ELSE COALESCE((SELECT MAX(similarity(term.word, w)) FROM regexp_split_to_table(lower(%[1]s), '[^[:alnum:]]+') AS w
+			WHERE similarity(term.word, w) >= $2), 0) END`
+
+// searchProductsCandidate tells whether product may match %[1]d-th word of $1, so only candidates are ranked.
+// It's found by product_search_document_idx when stems are equal and by product_search_text_idx when word resembles
+// text. Word similarity to text is at least trigram similarity to any of its words, so no match is missed
+const searchProductsCandidate = `((to_tsvector('english', product.name) || to_tsvector('english', COALESCE(product.description, '')))
+		@@ plainto_tsquery('english', ($1::text[])[%[1]d])
+		OR ($1::text[])[%[1]d] <%% (product.name || ' ' || COALESCE(product.description, '')))`
+
+// SearchProducts looks for products having every word of query in their name or description. Words are matched by
+// their stems with full-text search and misspelled ones by trigram similarity. Best matches go first
+func (s *Service) SearchProducts(ctx context.Context, q string, limit, offset int) ([]ProductSearchResult, error) {
+	words := productSearchWords(q)
+	results := []ProductSearchResult{}
+	if len(words) == 0 {
+		return results, nil
+	}
+	candidates := make([]string, len(words))
+	for i := range words {
+		candidates[i] = fmt.Sprintf(searchProductsCandidate, i+1)
+	}
+
+	tx := s.db.MustBeginTx(ctx, nil)
+	defer tx.Rollback()
+
+	// <% finds words at least as similar as matching requires
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		fmt.Sprint(productSearchSimilarity)); err != nil {
+		return nil, err
+	}
+	if err := tx.SelectContext(ctx, &results, `
+	WITH term AS (
+		SELECT word, plainto_tsquery('english', word) AS tsquery FROM unnest($1::text[]) AS word
+	)
+	SELECT `+productColumns+`, score.rank FROM product
+	JOIN LATERAL (
+		SELECT bool_and(GREATEST(term_match.name, term_match.description) > 0) AS matched,
+			AVG(GREATEST(term_match.name, $3 * term_match.description)) AS rank
+		FROM term
+		CROSS JOIN LATERAL (
+			SELECT `+fmt.Sprintf(searchProductsMatch, "product.name")+` AS name,
+				`+fmt.Sprintf(searchProductsMatch, "COALESCE(product.description, '')")+` AS description
+		) AS term_match
+	) AS score ON score.matched
+	WHERE product.archived_at IS NULL AND `+strings.Join(candidates, " AND ")+`
+	ORDER BY score.rank DESC, product.id
+	LIMIT $4 OFFSET $5
+	`, pq.Array(words), productSearchSimilarity, productSearchDescriptionWeight, limit, offset); err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Highlight = highlightProduct(results[i].Product, words)
+	}
+	return results, nil
+}
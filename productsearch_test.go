@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// searchProductsFixture are products of search tests, ids are in order of adding to database
+var searchProductsFixture = []Product{
+	{Id: 1, Name: "Samsung Galaxy S21", Description: "Android phone with great camera", Price: NewMoney(79900, "USD")},
+	{Id: 2, Name: "Galaxy Watch", Description: "Smart watch by Samsung, charges wirelessly", Price: NewMoney(29900, "USD")},
+	{Id: 3, Name: "Phone case", Description: "Leather case for Samsung phones", Price: NewMoney(1900, "USD")},
+	{Id: 4, Name: "Charging cable", Description: "USB-C cable <2m> & adapter", Price: NewMoney(900, "USD")},
+}
+
+// searchResultIds returns ids of found products in their order
+func searchResultIds(results []ProductSearchResult) []int {
+	ids := []int{}
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+	return ids
+}
+
+func TestStemWord(t *testing.T) {
+	for _, words := range [][]string{
+		{"phone", "phones"},
+		{"charge", "charges", "charged", "charging"},
+		{"battery", "batteries"},
+		{"glass", "glasses"},
+		{"run", "running"},
+		{"fall", "falling"},
+	} {
+		for _, word := range words[1:] {
+			if stemWord(word) != stemWord(words[0]) {
+				t.Errorf("%q and %q have to have the same stem, got %q and %q", words[0], word, stemWord(words[0]), stemWord(word))
+			}
+		}
+	}
+	if stemWord("case") == stemWord("cable") {
+		t.Errorf("Different words have to have different stems")
+	}
+}
+
+func TestSearchProducts(t *testing.T) {
+	for _, c := range []struct {
+		q   string
+		ids []int
+	}{
+		// misspelled word is found by trigrams, name's matches outrank description's ones
+		{"samsng galaxy", []int{1, 2}},
+		{"samsung", []int{1, 2, 3}},
+		// stems of words are matched
+		{"phones", []int{3, 1}},
+		{"charged", []int{4, 2}},
+		// every word have to match
+		{"samsung cable", []int{}},
+		// stop words are ignored
+		{"the case", []int{3}},
+		{"the", []int{}},
+	} {
+		if ids := searchResultIds(searchProducts(searchProductsFixture, c.q, 10, 0)); !cmp.Equal(ids, c.ids) {
+			t.Errorf("Search of %q have to find %v, got %v", c.q, c.ids, ids)
+		}
+	}
+
+	results := searchProducts(searchProductsFixture, "samsung", 1, 1)
+	if ids := searchResultIds(results); !cmp.Equal(ids, []int{2}) {
+		t.Errorf("Page of search have to contain the second product, got %v", ids)
+	}
+	if results[0].Rank <= 0 || results[0].Rank > 1 {
+		t.Errorf("Rank have to be in range (0, 1], got %v", results[0].Rank)
+	}
+}
+
+func TestHighlightText(t *testing.T) {
+	words := productSearchWords("samsng cables")
+	for _, c := range []struct {
+		text     string
+		maxWords int
+		expected string
+	}{
+		{"Samsung Galaxy S21", 0, "<b>Samsung</b> Galaxy S21"},
+		{"USB-C cable <2m> & adapter", 0, "USB-C <b>cable</b> &lt;2m&gt; &amp; adapter"},
+		{"", 0, ""},
+		{"a b c d e f g h cable i j", 4, "… h <b>cable</b> i j"},
+		{"cable a b c", 2, "<b>cable</b> a …"},
+		{"a b c d e f g h cable i j k l", 6, "… g h <b>cable</b> i j k …"},
+		{"a b c cable", 2, "… c <b>cable</b>"},
+	} {
+		if highlighted := highlightText(c.text, words, c.maxWords); highlighted != c.expected {
+			t.Errorf("Highlight of %q have to be %q, got %q", c.text, c.expected, highlighted)
+		}
+	}
+
+	highlight := highlightProduct(searchProductsFixture[1], productSearchWords("charging"))
+	if !strings.Contains(highlight.Description, "<b>charges</b>") {
+		t.Errorf("Description have to highlight word of the same stem, got %q", highlight.Description)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// Content types receipts are rendered in
+const (
+	ReceiptText = "text/plain"
+	ReceiptHTML = "text/html"
+)
+
+var receiptTemplates = map[string]string{
+	ReceiptText: "receipt.txt.tmpl",
+	ReceiptHTML: "receipt.html.tmpl",
+}
+
+// receiptFuncs lay out lines of plain receipts, widths are counted in characters
+var receiptFuncs = template.FuncMap{
+	"truncate": truncateRunes,
+	// center prepends spaces to text to put it in the middle of line
+	"center": func(width int, text string) string {
+		text = truncateRunes(width, text)
+		return strings.Repeat(" ", (width-utf8.RuneCountInString(text))/2) + text
+	},
+	// spread puts left text at line's start and right one at its end, left text is truncated to fit both
+	"spread": func(width int, left, right string) string {
+		left = truncateRunes(width-utf8.RuneCountInString(right)-1, left)
+		gap := width - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+		if gap < 1 {
+			gap = 1
+		}
+		return left + strings.Repeat(" ", gap) + right
+	},
+	"repeat": func(count int, s string) string {
+		return strings.Repeat(s, count)
+	},
+}
+
+func truncateRunes(width int, text string) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if width <= 0 {
+		return ""
+	}
+	return string(runes[:width])
+}
+
+// RenderReceipt renders bill's receipt in passed content type by receipt.txt.tmpl or receipt.html.tmpl template
+func (s *Service) RenderReceipt(ctx context.Context, id int, contentType string) ([]byte, error) {
+	name, ok := receiptTemplates[contentType]
+	if !ok {
+		return nil, &ApiError{Err: "Unsupported receipt's content type:" + contentType}
+	}
+
+	document, err := s.billDocument(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	text, err := s.readTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return renderReceipt(contentType, text, document)
+}
+
+// renderReceipt executes receipt's template, HTML one escapes bill's content
+func renderReceipt(contentType, text string, document *billDocument) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	name := receiptTemplates[contentType]
+	if contentType == ReceiptHTML {
+		tmpl, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(documentFuncs)).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		if err := tmpl.Execute(buf, document); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	tmpl, err := template.New(name).Funcs(documentFuncs).Funcs(receiptFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(buf, document); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
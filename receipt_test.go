@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderReceipt(t *testing.T) {
+	document := testBillDocument()
+	document.Bill.Products[1].Name = "<b>Cookbook</b> & Pantry Recipes for Everyone"
+	document.Bill.Payments = []Payment{{Amount: NewMoney(1000, "EUR"), Method: "cash"}}
+	document.Bill.Balance = NewMoney(2189, "EUR")
+
+	read := func(name string) string {
+		text, err := defaultTemplates.ReadFile("templates/" + name)
+		if err != nil {
+			t.Fatalf("Cannot read default template: %+v", err)
+		}
+		return string(text)
+	}
+
+	text, err := renderReceipt(ReceiptText, read("receipt.txt.tmpl"), document)
+	if err != nil {
+		t.Fatalf("Cannot render plain receipt: %+v", err)
+	}
+	for _, line := range strings.Split(string(text), "\n") {
+		if utf8.RuneCountInString(line) > 32 {
+			t.Errorf("Line %q of plain receipt is wider than 32 characters", line)
+		}
+	}
+	for _, expected := range []string{
+		"Receipt          INV-2023-000042\n",
+		"Café crème\n  2 x 4.50                  9.00\n",
+		"<b>Cookbook</b> & Pantry Recipes\n",
+		"Tax 5.5%                    1.10\n",
+		"TOTAL                  31.89 EUR\n",
+		"Paid by cash               10.00\n",
+		"Balance                21.89 EUR\n",
+	} {
+		if !strings.Contains(string(text), expected) {
+			t.Errorf("Plain receipt have to contain %q, got:\n%s", expected, text)
+		}
+	}
+
+	html, err := renderReceipt(ReceiptHTML, read("receipt.html.tmpl"), document)
+	if err != nil {
+		t.Fatalf("Cannot render HTML receipt: %+v", err)
+	}
+	for _, expected := range []string{
+		"<title>Receipt INV-2023-000042</title>",
+		"<td>Café crème</td>",
+		"<td>&lt;b&gt;Cookbook&lt;/b&gt; &amp; Pantry Recipes for Everyone</td>",
+		`<td class="number">31.89 EUR</td>`,
+	} {
+		if !strings.Contains(string(html), expected) {
+			t.Errorf("HTML receipt have to contain %q, got:\n%s", expected, html)
+		}
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":          ReceiptText,
+		"*/*":       ReceiptText,
+		"text/html": ReceiptHTML,
+		"text/html,application/xhtml+xml,*/*;q=0.8": ReceiptHTML,
+		"text/plain;q=0.5, text/html":               ReceiptHTML,
+		"text/*":                                    ReceiptText,
+		"text/plain;q=0, */*":                       ReceiptHTML,
+		"application/json":                          "",
+		"TEXT/HTML; q=0.9":                          ReceiptHTML,
+	} {
+		if actual := negotiateContentType(accept, ReceiptText, ReceiptHTML); actual != expected {
+			t.Errorf("Accept %q: expected %q, got %q", accept, expected, actual)
+		}
+	}
+}
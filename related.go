@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Sort orders of related products
+const (
+	RelatedSortConfidence = "confidence"
+	RelatedSortLift       = "lift"
+	RelatedSortSupport    = "support"
+)
+
+// RefreshProductAssociations recomputes which products are bought together from all bills. Pairs of products found
+// in less than minSupport share of bills are dropped. Associations are replaced in single transaction,
+// so readers see either previous or new ones
+func (s *Service) RefreshProductAssociations(ctx context.Context, minSupport float64) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		// concurrent refreshes would insert the same pairs, reading isn't blocked
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE productassociation IN EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM productassociation"); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+		WITH total AS (
+			SELECT COUNT(DISTINCT productbill.bill_id) AS bills FROM productbill
+		), item AS (
+			SELECT productbill.product_id, COUNT(*) AS bills FROM productbill GROUP BY productbill.product_id
+		), pair AS (
+			SELECT line.product_id, related.product_id AS related_id, COUNT(*) AS bills
+			FROM productbill AS line
+			JOIN productbill AS related ON related.bill_id = line.bill_id AND related.product_id <> line.product_id
+			GROUP BY line.product_id, related.product_id
+		)
+		INSERT INTO productassociation (product_id, related_id, bills, support, confidence, lift)
+		SELECT pair.product_id, pair.related_id, pair.bills,
+			pair.bills::float8 / total.bills,
+			pair.bills::float8 / item.bills,
+			pair.bills::float8 * total.bills / (item.bills * related_item.bills)
+		FROM pair
+		JOIN item ON item.product_id = pair.product_id
+		JOIN item AS related_item ON related_item.product_id = pair.related_id
+		CROSS JOIN total
+		WHERE pair.bills::float8 / total.bills >= $1
+		`, minSupport)
+		return err
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRelatedProducts returns products bought together with product by the last refresh of associations,
+// the best ones by sort go first
+func (s *Service) GetRelatedProducts(ctx context.Context, id int, sort string, limit int) ([]RelatedProduct, error) {
+	orders := map[string]string{
+		RelatedSortConfidence: "productassociation.confidence DESC, productassociation.lift DESC",
+		RelatedSortLift:       "productassociation.lift DESC, productassociation.confidence DESC",
+		RelatedSortSupport:    "productassociation.support DESC, productassociation.confidence DESC",
+	}
+	order, ok := orders[sort]
+	if !ok {
+		return nil, &ApiError{Err: fmt.Sprintf("Unknown sort %q, expected %v, %v or %v", sort, RelatedSortConfidence, RelatedSortLift, RelatedSortSupport)}
+	}
+	if _, err := s.GetProductById(ctx, id); err != nil {
+		return nil, err
+	}
+
+	related := []RelatedProduct{}
+	if err := s.db.SelectContext(ctx, &related, `
+	SELECT `+productColumns+`, productassociation.bills, productassociation.support,
+		productassociation.confidence, productassociation.lift
+	FROM productassociation
+	JOIN product ON product.id = productassociation.related_id
+	WHERE productassociation.product_id = $1 AND product.archived_at IS NULL
+	ORDER BY `+order+`, product.id
+	LIMIT $2
+	`, id, limit); err != nil {
+		return nil, err
+	}
+	return related, nil
+}
+
+// RelatedProductsRefresher periodically recomputes products bought together
+type RelatedProductsRefresher struct {
+	s          *Service
+	minSupport float64
+	interval   time.Duration
+}
+
+func NewRelatedProductsRefresher(s *Service, minSupport float64, interval time.Duration) *RelatedProductsRefresher {
+	return &RelatedProductsRefresher{s: s, minSupport: minSupport, interval: interval}
+}
+
+func (r *RelatedProductsRefresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.s.RefreshProductAssociations(ctx, r.minSupport); err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
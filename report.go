@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// reportFilterCondition narrows reports to bills created in range of the first two query arguments
+const reportFilterCondition = `($1::timestamp IS NULL OR bill.created_at >= $1)
+		AND ($2::timestamp IS NULL OR bill.created_at < $2)
+		AND bill.status <> 'void'`
+
+// GetSalesReport sums bills by day, week or month they are created in and by their currency. Periods without bills
+// are omitted
+func (s *Service) GetSalesReport(ctx context.Context, filter ReportFilter, group string) ([]SalesReportRow, error) {
+	switch group {
+	case ReportDay, ReportWeek, ReportMonth:
+	default:
+		return nil, &ApiError{Err: fmt.Sprintf("Unknown grouping %q, expected %v, %v or %v", group, ReportDay, ReportWeek, ReportMonth)}
+	}
+
+	rows := []SalesReportRow{}
+	if err := s.db.SelectContext(ctx, &rows, `
+	SELECT to_char(date_trunc($3, bill.created_at), 'YYYY-MM-DD') AS period, COUNT(*) AS bills,
+		COALESCE(SUM(line.quantity), 0) AS quantity, SUM(bill.total) AS "revenue.amount", bill.currency AS "revenue.currency"
+	FROM bill
+	LEFT JOIN LATERAL (
+		SELECT SUM(productbill.quantity) AS quantity FROM productbill WHERE productbill.bill_id = bill.id
+	) AS line ON TRUE
+	WHERE `+reportFilterCondition+`
+	GROUP BY period, bill.currency
+	ORDER BY period, bill.currency
+	`, filter.From, filter.To, group); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// GetTopProductsReport returns the most sold products, the same product sold in different currencies
+// has row for each of them
+func (s *Service) GetTopProductsReport(ctx context.Context, filter ReportFilter) ([]TopProductReportRow, error) {
+	rows := []TopProductReportRow{}
+	if err := s.db.SelectContext(ctx, &rows, `
+	SELECT product.id AS product_id, COALESCE(product.sku, '') AS sku, product.name, COUNT(*) AS bills,
+		SUM(productbill.quantity) AS quantity, SUM(productbill.quantity * productbill.price) AS "revenue.amount",
+		bill.currency AS "revenue.currency"
+	FROM productbill
+	JOIN bill ON bill.id = productbill.bill_id
+	JOIN product ON product.id = productbill.product_id
+	WHERE `+reportFilterCondition+`
+	GROUP BY product.id, bill.currency
+	ORDER BY quantity DESC, "revenue.amount" DESC, product.id, bill.currency
+	LIMIT $3
+	`, filter.From, filter.To, filter.Limit); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// GetTopCustomersReport returns customers with the greatest sum of bills, customer paying in different currencies
+// has row for each of them
+func (s *Service) GetTopCustomersReport(ctx context.Context, filter ReportFilter) ([]TopCustomerReportRow, error) {
+	rows := []TopCustomerReportRow{}
+	if err := s.db.SelectContext(ctx, &rows, `
+	SELECT customer.id AS customer_id, customer.first_name, customer.last_name, COALESCE(customer.email, '') AS email,
+		COUNT(*) AS bills, SUM(bill.total) AS "revenue.amount", bill.currency AS "revenue.currency"
+	FROM bill
+	JOIN customer ON customer.id = bill.customer_id
+	WHERE `+reportFilterCondition+`
+	GROUP BY customer.id, bill.currency
+	ORDER BY "revenue.amount" DESC, bills DESC, customer.id, bill.currency
+	LIMIT $3
+	`, filter.From, filter.To, filter.Limit); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+var salesReportColumns = []exportColumn[SalesReportRow]{
+	{"period", func(r *SalesReportRow) any { return r.Period }},
+	{"currency", func(r *SalesReportRow) any { return r.Revenue.Currency }},
+	{"bills", func(r *SalesReportRow) any { return r.Bills }},
+	{"quantity", func(r *SalesReportRow) any { return r.Quantity }},
+	{"revenue", func(r *SalesReportRow) any { return exportDecimal(r.Revenue.String()) }},
+}
+
+var topProductReportColumns = []exportColumn[TopProductReportRow]{
+	{"product", func(r *TopProductReportRow) any { return r.Product }},
+	{"sku", func(r *TopProductReportRow) any { return r.Sku }},
+	{"name", func(r *TopProductReportRow) any { return r.Name }},
+	{"currency", func(r *TopProductReportRow) any { return r.Revenue.Currency }},
+	{"bills", func(r *TopProductReportRow) any { return r.Bills }},
+	{"quantity", func(r *TopProductReportRow) any { return r.Quantity }},
+	{"revenue", func(r *TopProductReportRow) any { return exportDecimal(r.Revenue.String()) }},
+}
+
+var topCustomerReportColumns = []exportColumn[TopCustomerReportRow]{
+	{"customer", func(r *TopCustomerReportRow) any { return r.Customer }},
+	{"first_name", func(r *TopCustomerReportRow) any { return r.FirstName }},
+	{"last_name", func(r *TopCustomerReportRow) any { return r.LastName }},
+	{"email", func(r *TopCustomerReportRow) any { return r.Email }},
+	{"currency", func(r *TopCustomerReportRow) any { return r.Revenue.Currency }},
+	{"bills", func(r *TopCustomerReportRow) any { return r.Bills }},
+	{"revenue", func(r *TopCustomerReportRow) any { return exportDecimal(r.Revenue.String()) }},
+}
+
+// writeReportCSV writes rows of report as csv with header of columns' names
+func writeReportCSV[T any](w io.Writer, columns []exportColumn[T], rows []T) error {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	writer, err := newExportWriter(ExportCSV, w, names)
+	if err != nil {
+		return err
+	}
+
+	values := make([]any, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			values[i] = column.value(&row)
+		}
+		if err := writer.Write(values); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteReportCSV(t *testing.T) {
+	rows := []SalesReportRow{
+		{Period: "2023-04-03", Bills: 2, Quantity: 5, Revenue: NewMoney(1250, "USD")},
+		{Period: "2023-04-03", Bills: 1, Quantity: 1, Revenue: NewMoney(900, "JPY")},
+	}
+	buf := &strings.Builder{}
+	if err := writeReportCSV(buf, salesReportColumns, rows); err != nil {
+		t.Fatalf("Cannot write report: %+v", err)
+	}
+	expected := "period,currency,bills,quantity,revenue\n2023-04-03,USD,2,5,12.50\n2023-04-03,JPY,1,1,900\n"
+	if buf.String() != expected {
+		t.Errorf("Invalid csv of report:\n have to be %q\n got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeReportCSV(buf, topCustomerReportColumns, nil); err != nil {
+		t.Fatalf("Cannot write report: %+v", err)
+	}
+	if expected := "customer,first_name,last_name,email,currency,bills,revenue\n"; buf.String() != expected {
+		t.Errorf("Empty report have to contain header, got %q", buf.String())
+	}
+}
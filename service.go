@@ -1,13 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
-	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
@@ -21,19 +26,53 @@ func (a *ApiError) Error() string {
 }
 
 type Service struct {
-	db *sqlx.DB
+	db     *sqlx.DB
+	config *config
 }
 
-func NewService(db *sqlx.DB) *Service {
+func NewService(db *sqlx.DB, config *config) *Service {
 	return &Service{
-		db: db,
+		db:     db,
+		config: config,
 	}
 }
 
 // Product-related methods
+const productColumns = `product.id, COALESCE(product.sku, '') AS sku, product.name, product.description,
+	product.price AS "price.amount", product.currency AS "price.currency", product.quantity,
+	product.category, COALESCE(product.tax_class_id, 0) AS tax_class, product.reorder_point, product.reorder_quantity,
+	product.archived_at`
+
+// productError converts reference to absent tax class and duplicated sku into ApiError
+func productError(err error) error {
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code {
+		case pq.ErrorCode("23503"): // foreign_key_violation
+			return &ApiError{"Tax class with passed id not exists"}
+		case pq.ErrorCode("23505"): // unique_violation
+			return &ApiError{"Product with passed sku already exists"}
+		}
+	}
+	return err
+}
+
 func (s *Service) GetProducts(ctx context.Context) (products []Product, err error) {
 	if err = s.db.SelectContext(ctx, &products, `
-	SELECT product.id, product.name, product.description, product.price, product.quantity FROM product
+	SELECT `+productColumns+` FROM product
+	WHERE product.archived_at IS NULL
+	`); err != nil {
+		return
+	}
+	return
+}
+
+// GetArchivedProducts returns products hidden by deletion, the latest archived first
+func (s *Service) GetArchivedProducts(ctx context.Context) (products []Product, err error) {
+	products = []Product{}
+	if err = s.db.SelectContext(ctx, &products, `
+	SELECT `+productColumns+` FROM product
+	WHERE product.archived_at IS NOT NULL
+	ORDER BY product.archived_at DESC, product.id
 	`); err != nil {
 		return
 	}
@@ -43,8 +82,8 @@ func (s *Service) GetProducts(ctx context.Context) (products []Product, err erro
 func (s *Service) GetProductById(ctx context.Context, id int) (*Product, error) {
 	product := &Product{}
 	if err := s.db.GetContext(ctx, product, `
-	SELECT product.id, product.name, product.description, product.price, product.quantity FROM product
-	WHERE id = $1
+	SELECT `+productColumns+` FROM product
+	WHERE id = $1 AND archived_at IS NULL
 	`, id); err != nil {
 		if err == sql.ErrNoRows {
 			err = &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", id)}
@@ -54,253 +93,3183 @@ func (s *Service) GetProductById(ctx context.Context, id int) (*Product, error)
 	return product, nil
 }
 
+// validatePrice checks that price of product or of supplier's product is positive and is in known currency
+func validatePrice(price Money) error {
+	if price.Amount <= 0 {
+		return &ApiError{Err: fmt.Sprintf("price have to be positive, got %v", price)}
+	}
+	if _, ok := currencyExponents[price.Currency]; !ok {
+		return &ApiError{Err: fmt.Sprintf("unsupported currency %q", price.Currency)}
+	}
+	return nil
+}
+
 func (s *Service) AddProduct(ctx context.Context, dto ProductDTOAdd) (*Product, error) {
-	var product Product
-	resp, err := s.db.NamedQueryContext(ctx, `
-	INSERT INTO product (name, description, price, quantity) VALUES (:name, :description, :price, :quantity) RETURNING id
-	`, &dto)
+	if err := validatePrice(dto.Price); err != nil {
+		return nil, err
+	}
+
+	product := Product{
+		Sku:             dto.Sku,
+		Name:            dto.Name,
+		Description:     dto.Description,
+		Price:           dto.Price,
+		Quantity:        dto.Quantity,
+		Category:        dto.Category,
+		TaxClass:        dto.TaxClass,
+		ReorderPoint:    dto.ReorderPoint,
+		ReorderQuantity: dto.ReorderQuantity,
+	}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO product (sku, name, description, price, currency, quantity, category, tax_class_id, reorder_point, reorder_quantity)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7, NULLIF($8, 0), $9, $10) RETURNING id
+		`, dto.Sku, dto.Name, dto.Description, dto.Price.Amount, dto.Price.Currency, dto.Quantity, dto.Category,
+			dto.TaxClass, dto.ReorderPoint, dto.ReorderQuantity).Scan(&product.Id); err != nil {
+			return productError(err)
+		}
+		return s.audit(ctx, tx, AuditProduct, product.Id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &product, nil
+}
+
+func (s *Service) UpdateProductById(ctx context.Context, dto ProductDTOUpdate) error {
+	if err := validatePrice(dto.Price); err != nil {
+		return err
+	}
+
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditProduct, dto.Id)
+		if err != nil {
+			return err
+		}
+		resp, err := tx.NamedExecContext(ctx, `
+		UPDATE product
+		SET sku = NULLIF(:sku, ''), name = :name, description = :description, price = :price.amount, currency = :price.currency,
+			quantity = :quantity, category = :category,
+			tax_class_id = NULLIF(:tax_class, 0), reorder_point = :reorder_point, reorder_quantity = :reorder_quantity
+		WHERE id = :id AND archived_at IS NULL
+		`, &dto)
+		if err != nil {
+			return productError(err)
+		}
+		if count, _ := resp.RowsAffected(); count == 0 {
+			var archived bool
+			if err := tx.QueryRowContext(ctx, "SELECT archived_at IS NOT NULL FROM product WHERE id = $1", dto.Id).Scan(&archived); err != nil {
+				if err == sql.ErrNoRows {
+					return &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", dto.Id)}
+				}
+				return err
+			}
+			return &ApiError{Err: fmt.Sprintf("Product with passed id:%v is archived, restore it first", dto.Id)}
+		}
+		return s.audit(ctx, tx, AuditProduct, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// GetLowStockProducts returns products which quantity fell to or below their reorder point.
+// Products with zero reorder point are not tracked
+func (s *Service) GetLowStockProducts(ctx context.Context) (products []Product, err error) {
+	products = []Product{}
+	if err = s.db.SelectContext(ctx, &products, `
+	SELECT `+productColumns+` FROM product
+	WHERE product.archived_at IS NULL AND product.reorder_point > 0 AND product.quantity <= product.reorder_point
+	ORDER BY product.id
+	`); err != nil {
+		return
+	}
+	return
+}
+
+// DeleteProductById archives product. Archived product is hidden from products and cannot be added to new bills
+// or carts, but stays in existing bills. It is removed from carts, so they can be checked out
+func (s *Service) DeleteProductById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditProduct, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE product SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL
+		`, id); err != nil {
+			return err
+		}
+		// archiving only changes archived_at, so it's recorded as update like restoring
+		if err := s.audit(ctx, tx, AuditProduct, id, AuditUpdate, before); err != nil {
+			return err
+		}
+
+		var carts []int
+		if err := tx.SelectContext(ctx, &carts, `
+		SELECT cart_id FROM cartitem WHERE product_id = $1 ORDER BY cart_id
+		`, id); err != nil {
+			return err
+		}
+		for _, cart := range carts {
+			before, err := auditSnapshot(ctx, tx, AuditCart, cart)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM cartitem WHERE cart_id = $1 AND product_id = $2", cart, id); err != nil {
+				return err
+			}
+			if err := s.audit(ctx, tx, AuditCart, cart, AuditUpdate, before); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// RestoreProductById brings archived product back. Restoring fails when its sku is taken by another product meanwhile
+func (s *Service) RestoreProductById(ctx context.Context, id int) (*Product, error) {
+	product := &Product{}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditProduct, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.GetContext(ctx, product, `
+		UPDATE product SET archived_at = NULL WHERE id = $1 AND archived_at IS NOT NULL
+		RETURNING `+productColumns+`
+		`, id); err != nil {
+			if err == sql.ErrNoRows {
+				err = &ApiError{Err: fmt.Sprintf("Archived product with passed id:%v not exists", id)}
+			}
+			return productError(err)
+		}
+		return s.audit(ctx, tx, AuditProduct, id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return product, nil
+}
+
+// ImportProducts upserts products read from csv or ndjson by their sku within single transaction. Every row is passed
+// to report as soon as it is processed. In all-or-nothing mode import is committed only when every row succeeds,
+// in best-effort mode failed rows are skipped. Dry run is never committed. Summary is returned even if import is aborted
+func (s *Service) ImportProducts(ctx context.Context, r io.Reader, options ProductImportOptions, report func(ProductImportRow) error) (*ProductImportSummary, error) {
+	if options.Mode != ProductImportAllOrNothing && options.Mode != ProductImportBestEffort {
+		return nil, &ApiError{Err: fmt.Sprintf("unsupported import mode %q", options.Mode)}
+	}
+	reader, err := newProductImportReader(options.Format, r)
 	if err != nil {
 		return nil, err
 	}
 
-	for resp.Next() {
-		if err := resp.Scan(&product.Id); err != nil {
-			return nil, err
-		}
-		product.Name = dto.Name
-		product.Description = dto.Description
-		product.Price = dto.Price
-		product.Quantity = dto.Quantity
+	summary := &ProductImportSummary{DryRun: options.DryRun}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		for {
+			line, dto, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+
+			row := ProductImportRow{Line: line, Sku: dto.Sku}
+			if err == nil {
+				err = s.validateImportedProduct(dto, options.Validate)
+			}
+			if err == nil {
+				row.Id, row.Action, err = s.importProduct(ctx, tx, dto, reader.Columns())
+			}
+			if err != nil {
+				apiErr, ok := err.(*ApiError)
+				if !ok {
+					return err
+				}
+				row.Action, row.Error = ProductImportFailed, apiErr.Err
+			}
+
+			switch row.Action {
+			case ProductImportCreated:
+				summary.Created++
+			case ProductImportUpdated:
+				summary.Updated++
+			case ProductImportFailed:
+				summary.Failed++
+			}
+			if options.DryRun {
+				row.Id = 0
+			}
+			if err := report(row); err != nil {
+				return err
+			}
+		}
+	}(); err != nil {
+		tx.Rollback()
+		return summary, err
+	}
+
+	if options.DryRun || (options.Mode == ProductImportAllOrNothing && summary.Failed > 0) {
+		tx.Rollback()
+		return summary, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return summary, err
+	}
+	summary.Committed = true
+	return summary, nil
+}
+
+func (s *Service) validateImportedProduct(dto ProductDTOAdd, validate func(ProductDTOAdd) error) error {
+	if dto.Sku == "" {
+		return &ApiError{Err: "sku is required to import product"}
+	}
+	if validate != nil {
+		if err := validate(dto); err != nil {
+			return &ApiError{Err: fmt.Sprintf("Invalid passed data err: %v", err.Error())}
+		}
+	}
+	return validatePrice(dto.Price)
+}
+
+// productImportUpdates are assignments updating existing product by imported columns. Currency is changed only
+// when it's passed explicitly, so import without it cannot re-denominate existing products
+var productImportUpdates = []struct{ column, update string }{
+	{"name", "name = EXCLUDED.name"},
+	{"description", "description = EXCLUDED.description"},
+	{"price", "price = EXCLUDED.price"},
+	{"currency", "currency = EXCLUDED.currency"},
+	{"quantity", "quantity = EXCLUDED.quantity"},
+	{"category", "category = EXCLUDED.category"},
+	{"tax_class", "tax_class_id = EXCLUDED.tax_class_id"},
+	{"reorder_point", "reorder_point = EXCLUDED.reorder_point"},
+	{"reorder_quantity", "reorder_quantity = EXCLUDED.reorder_quantity"},
+}
+
+// importProduct upserts product by its sku under savepoint, so failed row doesn't abort import's transaction.
+// Existing product is updated only by passed columns, new product gets defaults of the others
+func (s *Service) importProduct(ctx context.Context, tx *sqlx.Tx, dto ProductDTOAdd, columns map[string]bool) (id int, action string, err error) {
+	// product which is going to be updated is snapshotted for audit
+	var before json.RawMessage
+	var currency string
+	if err := tx.QueryRowContext(ctx, `
+	SELECT id, currency FROM product WHERE sku = $1 AND archived_at IS NULL
+	`, dto.Sku).Scan(&id, &currency); err != nil && err != sql.ErrNoRows {
+		return 0, "", err
+	} else if err == nil {
+		// price without currency is parsed in default currency, which may differ from product's one
+		if columns["price"] && !columns["currency"] && dto.Price.Currency != currency {
+			return 0, "", &ApiError{Err: fmt.Sprintf("product's currency is %v, pass currency column to change it", currency)}
+		}
+		if before, err = auditSnapshot(ctx, tx, AuditProduct, id); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT import_product"); err != nil {
+		return 0, "", err
+	}
+
+	// row without updated columns still has to lock and return existing product
+	updates := []string{"sku = EXCLUDED.sku"}
+	for _, update := range productImportUpdates {
+		if columns[update.column] {
+			updates = append(updates, update.update)
+		}
+	}
+
+	var inserted bool
+	if err := tx.QueryRowContext(ctx, `
+	INSERT INTO product (sku, name, description, price, currency, quantity, category, tax_class_id, reorder_point, reorder_quantity)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), $9, $10)
+	ON CONFLICT (sku) WHERE archived_at IS NULL DO UPDATE
+	SET `+strings.Join(updates, ", ")+`
+	RETURNING id, xmax = 0
+	`, dto.Sku, dto.Name, dto.Description, dto.Price.Amount, dto.Price.Currency, dto.Quantity, dto.Category,
+		dto.TaxClass, dto.ReorderPoint, dto.ReorderQuantity).Scan(&id, &inserted); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_product"); rollbackErr != nil {
+			return 0, "", rollbackErr
+		}
+		// Data exceptions and constraint violations are failures of the row, e.g. too long name
+		err = productError(err)
+		if pqErr, ok := err.(*pq.Error); ok && (pqErr.Code.Class() == "22" || pqErr.Code.Class() == "23") {
+			err = &ApiError{Err: pqErr.Message}
+		}
+		return 0, "", err
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_product"); err != nil {
+		return 0, "", err
+	}
+
+	// xmax of freshly inserted row is zero, updated row keeps id of transaction which locked it
+	if inserted {
+		return id, ProductImportCreated, s.audit(ctx, tx, AuditProduct, id, AuditCreate, nil)
+	}
+	return id, ProductImportUpdated, s.audit(ctx, tx, AuditProduct, id, AuditUpdate, before)
+}
+
+// Customer-related methods
+const customerColumns = `customer.id, customer.first_name, customer.last_name,
+	COALESCE(customer.email, '') AS email, COALESCE(customer.phone, '') AS phone`
+
+// customerError converts violation of customer's email uniqueness into ApiError
+func customerError(err error) error {
+	if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23505") { // unique_violation
+		return &ApiError{"Customer with passed email already exists"}
+	}
+	return err
+}
+
+func (s *Service) GetCustomers(ctx context.Context) (customers []Customer, err error) {
+	customers = []Customer{}
+	if err = s.db.SelectContext(ctx, &customers, `
+	SELECT `+customerColumns+` FROM customer
+	WHERE customer.deleted_at IS NULL
+	`); err != nil {
+		return
+	}
+	return
+}
+
+// searchCustomersCondition matches customers by name with tolerance to typos, by name or email substring
+// and by phone's digits. Query, escaped query and its digits are the first three arguments, see searchCustomersArgs
+const searchCustomersCondition = `($1 <% (customer.first_name || ' ' || customer.last_name)
+		OR concat_ws(' ', customer.first_name, customer.last_name, customer.email) ILIKE '%' || $2 || '%'
+		OR ($3 <> '' AND regexp_replace(COALESCE(customer.phone, ''), '\D', '', 'g') LIKE '%' || $3 || '%'))`
+
+// searchCustomersOrder puts the best matches first
+const searchCustomersOrder = `word_similarity($1, concat_ws(' ', customer.first_name, customer.last_name, customer.email)) DESC, customer.id`
+
+func searchCustomersArgs(q string) []any {
+	q = strings.TrimSpace(q)
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, q)
+	return []any{q, escapeLike(q), digits}
+}
+
+// SearchCustomers looks for customers by words of their name, email or phone tolerating typos in name.
+// Best matches go first
+func (s *Service) SearchCustomers(ctx context.Context, q string) (customers []Customer, err error) {
+	customers = []Customer{}
+	if err = s.db.SelectContext(ctx, &customers, `
+	SELECT `+customerColumns+` FROM customer
+	WHERE customer.deleted_at IS NULL AND `+searchCustomersCondition+`
+	ORDER BY `+searchCustomersOrder+`
+	`, searchCustomersArgs(q)...); err != nil {
+		return
+	}
+	return
+}
+
+func (s *Service) GetCustomerById(ctx context.Context, id int) (*Customer, error) {
+	customer := &Customer{}
+	if err := s.db.GetContext(ctx, customer, `
+	SELECT `+customerColumns+` FROM customer
+	WHERE id = $1 AND deleted_at IS NULL
+	`, id); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Customer with passed id:%v not exists", id)}
+		}
+		return nil, err
+	}
+	return customer, nil
+}
+
+func (s *Service) AddCustomer(ctx context.Context, dto CustomerDTOAdd) (*Customer, error) {
+	customer := Customer{FirstName: dto.FirstName, LastName: dto.LastName, Email: dto.Email, Phone: dto.Phone}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO customer (first_name, last_name, email, phone)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, '')) RETURNING id
+		`, dto.FirstName, dto.LastName, dto.Email, dto.Phone).Scan(&customer.Id); err != nil {
+			return customerError(err)
+		}
+		return s.audit(ctx, tx, AuditCustomer, customer.Id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &customer, nil
+}
+
+func (s *Service) UpdateCustomerById(ctx context.Context, dto CustomerDTOUpdate) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCustomer, dto.Id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+		UPDATE customer
+		SET first_name = :first_name, last_name = :last_name, email = NULLIF(:email, ''), phone = NULLIF(:phone, '')
+		WHERE id = :id AND deleted_at IS NULL
+		`, &dto); err != nil {
+			return customerError(err)
+		}
+		return s.audit(ctx, tx, AuditCustomer, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// DeleteCustomerById deletes customer without bills. Customer with bills is only marked as deleted
+// to keep bills consistent: it disappears from customers but stays visible in its bills
+func (s *Service) DeleteCustomerById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		var hasBills bool
+		if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT id FROM bill WHERE customer_id = $1)
+		`, id).Scan(&hasBills); err != nil {
+			return err
+		}
+
+		before, err := auditSnapshot(ctx, tx, AuditCustomer, id)
+		if err != nil {
+			return err
+		}
+		if hasBills {
+			if _, err := tx.ExecContext(ctx, `
+			UPDATE customer SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL
+			`, id); err != nil {
+				return err
+			}
+		} else if _, err := tx.ExecContext(ctx, "DELETE FROM customer WHERE id = $1", id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditCustomer, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// ExportCustomer gathers all data stored about customer, including deleted one
+func (s *Service) ExportCustomer(ctx context.Context, id int) (*CustomerExport, error) {
+	export := &CustomerExport{ExportedAt: time.Now()}
+	if err := s.db.GetContext(ctx, &export.Customer, `
+	SELECT `+customerColumns+` FROM customer
+	WHERE id = $1 AND erased_at IS NULL
+	`, id); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Customer with passed id:%v not exists", id)}
+		}
+		return nil, err
+	}
+
+	var err error
+	if export.Addresses, err = s.GetCustomerAddresses(ctx, id); err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if err := s.db.SelectContext(ctx, &ids, `
+	SELECT id FROM bill WHERE customer_id = $1 ORDER BY created_at, id
+	`, id); err != nil {
+		return nil, err
+	}
+
+	export.Bills = make([]BillVerbose, 0, len(ids))
+	for _, billId := range ids {
+		bill, err := s.GetBillById(ctx, billId)
+		if err != nil {
+			return nil, err
+		}
+		export.Bills = append(export.Bills, *bill)
+	}
+	return export, nil
+}
+
+// EraseCustomer scrubs customer's personal data: name, contacts and addresses including ones snapshotted
+// into bills. Bills themselves are kept as financial records, bound to anonymous deleted customer
+func (s *Service) EraseCustomer(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCustomer, id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+		UPDATE customer
+		SET first_name = 'Erased', last_name = 'Customer', email = NULL, phone = NULL,
+			deleted_at = COALESCE(deleted_at, NOW()), erased_at = NOW()
+		WHERE id = $1 AND erased_at IS NULL
+		`, id)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return &ApiError{Err: fmt.Sprintf("Customer with passed id:%v not exists", id)}
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM customeraddress WHERE customer_id = $1", id); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE bill SET billing_address = NULL, shipping_address = NULL WHERE customer_id = $1
+		`, id); err != nil {
+			return err
+		}
+
+		// erasure itself is recorded, but erased data doesn't stay in audit
+		if err := s.audit(ctx, tx, AuditCustomer, id, AuditUpdate, before); err != nil {
+			return err
+		}
+		return s.scrubCustomerAudit(ctx, tx, id)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// GetCustomerBills returns customer's bills from newest to oldest
+func (s *Service) GetCustomerBills(ctx context.Context, filter CustomerBillsFilter) (bills []Bill, err error) {
+	bills = []Bill{}
+	if err = s.db.SelectContext(ctx, &bills, `
+	SELECT `+billColumns+` FROM bill
+	WHERE bill.customer_id = $1
+		AND ($2::timestamp IS NULL OR bill.created_at >= $2)
+		AND ($3::timestamp IS NULL OR bill.created_at < $3)
+	ORDER BY bill.created_at DESC, bill.id DESC
+	LIMIT $4 OFFSET $5
+	`, filter.Customer, filter.From, filter.To, filter.Limit, filter.Offset); err != nil {
+		return
+	}
+	return
+}
+
+// GetCustomerSummary aggregates customer's purchase history. Spend is what customer has to pay for bills after
+// discounts, coupons and taxes, less credited returns. Amounts in different currencies aren't summed, so spend
+// is returned for every currency and the same product may be top product in several of them
+func (s *Service) GetCustomerSummary(ctx context.Context, id int) (*CustomerSummary, error) {
+	if _, err := s.GetCustomerById(ctx, id); err != nil {
+		return nil, err
+	}
+
+	summary := &CustomerSummary{}
+	if err := s.db.GetContext(ctx, summary, `
+	SELECT $1::integer AS customer_id,
+		COUNT(bill.id) AS bill_count,
+		MIN(bill.created_at) AS first_purchase,
+		MAX(bill.created_at) AS last_purchase
+	FROM bill
+	WHERE bill.customer_id = $1 AND bill.status <> 'void'
+	`, id); err != nil {
+		return nil, err
+	}
+
+	summary.TotalSpend = []Money{}
+	if err := s.db.SelectContext(ctx, &summary.TotalSpend, `
+	SELECT SUM(bill.total - COALESCE(credited.amount, 0)) AS amount, bill.currency
+	FROM bill
+	LEFT JOIN LATERAL (
+		SELECT SUM(creditnoteline.amount) AS amount FROM creditnoteline WHERE creditnoteline.bill_id = bill.id
+	) AS credited ON TRUE
+	WHERE bill.customer_id = $1 AND bill.status <> 'void'
+	GROUP BY bill.currency
+	ORDER BY bill.currency
+	`, id); err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if err := s.db.SelectContext(ctx, &ids, "SELECT id FROM bill WHERE customer_id = $1", id); err != nil {
+		return nil, err
+	}
+	bills, err := s.calculateBills(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	var credits []lineCredit
+	if err := s.db.SelectContext(ctx, &credits, `
+	SELECT creditnoteline.bill_id, creditnoteline.product_id,
+		SUM(creditnoteline.quantity) AS quantity, SUM(creditnoteline.amount) AS amount
+	FROM creditnoteline
+	JOIN bill ON bill.id = creditnoteline.bill_id
+	WHERE bill.customer_id = $1
+	GROUP BY creditnoteline.bill_id, creditnoteline.product_id
+	`, id); err != nil {
+		return nil, err
+	}
+	summary.TopProducts = topBillProducts(bills, credits, 5)
+	return summary, nil
+}
+
+func (s *Service) GetCustomerAddresses(ctx context.Context, id int) (addresses []CustomerAddress, err error) {
+	addresses = []CustomerAddress{}
+	if err = s.db.SelectContext(ctx, &addresses, `
+	SELECT customeraddress.id, customeraddress.customer_id, customeraddress.type, customeraddress.line1,
+		customeraddress.line2, customeraddress.city, customeraddress.postal_code, customeraddress.country
+	FROM customeraddress
+	WHERE customeraddress.customer_id = $1
+	ORDER BY customeraddress.id
+	`, id); err != nil {
+		return
+	}
+	return
+}
+
+func (s *Service) AddCustomerAddress(ctx context.Context, dto CustomerAddressDTOAdd) (*CustomerAddress, error) {
+	address := CustomerAddress{Customer: dto.Customer, Type: dto.Type, Address: dto.Address}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCustomer, dto.Customer)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO customeraddress (customer_id, type, line1, line2, city, postal_code, country)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+		`, dto.Customer, dto.Type, dto.Line1, dto.Line2, dto.City, dto.PostalCode, dto.Country).Scan(&address.Id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{Err: fmt.Sprintf("Customer with passed id:%v not exists", dto.Customer)}
+			}
+			return err
+		}
+		return s.audit(ctx, tx, AuditCustomer, dto.Customer, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &address, nil
+}
+
+func (s *Service) UpdateCustomerAddressById(ctx context.Context, dto CustomerAddressDTOUpdate) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCustomer, dto.Customer)
+		if err != nil {
+			return err
+		}
+		res, err := tx.NamedExecContext(ctx, `
+		UPDATE customeraddress
+		SET type = :type, line1 = :line1, line2 = :line2, city = :city, postal_code = :postal_code, country = :country
+		WHERE id = :id AND customer_id = :customer_id
+		`, &dto)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return &ApiError{Err: fmt.Sprintf("Address with passed id:%v not exists", dto.Id)}
+		}
+		return s.audit(ctx, tx, AuditCustomer, dto.Customer, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) DeleteCustomerAddressById(ctx context.Context, customer_id, address_id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCustomer, customer_id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM customeraddress WHERE id = $1 AND customer_id = $2
+		`, address_id, customer_id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditCustomer, customer_id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// snapshotAddress copies customer's address of passed type. Zero id means address isn't specified
+func (s *Service) snapshotAddress(ctx context.Context, tx *sqlx.Tx, customer, id int, addressType string) (*AddressSnapshot, error) {
+	if id == 0 {
+		return nil, nil
+	}
+
+	var address Address
+	if err := tx.GetContext(ctx, &address, `
+	SELECT line1, line2, city, postal_code, country FROM customeraddress
+	WHERE id = $1 AND customer_id = $2 AND type = $3
+	`, id, customer, addressType); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("customer id:%v has no %v address with id:%v", customer, addressType, id)}
+		}
+		return nil, err
+	}
+	snapshot := AddressSnapshot(address)
+	return &snapshot, nil
+}
+
+// Bill-related methods
+const billColumns = `bill.id, bill.number, bill.created_at, bill.customer_id, bill.currency, bill.status,
+	bill.total AS "total.amount", bill.currency AS "total.currency", bill.billing_address, bill.shipping_address`
+
+// billSorts maps sort orders of bills listing to columns and types cursor values are cast to
+var billSorts = map[string]struct{ column, cast string }{
+	BillSortCreatedAt: {"bill.created_at", "timestamp"},
+	BillSortTotal:     {"bill.total", "bigint"},
+	BillSortNumber:    {"bill.number", "text"},
+}
+
+// billsOrder returns column and direction of bills' sort, comparison selects bills after cursor
+func billsOrder(sort string) (order struct{ column, cast string }, direction, comparison string, err error) {
+	order, ok := billSorts[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		err = &ApiError{Err: fmt.Sprintf("Unknown sort %q, expected created_at, total or number optionally prefixed by minus", sort)}
+		return
+	}
+	direction, comparison = "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		direction, comparison = "DESC", "<"
+	}
+	return
+}
+
+// billsFilterCondition filters bills by the first seven query arguments, see billsFilterArgs
+const billsFilterCondition = `($1::timestamp IS NULL OR bill.created_at >= $1)
+		AND ($2::timestamp IS NULL OR bill.created_at < $2)
+		AND ($3 = 0 OR bill.customer_id = $3)
+		AND ($4 = '' OR bill.status = $4)
+		AND ($5::bigint IS NULL OR bill.total >= $5)
+		AND ($6::bigint IS NULL OR bill.total <= $6)
+		AND ($7 = '' OR bill.currency = $7)`
+
+func billsFilterArgs(filter BillsFilter) []any {
+	return []any{filter.From, filter.To, filter.Customer, filter.Status, filter.MinTotal, filter.MaxTotal, filter.Currency}
+}
+
+// validateBillsFilter checks that totals are compared within single currency, amounts in different currencies
+// aren't comparable
+func validateBillsFilter(filter BillsFilter) error {
+	if filter.Currency != "" {
+		if _, ok := currencyExponents[filter.Currency]; !ok {
+			return &ApiError{Err: fmt.Sprintf("unsupported currency %q", filter.Currency)}
+		}
+	} else if filter.MinTotal != nil || filter.MaxTotal != nil {
+		return &ApiError{Err: "currency is required to filter bills by total"}
+	}
+	return nil
+}
+
+// billCursor points to the last bill of listed page by value of sort column and id
+type billCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+func (c billCursor) encode() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeBillCursor(cursor string) (c billCursor, err error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(buf, &c)
+	return
+}
+
+// GetBills returns page of bills matching filter and cursor of the next page, which is empty on the last one.
+// Pages are continued after the last bill by keyset, so bills added meanwhile never shift them
+func (s *Service) GetBills(ctx context.Context, filter BillsFilter) (bills []Bill, next string, err error) {
+	if err := validateBillsFilter(filter); err != nil {
+		return nil, "", err
+	}
+	if filter.Sort == "" {
+		filter.Sort = "-" + BillSortCreatedAt
+	}
+	order, direction, comparison, err := billsOrder(filter.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var cursorValue *string
+	var cursorId int
+	if filter.Cursor != "" {
+		cursor, err := decodeBillCursor(filter.Cursor)
+		if err != nil || cursor.Sort != filter.Sort {
+			return nil, "", &ApiError{Err: "Invalid cursor, it have to be passed with the same sort it was returned for"}
+		}
+		cursorValue, cursorId = &cursor.Value, cursor.Id
+	}
+
+	bills = []Bill{}
+	if err = s.db.SelectContext(ctx, &bills, `
+	SELECT `+billColumns+` FROM bill
+	WHERE `+billsFilterCondition+`
+		AND ($8::text IS NULL OR (`+order.column+`, bill.id) `+comparison+` ($8::text::`+order.cast+`, $9))
+	ORDER BY `+order.column+` `+direction+`, bill.id `+direction+`
+	LIMIT $10
+	`, append(billsFilterArgs(filter), cursorValue, cursorId, filter.Limit+1)...); err != nil {
+		return nil, "", err
+	}
+
+	if len(bills) > filter.Limit {
+		bills = bills[:filter.Limit]
+		last := bills[len(bills)-1]
+		cursor := billCursor{Sort: filter.Sort, Id: last.Id}
+		switch order.column {
+		case "bill.created_at":
+			cursor.Value = last.CreatedAt.Format("2006-01-02T15:04:05.999999")
+		case "bill.total":
+			cursor.Value = strconv.FormatInt(last.Total.Amount, 10)
+		case "bill.number":
+			cursor.Value = last.Number
+		}
+		next = cursor.encode()
+	}
+	return bills, next, nil
+}
+
+// GetBillsVerbose returns page of bills like GetBills with expanded parts of BillVerbose
+func (s *Service) GetBillsVerbose(ctx context.Context, filter BillsFilter, expand BillExpand) ([]BillVerbose, string, error) {
+	bills, next, err := s.GetBills(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids := make([]int, len(bills))
+	for i, bill := range bills {
+		ids[i] = bill.Id
+	}
+	verbose, err := s.getBillsVerbose(ctx, s.db, ids, expand)
+	if err != nil {
+		return nil, "", err
+	}
+	return verbose, next, nil
+}
+
+func (s *Service) GetBillById(ctx context.Context, id int) (*BillVerbose, error) {
+	tx := s.db.MustBeginTx(ctx, nil)
+	defer tx.Rollback()
+
+	bills, err := s.getBillsVerbose(ctx, tx, []int{id}, BillExpand{Customer: true, Lines: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(bills) == 0 {
+		return nil, &ApiError{Err: fmt.Sprintf("Bill with passed id:%v not exists", id)}
+	}
+	return &bills[0], nil
+}
+
+// getBillsVerbose loads passed bills in their order. Every part is loaded for all bills by one query
+func (s *Service) getBillsVerbose(ctx context.Context, q sqlx.QueryerContext, ids []int, expand BillExpand) ([]BillVerbose, error) {
+	rows, err := q.QueryxContext(ctx, `
+	SELECT bill.id, bill.number, bill.created_at, bill.customer_id, bill.currency, bill.status, bill.total,
+		bill.billing_address, bill.shipping_address, bill.discount, COALESCE(coupon.code, ''),
+		bill.price_currency, bill.exchange_rate::text, bill.exchange_rate_effective_from
+	FROM bill
+	LEFT JOIN couponredemption ON couponredemption.bill_id = bill.id
+	LEFT JOIN coupon ON coupon.id = couponredemption.coupon_id
+	WHERE bill.id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bills := make(map[int]*BillVerbose, len(ids))
+	totals := make(map[int]int64, len(ids))
+	for rows.Next() {
+		bill := &BillVerbose{}
+		var total int64
+		var exchangeRate BillExchangeRate
+		var exchangeRateEffectiveFrom *time.Time
+		if err := rows.Scan(
+			&bill.Id,
+			&bill.Number,
+			&bill.CreatedAt,
+			&bill.Customer.Id,
+			&bill.Currency,
+			&bill.Status,
+			&total,
+			&bill.BillingAddress,
+			&bill.ShippingAddress,
+			&bill.Discount,
+			&bill.Coupon,
+			&exchangeRate.From,
+			&exchangeRate.Rate,
+			&exchangeRateEffectiveFrom,
+		); err != nil {
+			return nil, err
+		}
+
+		if exchangeRate.From != bill.Currency && exchangeRateEffectiveFrom != nil {
+			exchangeRate.To = bill.Currency
+			exchangeRate.Rate = normalizeRate(exchangeRate.Rate)
+			exchangeRate.EffectiveFrom = *exchangeRateEffectiveFrom
+			bill.ExchangeRate = &exchangeRate
+		}
+		bills[bill.Id] = bill
+		totals[bill.Id] = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if expand.Customer {
+		customers := []Customer{}
+		if err := sqlx.SelectContext(ctx, q, &customers, `
+		SELECT `+customerColumns+` FROM customer
+		WHERE customer.id IN (SELECT bill.customer_id FROM bill WHERE bill.id = ANY($1))
+		`, pq.Array(ids)); err != nil {
+			return nil, err
+		}
+		for _, customer := range customers {
+			for _, bill := range bills {
+				if bill.Customer.Id == customer.Id {
+					bill.Customer = customer
+				}
+			}
+		}
+	}
+
+	if expand.Lines {
+		calculations, err := s.calculateBills(ctx, q, ids)
+		if err != nil {
+			return nil, err
+		}
+		for id, calculation := range calculations {
+			bills[id].Products = calculation.lines
+			bills[id].Totals = &calculation.totals
+			totals[id] = calculation.totals.Total.Amount
+		}
+	}
+
+	payments, err := s.getBillsPayments(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	settlements, err := s.billSettlements(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	verbose := make([]BillVerbose, 0, len(bills))
+	for _, id := range ids {
+		bill, ok := bills[id]
+		if !ok {
+			continue
+		}
+		bill.Payments = payments[id]
+		if bill.Payments == nil {
+			bill.Payments = []Payment{}
+		}
+		settlement := settlements[id]
+		bill.Paid = NewMoney(settlement.Paid, bill.Currency)
+		bill.Credited = NewMoney(settlement.Credited, bill.Currency)
+		bill.Refunded = NewMoney(settlement.Refunded, bill.Currency)
+		bill.Balance = NewMoney(settlement.balance(totals[id]), bill.Currency)
+		verbose = append(verbose, *bill)
+	}
+	return verbose, nil
+}
+
+func (s *Service) GetBillByNumber(ctx context.Context, number string) (*BillVerbose, error) {
+	var id int
+	if err := s.db.GetContext(ctx, &id, "SELECT id FROM bill WHERE number = $1", number); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Bill with passed number:%v not exists", number)}
+		}
+		return nil, err
+	}
+	return s.GetBillById(ctx, id)
+}
+
+// billCalculation is bill's lines with calculated amounts, applied coupon and totals
+type billCalculation struct {
+	lines  []BillLine
+	totals BillTotals
+	coupon *Coupon
+}
+
+// calculateBill returns bill's lines, coupon and totals calculated with bill's discounts, coupon and taxes
+func (s *Service) calculateBill(ctx context.Context, q sqlx.QueryerContext, id int) (lines []BillLine, totals BillTotals, coupon *Coupon, err error) {
+	calculations, err := s.calculateBills(ctx, q, []int{id})
+	if err != nil {
+		return
+	}
+	calculation, ok := calculations[id]
+	if !ok {
+		err = &ApiError{Err: fmt.Sprintf("Bill with passed id:%v not exists", id)}
+		return
+	}
+	return calculation.lines, calculation.totals, calculation.coupon, nil
+}
+
+// calculateBills calculates passed bills like calculateBill, absent bills are skipped
+func (s *Service) calculateBills(ctx context.Context, q sqlx.QueryerContext, ids []int) (map[int]*billCalculation, error) {
+	var bills []struct {
+		Id             int       `db:"id"`
+		Discount       *Discount `db:"discount"`
+		Currency       string    `db:"currency"`
+		TaxMode        string    `db:"tax_mode"`
+		CouponDiscount int64     `db:"coupon_discount"`
+	}
+	if err := sqlx.SelectContext(ctx, q, &bills, `
+	SELECT bill.id, bill.discount, bill.currency, bill.tax_mode, COALESCE(couponredemption.amount, 0) AS coupon_discount
+	FROM bill
+	LEFT JOIN couponredemption ON couponredemption.bill_id = bill.id
+	WHERE bill.id = ANY($1)
+	`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	lines, err := s.getBillsLines(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var coupons []struct {
+		Bill int `db:"bill_id"`
+		Coupon
+	}
+	if err := sqlx.SelectContext(ctx, q, &coupons, `
+	SELECT couponredemption.bill_id, `+couponColumns+` FROM coupon
+	JOIN couponredemption ON couponredemption.coupon_id = coupon.id
+	WHERE couponredemption.bill_id = ANY($1)
+	`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	calculations := make(map[int]*billCalculation, len(bills))
+	for _, bill := range bills {
+		calculation := &billCalculation{lines: lines[bill.Id]}
+		if calculation.lines == nil {
+			calculation.lines = []BillLine{}
+		}
+		for i := range coupons {
+			if coupons[i].Bill == bill.Id {
+				calculation.coupon = &coupons[i].Coupon
+			}
+		}
+
+		calculation.totals = calculateBillTotals(calculation.lines, bill.Discount, bill.CouponDiscount, bill.Currency)
+		calculation.totals = applyTaxes(calculation.totals, calculation.lines, calculation.coupon, bill.TaxMode)
+		calculations[bill.Id] = calculation
+	}
+	return calculations, nil
+}
+
+// refreshBillTotal stores bill's total after its lines, discounts or coupon change, so bills can be filtered
+// and sorted by total
+func (s *Service) refreshBillTotal(ctx context.Context, tx *sqlx.Tx, id int) (Money, error) {
+	_, totals, _, err := s.calculateBill(ctx, tx, id)
+	if err != nil {
+		return Money{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE bill SET total = $1 WHERE id = $2", totals.Total.Amount, id)
+	return totals.Total, err
+}
+
+// getBillLines returns bill's lines without calculated amounts
+func (s *Service) getBillLines(ctx context.Context, q sqlx.QueryerContext, id int) ([]BillLine, error) {
+	lines, err := s.getBillsLines(ctx, q, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if lines[id] == nil {
+		return []BillLine{}, nil
+	}
+	return lines[id], nil
+}
+
+// getBillsLines returns lines of passed bills by bill's id
+func (s *Service) getBillsLines(ctx context.Context, q sqlx.QueryerContext, ids []int) (map[int][]BillLine, error) {
+	var rows []struct {
+		Bill int `db:"bill_id"`
+		BillLine
+	}
+	if err := sqlx.SelectContext(ctx, q, &rows, `
+	SELECT productbill.bill_id, productbill.product_id, product.name, product.category, productbill.quantity,
+		productbill.price AS "price.amount", bill.currency AS "price.currency", productbill.discount, productbill.tax_rate
+	FROM productbill
+	JOIN product ON product.id = productbill.product_id
+	JOIN bill ON bill.id = productbill.bill_id
+	WHERE productbill.bill_id = ANY($1)
+	ORDER BY productbill.bill_id, productbill.product_id
+	`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	lines := map[int][]BillLine{}
+	for _, row := range rows {
+		lines[row.Bill] = append(lines[row.Bill], row.BillLine)
+	}
+	return lines, nil
+}
+
+// validateUpsertBillFields checks bill's customer and products, returning products with merged duplicates.
+// Bill is 0 for new bill, existing bill keeps its archived products
+func (s *Service) validateUpsertBillFields(ctx context.Context, tx *sqlx.Tx, bill, customer int, products []BillProduct) ([]BillProduct, error) {
+	// Checking customer on existence
+	var hasCustomer bool
+	tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT id FROM customer WHERE id = $1 AND deleted_at IS NULL)", customer).Scan(&hasCustomer)
+	if !hasCustomer {
+		return nil, &ApiError{Err: fmt.Sprintf("customer with passed id:%v not exists", customer)}
+	}
+
+	products, err := mergeBillProducts(products)
+	if err != nil {
+		return nil, err
+	}
+	// lines take stock of products in order of their ids, so concurrent bills cannot deadlock
+	sort.Slice(products, func(a, b int) bool {
+		return products[a].Product < products[b].Product
+	})
+
+	ids := make([]int, len(products))
+	for i, billProduct := range products {
+		ids[i] = billProduct.Product
+	}
+	if err := s.validateProductsExist(ctx, tx, ids, bill); err != nil {
+		return nil, err
+	}
+
+	for _, billProduct := range products {
+		if err := billProduct.Discount.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return products, nil
+}
+
+// billProductPrice returns product's current price converted into bill's currency by bill's exchange rate and
+// whether product is archived. All bill's products have to be priced in the same currency
+func (s *Service) billProductPrice(ctx context.Context, tx *sqlx.Tx, id, product int) (price Money, archived bool, err error) {
+	var priceCurrency, currency, exchangeRate string
+	if err := tx.QueryRowContext(ctx, `
+	SELECT product.price, product.currency, product.archived_at IS NOT NULL, bill.price_currency, bill.currency,
+		bill.exchange_rate::text
+	FROM product, bill WHERE product.id = $1 AND bill.id = $2
+	`, product, id).Scan(&price.Amount, &price.Currency, &archived, &priceCurrency, &currency, &exchangeRate); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", product)}
+		}
+		return price, false, err
+	}
+	if price.Currency != priceCurrency {
+		return price, false, &ApiError{Err: fmt.Sprintf("product id:%v is priced in %v while bill's products are priced in %v",
+			product, price.Currency, priceCurrency)}
+	}
+
+	rate, err := parseRate(normalizeRate(exchangeRate))
+	if err != nil {
+		return price, false, err
+	}
+	return price.Convert(rate, currency), archived, nil
+}
+
+// insertBillProduct adds product to bill, snapshotting its current price converted into bill's currency and tax rate
+// effective at bill's date. Archived product cannot be added
+func (s *Service) insertBillProduct(ctx context.Context, tx *sqlx.Tx, id int, billProduct BillProduct) error {
+	price, archived, err := s.billProductPrice(ctx, tx, id, billProduct.Product)
+	if err != nil {
+		return err
+	}
+	if archived {
+		return &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", billProduct.Product)}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	INSERT INTO productbill (product_id, bill_id, quantity, price, discount, tax_rate)
+	SELECT product.id, $2, $3, $5, $4, COALESCE((
+		SELECT taxrate.rate FROM taxrate
+		WHERE taxrate.tax_class_id = product.tax_class_id
+			AND taxrate.effective_from <= (SELECT bill.created_at FROM bill WHERE bill.id = $2)
+		ORDER BY taxrate.effective_from DESC
+		LIMIT 1
+	), 0)
+	FROM product WHERE product.id = $1
+	`, billProduct.Product, id, billProduct.Quantity, billProduct.Discount.arg(), price.Amount); err != nil {
+		return err
+	}
+	return s.takeProductStock(ctx, tx, billProduct.Product, billProduct.Quantity)
+}
+
+// replaceBillProducts makes passed products the lines of bill. Kept lines keep their price and tax rate snapshots,
+// so archived products stay in bill, their prices are converted anew only when bill's pricing changes. Stock is
+// taken and returned by differences of quantities in order of products' ids
+func (s *Service) replaceBillProducts(ctx context.Context, tx *sqlx.Tx, id int, products []BillProduct, reprice bool) error {
+	var lines []BillProduct
+	if err := tx.SelectContext(ctx, &lines, `
+	SELECT product_id AS product, quantity FROM productbill WHERE bill_id = $1
+	`, id); err != nil {
+		return err
+	}
+
+	current := map[int]int{}
+	ids := []int{}
+	for _, line := range lines {
+		current[line.Product] = line.Quantity
+		ids = append(ids, line.Product)
+	}
+	passed := map[int]BillProduct{}
+	for _, billProduct := range products {
+		passed[billProduct.Product] = billProduct
+		if _, ok := current[billProduct.Product]; !ok {
+			ids = append(ids, billProduct.Product)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, product := range ids {
+		billProduct, keep := passed[product]
+		quantity, exists := current[product]
+		switch {
+		case !keep:
+			if _, err := tx.ExecContext(ctx, "DELETE FROM productbill WHERE bill_id = $1 AND product_id = $2", id, product); err != nil {
+				return err
+			}
+			if err := s.addProductStock(ctx, tx, product, quantity); err != nil {
+				return err
+			}
+		case !exists:
+			if err := s.insertBillProduct(ctx, tx, id, billProduct); err != nil {
+				return err
+			}
+		default:
+			var price *int64
+			if reprice {
+				converted, _, err := s.billProductPrice(ctx, tx, id, product)
+				if err != nil {
+					return err
+				}
+				price = &converted.Amount
+			}
+			if _, err := tx.ExecContext(ctx, `
+			UPDATE productbill SET quantity = $3, discount = $4, price = COALESCE($5, price)
+			WHERE bill_id = $1 AND product_id = $2
+			`, id, product, billProduct.Quantity, billProduct.Discount.arg(), price); err != nil {
+				return err
+			}
+			if billProduct.Quantity > quantity {
+				if err := s.takeProductStock(ctx, tx, product, billProduct.Quantity-quantity); err != nil {
+					return err
+				}
+			} else if billProduct.Quantity < quantity {
+				if err := s.addProductStock(ctx, tx, product, quantity-billProduct.Quantity); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// billPriceCurrency returns currency bill's products are priced in. Bill without products is in default currency
+func (s *Service) billPriceCurrency(ctx context.Context, tx *sqlx.Tx, products []BillProduct) (string, error) {
+	if len(products) == 0 {
+		return defaultCurrency, nil
+	}
+
+	var currency string
+	if err := tx.QueryRowContext(ctx, "SELECT currency FROM product WHERE id = $1", products[0].Product).Scan(&currency); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", products[0].Product)}
+		}
+		return "", err
+	}
+	return currency, nil
+}
+
+// snapshotExchangeRate stores on bill the latest rate from its products' currency into its own one effective at
+// bill's creation. Inverse rate is used when only it is loaded
+func (s *Service) snapshotExchangeRate(ctx context.Context, tx *sqlx.Tx, id int) error {
+	var priceCurrency, currency string
+	var createdAt time.Time
+	if err := tx.QueryRowContext(ctx, `
+	SELECT price_currency, currency, created_at FROM bill WHERE id = $1
+	`, id).Scan(&priceCurrency, &currency, &createdAt); err != nil {
+		return err
+	}
+
+	var rate string
+	var effectiveFrom *time.Time
+	if priceCurrency != currency {
+		if err := tx.QueryRowContext(ctx, `
+		SELECT rate::text, effective_from FROM (
+			SELECT rate, effective_from, 0 AS inverse FROM exchangerate
+			WHERE base = $1 AND quote = $2 AND effective_from <= $3
+			UNION ALL
+			SELECT ROUND(1 / rate, 10), effective_from, 1 AS inverse FROM exchangerate
+			WHERE base = $2 AND quote = $1 AND effective_from <= $3
+		) AS rates
+		ORDER BY effective_from DESC, inverse
+		LIMIT 1
+		`, priceCurrency, currency, createdAt).Scan(&rate, &effectiveFrom); err != nil {
+			if err == sql.ErrNoRows {
+				err = &ApiError{Err: fmt.Sprintf("no exchange rate from %v to %v effective at %v",
+					priceCurrency, currency, createdAt.Format(time.RFC3339))}
+			}
+			return err
+		}
+	} else {
+		rate = "1"
+	}
+
+	_, err := tx.ExecContext(ctx, `
+	UPDATE bill SET exchange_rate = $2, exchange_rate_effective_from = $3 WHERE id = $1
+	`, id, rate, effectiveFrom)
+	return err
+}
+
+// chooseBillCurrency returns currency passed into bill or its products' one when omitted
+func chooseBillCurrency(currency, priceCurrency string) (string, error) {
+	if currency == "" {
+		return priceCurrency, nil
+	}
+	if _, ok := currencyExponents[currency]; !ok {
+		return "", &ApiError{Err: fmt.Sprintf("unsupported currency %q", currency)}
+	}
+	return currency, nil
+}
+
+func (s *Service) AddBill(ctx context.Context, dto BillDTOAdd) (*Bill, error) {
+	tx := s.db.MustBeginTx(ctx, nil)
+	bill, err := s.addBill(ctx, tx, dto)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return bill, nil
+}
+
+// addBill creates bill within transaction, so bill can be created together with other changes
+func (s *Service) addBill(ctx context.Context, tx *sqlx.Tx, dto BillDTOAdd) (*Bill, error) {
+	var bill Bill
+	products, err := s.validateUpsertBillFields(ctx, tx, 0, dto.Customer, dto.Products)
+	if err != nil {
+		return nil, err
+	}
+	dto.Products = products
+	if err := dto.Discount.validate(); err != nil {
+		return nil, err
+	}
+
+	billingAddress, err := s.snapshotAddress(ctx, tx, dto.Customer, dto.BillingAddress, AddressBilling)
+	if err != nil {
+		return nil, err
+	}
+	shippingAddress, err := s.snapshotAddress(ctx, tx, dto.Customer, dto.ShippingAddress, AddressShipping)
+	if err != nil {
+		return nil, err
+	}
+	priceCurrency, err := s.billPriceCurrency(ctx, tx, dto.Products)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := chooseBillCurrency(dto.Currency, priceCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	number, err := s.nextBillNumber(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO bill (number, customer_id, currency, price_currency, billing_address, shipping_address, discount, tax_mode)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id, created_at, number
+	`, number, dto.Customer, currency, priceCurrency, billingAddress, shippingAddress, dto.Discount.arg(), s.config.Tax.PricingMode).Scan(&bill.Id, &bill.CreatedAt, &bill.Number)
+	if err != nil {
+		return nil, err
+	}
+	bill.Customer = dto.Customer
+	bill.Currency = currency
+
+	if err := s.snapshotExchangeRate(ctx, tx, bill.Id); err != nil {
+		return nil, err
+	}
+	bill.BillingAddress = billingAddress
+	bill.ShippingAddress = shippingAddress
+
+	for _, billProduct := range dto.Products {
+		if err := s.insertBillProduct(ctx, tx, bill.Id, billProduct); err != nil {
+			return nil, err
+		}
+	}
+
+	if dto.Coupon != "" {
+		if err := s.applyCoupon(ctx, tx, bill.Id, dto.Customer, dto.Coupon); err != nil {
+			return nil, err
+		}
+	}
+	if bill.Total, err = s.refreshBillTotal(ctx, tx, bill.Id); err != nil {
+		return nil, err
+	}
+	if err := s.audit(ctx, tx, AuditBill, bill.Id, AuditCreate, nil); err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// UpdateBillById replaces bill's customer, products, discount and coupon. Omitted coupon is removed from bill.
+// Products already in bill keep their snapshots and may be archived, new ones have to be active
+func (s *Service) UpdateBillById(ctx context.Context, dto BillDTOUpdate) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := s.lockOpenBill(ctx, tx, dto.Id); err != nil {
+			return err
+		}
+		before, err := auditSnapshot(ctx, tx, AuditBill, dto.Id)
+		if err != nil {
+			return err
+		}
+
+		products, err := s.validateUpsertBillFields(ctx, tx, dto.Id, dto.Customer, dto.Products)
+		if err != nil {
+			return err
+		}
+		dto.Products = products
+		if err := dto.Discount.validate(); err != nil {
+			return err
+		}
+
+		// Passed addresses are snapshotted again, otherwise previous snapshots are kept unless customer changes
+		billingAddress, err := s.snapshotAddress(ctx, tx, dto.Customer, dto.BillingAddress, AddressBilling)
+		if err != nil {
+			return err
+		}
+		shippingAddress, err := s.snapshotAddress(ctx, tx, dto.Customer, dto.ShippingAddress, AddressShipping)
+		if err != nil {
+			return err
+		}
+		priceCurrency, err := s.billPriceCurrency(ctx, tx, dto.Products)
+		if err != nil {
+			return err
+		}
+		currency, err := chooseBillCurrency(dto.Currency, priceCurrency)
+		if err != nil {
+			return err
+		}
+
+		// kept lines are converted anew when bill's currencies or exchange rate change
+		pricing := func() (string, error) {
+			var pricing string
+			err := tx.QueryRowContext(ctx, `
+			SELECT currency || price_currency || COALESCE(exchange_rate::text, '') FROM bill WHERE id = $1
+			`, dto.Id).Scan(&pricing)
+			return pricing, err
+		}
+		previousPricing, err := pricing()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE bill
+		SET customer_id = $1,
+			billing_address = CASE WHEN customer_id = $1 THEN COALESCE($3, billing_address) ELSE $3 END,
+			shipping_address = CASE WHEN customer_id = $1 THEN COALESCE($4, shipping_address) ELSE $4 END,
+			discount = $5,
+			currency = $6,
+			price_currency = $7
+		WHERE id = $2
+		`, dto.Customer, dto.Id, billingAddress, shippingAddress, dto.Discount.arg(), currency, priceCurrency); err != nil {
+			return err
+		}
+		if err := s.snapshotExchangeRate(ctx, tx, dto.Id); err != nil {
+			return err
+		}
+		newPricing, err := pricing()
+		if err != nil {
+			return err
+		}
+		if err := s.replaceBillProducts(ctx, tx, dto.Id, dto.Products, newPricing != previousPricing); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM couponredemption WHERE bill_id = $1", dto.Id); err != nil {
+			return err
+		}
+		if dto.Coupon != "" {
+			if err := s.applyCoupon(ctx, tx, dto.Id, dto.Customer, dto.Coupon); err != nil {
+				return err
+			}
+		}
+		if _, err := s.refreshBillTotal(ctx, tx, dto.Id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditBill, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// DeleteBillById voids open bill instead of deleting it, so its number isn't reused and numbering stays gapless.
+// Bill's products return to stock and its coupon is released. Paid bills are kept, their payments have to be deleted first
+func (s *Service) DeleteBillById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		// Absent or voided bill is considered already deleted
+		var status string
+		if err := tx.QueryRowContext(ctx, "SELECT status FROM bill WHERE id = $1 FOR UPDATE", id).Scan(&status); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+		if status == BillVoid {
+			return nil
+		}
+		if status != BillOpen {
+			return &ApiError{Err: fmt.Sprintf("Bill id:%v is %v, delete its payments first", id, status)}
+		}
+
+		before, err := auditSnapshot(ctx, tx, AuditBill, id)
+		if err != nil {
+			return err
+		}
+		if err := s.returnBillStock(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM productbill WHERE bill_id = $1", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM couponredemption WHERE bill_id = $1", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE bill SET status = $1, total = 0 WHERE id = $2
+		`, BillVoid, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditBill, id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) lockBill(ctx context.Context, tx *sqlx.Tx, id int) (status string, err error) {
+	err = tx.QueryRowContext(ctx, `
+	SELECT status FROM bill WHERE id = $1 FOR UPDATE
+	`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		err = &ApiError{Err: fmt.Sprintf("Bill with passed id:%v not exists", id)}
+	}
+	return
+}
+
+// lockOpenBill locks bill which is going to be changed. Bills with payments cannot be changed
+func (s *Service) lockOpenBill(ctx context.Context, tx *sqlx.Tx, id int) error {
+	status, err := s.lockBill(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if status != BillOpen {
+		return &ApiError{Err: fmt.Sprintf("Bill id:%v is %v, only open bill can be changed", id, status)}
+	}
+	return nil
+}
+
+func (s *Service) GetBillProducts(ctx context.Context, id int) (products []Product, err error) {
+	products = []Product{}
+	if err = s.db.SelectContext(ctx, &products, `
+	SELECT `+productColumns+`
+	FROM product
+	JOIN productbill ON productbill.product_id = product.id
+	WHERE productbill.bill_id = $1
+	`, id); err != nil {
+		return
+	}
+	return
+}
+
+func (s *Service) DeleteProductFromBill(ctx context.Context, bill_id, product_id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := s.lockOpenBill(ctx, tx, bill_id); err != nil {
+			return err
+		}
+		before, err := auditSnapshot(ctx, tx, AuditBill, bill_id)
+		if err != nil {
+			return err
+		}
+
+		var quantity int
+		if err := tx.QueryRowContext(ctx, `
+		DELETE FROM productbill WHERE bill_id = $1 and product_id = $2 RETURNING quantity
+		`, bill_id, product_id).Scan(&quantity); err != nil && err != sql.ErrNoRows {
+			return err
+		} else if err == nil {
+			if err := s.addProductStock(ctx, tx, product_id, quantity); err != nil {
+				return err
+			}
+		}
+
+		if err := s.refreshBillCoupon(ctx, tx, bill_id); err != nil {
+			return err
+		}
+		if _, err := s.refreshBillTotal(ctx, tx, bill_id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditBill, bill_id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) AddProductToBill(ctx context.Context, dto BillDtoAddProduct) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := dto.BillProduct.Discount.validate(); err != nil {
+			return err
+		}
+		if err := s.lockOpenBill(ctx, tx, dto.Id); err != nil {
+			return err
+		}
+		before, err := auditSnapshot(ctx, tx, AuditBill, dto.Id)
+		if err != nil {
+			return err
+		}
+
+		if err := s.insertBillProduct(ctx, tx, dto.Id, dto.BillProduct); err != nil {
+			if err, ok := err.(*pq.Error); ok {
+				switch err.Code {
+				case pq.ErrorCode("23505"): // unique_violation
+					return &ApiError{"Passed product already exists in bill, change its quantity instead"}
+				case pq.ErrorCode("23503"): // foreign_key_violation
+					return &ApiError{"Passed product or bill not exists"}
+				}
+			}
+
+			return err
+		}
+
+		if err := s.refreshBillCoupon(ctx, tx, dto.Id); err != nil {
+			return err
+		}
+		if _, err := s.refreshBillTotal(ctx, tx, dto.Id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditBill, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// UpdateBillProduct sets quantity of bill's line or changes it by delta, keeping line's price and tax rate snapshots.
+// Added quantity is taken from product's stock and removed one goes back to it
+func (s *Service) UpdateBillProduct(ctx context.Context, dto BillDTOUpdateProduct) (*BillLine, error) {
+	if (dto.Quantity == nil) == (dto.Delta == nil) {
+		return nil, &ApiError{Err: "either quantity or delta have to be passed"}
+	}
+
+	var line *BillLine
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := s.lockOpenBill(ctx, tx, dto.Bill); err != nil {
+			return err
+		}
+		before, err := auditSnapshot(ctx, tx, AuditBill, dto.Bill)
+		if err != nil {
+			return err
+		}
+
+		var current int
+		if err := tx.QueryRowContext(ctx, `
+		SELECT quantity FROM productbill WHERE bill_id = $1 AND product_id = $2 FOR UPDATE
+		`, dto.Bill, dto.Product).Scan(&current); err != nil {
+			if err == sql.ErrNoRows {
+				return &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists in bill id:%v", dto.Product, dto.Bill)}
+			}
+			return err
+		}
+
+		quantity := current
+		if dto.Quantity != nil {
+			quantity = *dto.Quantity
+		} else {
+			quantity += *dto.Delta
+		}
+		if quantity <= 0 {
+			return &ApiError{Err: fmt.Sprintf("quantity of bill's line have to stay positive, got %v. Delete product from bill instead", quantity)}
+		}
+		if quantity > current {
+			if err := s.takeProductStock(ctx, tx, dto.Product, quantity-current); err != nil {
+				return err
+			}
+		} else if quantity < current {
+			if err := s.addProductStock(ctx, tx, dto.Product, current-quantity); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE productbill SET quantity = $3 WHERE bill_id = $1 AND product_id = $2
+		`, dto.Bill, dto.Product, quantity); err != nil {
+			return err
+		}
+
+		if err := s.refreshBillCoupon(ctx, tx, dto.Bill); err != nil {
+			return err
+		}
+		if _, err := s.refreshBillTotal(ctx, tx, dto.Bill); err != nil {
+			return err
+		}
+		if err := s.audit(ctx, tx, AuditBill, dto.Bill, AuditUpdate, before); err != nil {
+			return err
+		}
+
+		lines, _, _, err := s.calculateBill(ctx, tx, dto.Bill)
+		if err != nil {
+			return err
+		}
+		for i := range lines {
+			if lines[i].Product == dto.Product {
+				line = &lines[i]
+			}
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return line, nil
+}
+
+// takeProductStock decreases product's stock by quantity sold by bill. Stock cannot fall below zero, so concurrent
+// bills cannot sell the same items
+func (s *Service) takeProductStock(ctx context.Context, tx *sqlx.Tx, id, quantity int) error {
+	before, err := auditSnapshot(ctx, tx, AuditProduct, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+	UPDATE product SET quantity = quantity - $1 WHERE id = $2 AND quantity >= $1
+	`, quantity, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		var stock int
+		if err := tx.QueryRowContext(ctx, "SELECT quantity FROM product WHERE id = $1", id).Scan(&stock); err != nil {
+			if err == sql.ErrNoRows {
+				return &ApiError{Err: fmt.Sprintf("Product with passed id:%v not exists", id)}
+			}
+			return err
+		}
+		return &ApiError{Err: fmt.Sprintf("only %v of product id:%v are in stock, requested %v", stock, id, quantity)}
+	}
+	return s.audit(ctx, tx, AuditProduct, id, AuditUpdate, before)
+}
+
+// returnBillStock puts quantities of bill's lines back to stock before the lines are removed
+func (s *Service) returnBillStock(ctx context.Context, tx *sqlx.Tx, id int) error {
+	var lines []BillProduct
+	if err := tx.SelectContext(ctx, &lines, `
+	SELECT product_id AS product, quantity FROM productbill WHERE bill_id = $1 ORDER BY product_id
+	`, id); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if err := s.addProductStock(ctx, tx, line.Product, line.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addProductStock increases product's stock by arrived or returned quantity
+func (s *Service) addProductStock(ctx context.Context, tx *sqlx.Tx, id, quantity int) error {
+	before, err := auditSnapshot(ctx, tx, AuditProduct, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE product SET quantity = quantity + $1 WHERE id = $2
+	`, quantity, id); err != nil {
+		return err
+	}
+	return s.audit(ctx, tx, AuditProduct, id, AuditUpdate, before)
+}
+
+// Payment-related methods
+const paymentColumns = `payment.id, payment.bill_id, payment.amount AS "amount.amount", payment.currency AS "amount.currency",
+	payment.method, payment.paid_at, payment.reference`
+
+func (s *Service) GetBillPayments(ctx context.Context, id int) ([]Payment, error) {
+	return s.getBillPayments(ctx, s.db, id)
+}
+
+func (s *Service) getBillPayments(ctx context.Context, q sqlx.QueryerContext, id int) ([]Payment, error) {
+	payments, err := s.getBillsPayments(ctx, q, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if payments[id] == nil {
+		return []Payment{}, nil
+	}
+	return payments[id], nil
+}
+
+// getBillsPayments returns payments of passed bills by bill's id
+func (s *Service) getBillsPayments(ctx context.Context, q sqlx.QueryerContext, ids []int) (map[int][]Payment, error) {
+	var rows []Payment
+	if err := sqlx.SelectContext(ctx, q, &rows, `
+	SELECT `+paymentColumns+` FROM payment
+	WHERE payment.bill_id = ANY($1)
+	ORDER BY payment.paid_at, payment.id
+	`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	payments := map[int][]Payment{}
+	for _, payment := range rows {
+		payments[payment.Bill] = append(payments[payment.Bill], payment)
+	}
+	return payments, nil
+}
+
+// AddPayment records payment against bill. Payment cannot exceed bill's outstanding balance
+func (s *Service) AddPayment(ctx context.Context, dto PaymentDTOAdd) (*Payment, error) {
+	payment := Payment{Bill: dto.Bill, Amount: dto.Amount, Method: dto.Method, Reference: dto.Reference}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		status, err := s.lockBill(ctx, tx, dto.Bill)
+		if err != nil {
+			return err
+		}
+		if status == BillPaid {
+			return &ApiError{Err: fmt.Sprintf("Bill id:%v is already paid", dto.Bill)}
+		}
+		if status == BillVoid {
+			return &ApiError{Err: fmt.Sprintf("Bill id:%v is void", dto.Bill)}
+		}
+
+		balance, err := s.billBalance(ctx, tx, dto.Bill)
+		if err != nil {
+			return err
+		}
+		if dto.Amount.Currency != balance.Currency {
+			return &ApiError{Err: fmt.Sprintf("payment have to be in bill's currency %v, got %v", balance.Currency, dto.Amount.Currency)}
+		}
+		if dto.Amount.Amount <= 0 {
+			return &ApiError{Err: fmt.Sprintf("payment amount have to be positive, got %v", dto.Amount)}
+		}
+		if dto.Amount.Amount > balance.Amount {
+			return &ApiError{Err: fmt.Sprintf("payment of %v %v exceeds bill's outstanding balance %v %v",
+				dto.Amount, dto.Amount.Currency, balance, balance.Currency)}
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO payment (bill_id, amount, currency, method, paid_at, reference)
+		VALUES ($1, $2, $3, $4, COALESCE($5, NOW()), $6)
+		RETURNING id, paid_at
+		`, dto.Bill, dto.Amount.Amount, dto.Amount.Currency, dto.Method, dto.PaidAt, dto.Reference).Scan(&payment.Id, &payment.PaidAt); err != nil {
+			return err
+		}
+		if err := s.audit(ctx, tx, AuditPayment, payment.Id, AuditCreate, nil); err != nil {
+			return err
+		}
+
+		return s.refreshBillStatus(ctx, tx, dto.Bill)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &payment, nil
+}
+
+// DeletePayment deletes payment recorded by mistake, bill becomes unpaid again
+func (s *Service) DeletePayment(ctx context.Context, bill_id, payment_id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if _, err := s.lockBill(ctx, tx, bill_id); err != nil {
+			return err
+		}
+
+		var hasCreditNotes bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT id FROM creditnote WHERE bill_id = $1)", bill_id).Scan(&hasCreditNotes); err != nil {
+			return err
+		}
+		if hasCreditNotes {
+			return &ApiError{Err: fmt.Sprintf("Bill id:%v has credit notes, its payments cannot be deleted", bill_id)}
+		}
+
+		before, err := auditSnapshot(ctx, tx, AuditPayment, payment_id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+		DELETE FROM payment WHERE id = $1 AND bill_id = $2
+		`, payment_id, bill_id)
+		if err != nil {
+			return err
+		}
+		// payment of another bill is kept
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			if err := s.audit(ctx, tx, AuditPayment, payment_id, AuditDelete, before); err != nil {
+				return err
+			}
+		}
+
+		return s.refreshBillStatus(ctx, tx, bill_id)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// billSettlement sums bill's payments, credit notes and refunds
+func (s *Service) billSettlement(ctx context.Context, q sqlx.QueryerContext, id int) (Settlement, error) {
+	settlements, err := s.billSettlements(ctx, q, []int{id})
+	return settlements[id], err
+}
+
+// billSettlements sums payments, credit notes and refunds of passed bills by bill's id
+func (s *Service) billSettlements(ctx context.Context, q sqlx.QueryerContext, ids []int) (map[int]Settlement, error) {
+	var rows []struct {
+		Bill int `db:"bill_id"`
+		Settlement
+	}
+	if err := sqlx.SelectContext(ctx, q, &rows, `
+	SELECT bill.id AS bill_id,
+		COALESCE((SELECT SUM(payment.amount) FROM payment WHERE payment.bill_id = bill.id), 0) AS paid,
+		COALESCE((
+			SELECT SUM(creditnoteline.amount) FROM creditnoteline
+			JOIN creditnote ON creditnote.id = creditnoteline.credit_note_id
+			WHERE creditnote.bill_id = bill.id
+		), 0) AS credited,
+		COALESCE((
+			SELECT SUM(refund.amount) FROM refund
+			JOIN creditnote ON creditnote.id = refund.credit_note_id
+			WHERE creditnote.bill_id = bill.id
+		), 0) AS refunded
+	FROM bill
+	WHERE bill.id = ANY($1)
+	`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	settlements := make(map[int]Settlement, len(rows))
+	for _, row := range rows {
+		settlements[row.Bill] = row.Settlement
+	}
+	return settlements, nil
+}
+
+// billBalance returns amount left to pay of bill. Negative balance is owed to customer after return
+func (s *Service) billBalance(ctx context.Context, tx *sqlx.Tx, id int) (Money, error) {
+	_, totals, _, err := s.calculateBill(ctx, tx, id)
+	if err != nil {
+		return Money{}, err
+	}
+
+	settlement, err := s.billSettlement(ctx, tx, id)
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(settlement.balance(totals.Total.Amount), totals.Total.Currency), nil
+}
+
+// refreshBillStatus moves bill to paid when its balance reaches zero and back when payments are deleted
+func (s *Service) refreshBillStatus(ctx context.Context, tx *sqlx.Tx, id int) error {
+	balance, err := s.billBalance(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	var hasPayments bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT id FROM payment WHERE bill_id = $1)", id).Scan(&hasPayments); err != nil {
+		return err
+	}
+
+	status := BillOpen
+	if hasPayments && balance.Amount <= 0 {
+		status = BillPaid
+	} else if hasPayments {
+		status = BillPartiallyPaid
+	}
+
+	before, err := auditSnapshot(ctx, tx, AuditBill, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE bill SET status = $1 WHERE id = $2", status, id); err != nil {
+		return err
+	}
+	return s.audit(ctx, tx, AuditBill, id, AuditUpdate, before)
+}
+
+// Credit note-related methods
+const refundColumns = `refund.id, refund.credit_note_id, refund.amount AS "amount.amount", refund.currency AS "amount.currency",
+	refund.method, refund.refunded_at, refund.reference`
+
+func (s *Service) GetBillCreditNotes(ctx context.Context, id int) ([]CreditNote, error) {
+	var ids []int
+	if err := s.db.SelectContext(ctx, &ids, `
+	SELECT id FROM creditnote WHERE bill_id = $1 ORDER BY id
+	`, id); err != nil {
+		return nil, err
+	}
+
+	notes := make([]CreditNote, 0, len(ids))
+	for _, id := range ids {
+		note, err := s.getCreditNote(ctx, s.db, id)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *note)
+	}
+	return notes, nil
+}
+
+func (s *Service) GetCreditNoteById(ctx context.Context, id int) (*CreditNote, error) {
+	return s.getCreditNote(ctx, s.db, id)
+}
+
+func (s *Service) getCreditNote(ctx context.Context, q sqlx.QueryerContext, id int) (*CreditNote, error) {
+	note := &CreditNote{}
+	var currency string
+	if err := q.QueryRowxContext(ctx, `
+	SELECT creditnote.id, creditnote.bill_id, creditnote.created_at, creditnote.reason, creditnote.restock, bill.currency
+	FROM creditnote
+	JOIN bill ON bill.id = creditnote.bill_id
+	WHERE creditnote.id = $1
+	`, id).Scan(&note.Id, &note.Bill, &note.CreatedAt, &note.Reason, &note.Restock, &currency); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Credit note with passed id:%v not exists", id)}
+		}
+		return nil, err
+	}
+
+	note.Lines = []CreditNoteLine{}
+	if err := sqlx.SelectContext(ctx, q, &note.Lines, `
+	SELECT product_id, quantity, amount AS "amount.amount", $2 AS "amount.currency" FROM creditnoteline
+	WHERE credit_note_id = $1
+	ORDER BY product_id
+	`, id, currency); err != nil {
+		return nil, err
+	}
+	note.Amount = NewMoney(0, currency)
+	for _, line := range note.Lines {
+		note.Amount.Amount += line.Amount.Amount
+	}
+
+	note.Refunds = []Refund{}
+	if err := sqlx.SelectContext(ctx, q, &note.Refunds, `
+	SELECT `+refundColumns+` FROM refund
+	WHERE refund.credit_note_id = $1
+	ORDER BY refund.refunded_at, refund.id
+	`, id); err != nil {
+		return nil, err
+	}
+	note.Refunded = NewMoney(0, currency)
+	for _, refund := range note.Refunds {
+		note.Refunded.Amount += refund.Amount.Amount
+	}
+	return note, nil
+}
+
+// AddCreditNote returns products of paid bill. Each line is credited with its share of paid amount
+// including discounts and taxes, quantities cannot exceed ones not returned yet
+func (s *Service) AddCreditNote(ctx context.Context, dto CreditNoteDTOAdd) (*CreditNote, error) {
+	var note *CreditNote
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		status, err := s.lockBill(ctx, tx, dto.Bill)
+		if err != nil {
+			return err
+		}
+		if status == BillOpen {
+			return &ApiError{Err: fmt.Sprintf("Bill id:%v is open, change its products instead of issuing credit note", dto.Bill)}
+		}
+		if status == BillVoid {
+			return &ApiError{Err: fmt.Sprintf("Bill id:%v is void", dto.Bill)}
+		}
+
+		lines, totals, coupon, err := s.calculateBill(ctx, tx, dto.Bill)
+		if err != nil {
+			return err
+		}
+		paid := paidLineAmounts(totals, lines, coupon)
+
+		var returned []struct {
+			Product  int `db:"product_id"`
+			Quantity int `db:"quantity"`
+		}
+		if err := tx.SelectContext(ctx, &returned, `
+		SELECT creditnoteline.product_id, SUM(creditnoteline.quantity) AS quantity FROM creditnoteline
+		JOIN creditnote ON creditnote.id = creditnoteline.credit_note_id
+		WHERE creditnote.bill_id = $1
+		GROUP BY creditnoteline.product_id
+		`, dto.Bill); err != nil {
+			return err
+		}
+		returnedQuantity := map[int]int{}
+		for _, r := range returned {
+			returnedQuantity[r.Product] = r.Quantity
+		}
+
+		var id int
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO creditnote (bill_id, reason, restock) VALUES ($1, $2, $3) RETURNING id
+		`, dto.Bill, dto.Reason, dto.Restock).Scan(&id); err != nil {
+			return err
+		}
+
+		passed := map[int]bool{}
+		for _, line := range dto.Lines {
+			if passed[line.Product] {
+				return &ApiError{Err: fmt.Sprintf("product with passed id:%v passed more than once", line.Product)}
+			}
+			passed[line.Product] = true
+
+			i := -1
+			for j := range lines {
+				if lines[j].Product == line.Product {
+					i = j
+				}
+			}
+			if i < 0 {
+				return &ApiError{Err: fmt.Sprintf("product id:%v isn't sold by bill id:%v", line.Product, dto.Bill)}
+			}
+			sold, alreadyReturned := lines[i].Quantity, returnedQuantity[line.Product]
+			if alreadyReturned+line.Quantity > sold {
+				return &ApiError{Err: fmt.Sprintf("only %v of %v items of product id:%v can be returned, got %v",
+					sold-alreadyReturned, sold, line.Product, line.Quantity)}
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+			INSERT INTO creditnoteline (credit_note_id, bill_id, product_id, quantity, amount) VALUES ($1, $2, $3, $4, $5)
+			`, id, dto.Bill, line.Product, line.Quantity, creditAmount(paid[i], sold, alreadyReturned, line.Quantity)); err != nil {
+				return err
+			}
+
+			if dto.Restock {
+				if err := s.addProductStock(ctx, tx, line.Product, line.Quantity); err != nil {
+					return err
+				}
+			}
+		}
+		if err := s.audit(ctx, tx, AuditCreditNote, id, AuditCreate, nil); err != nil {
+			return err
+		}
+
+		if err := s.refreshBillStatus(ctx, tx, dto.Bill); err != nil {
+			return err
+		}
+
+		note, err = s.getCreditNote(ctx, tx, id)
+		return err
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return note, nil
+}
+
+// AddRefund pays back credited amount. Refund cannot exceed credit note's amount not refunded yet
+// nor amount bill's customer overpaid
+func (s *Service) AddRefund(ctx context.Context, dto RefundDTOAdd) (*Refund, error) {
+	refund := Refund{CreditNote: dto.CreditNote, Amount: dto.Amount, Method: dto.Method, Reference: dto.Reference}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		var bill int
+		if err := tx.QueryRowContext(ctx, "SELECT bill_id FROM creditnote WHERE id = $1", dto.CreditNote).Scan(&bill); err != nil {
+			if err == sql.ErrNoRows {
+				err = &ApiError{Err: fmt.Sprintf("Credit note with passed id:%v not exists", dto.CreditNote)}
+			}
+			return err
+		}
+		if _, err := s.lockBill(ctx, tx, bill); err != nil {
+			return err
+		}
+
+		note, err := s.getCreditNote(ctx, tx, dto.CreditNote)
+		if err != nil {
+			return err
+		}
+		if dto.Amount.Currency != note.Amount.Currency {
+			return &ApiError{Err: fmt.Sprintf("refund have to be in bill's currency %v, got %v", note.Amount.Currency, dto.Amount.Currency)}
+		}
+		if dto.Amount.Amount <= 0 {
+			return &ApiError{Err: fmt.Sprintf("refund amount have to be positive, got %v", dto.Amount)}
+		}
+		if left := note.Amount.Amount - note.Refunded.Amount; dto.Amount.Amount > left {
+			return &ApiError{Err: fmt.Sprintf("refund of %v %v exceeds credit note's amount left to refund %v %v",
+				dto.Amount, dto.Amount.Currency, NewMoney(left, note.Amount.Currency), note.Amount.Currency)}
+		}
+
+		balance, err := s.billBalance(ctx, tx, bill)
+		if err != nil {
+			return err
+		}
+		owed := NewMoney(-balance.Amount, balance.Currency)
+		if owed.Amount < 0 {
+			owed.Amount = 0
+		}
+		if dto.Amount.Amount > owed.Amount {
+			return &ApiError{Err: fmt.Sprintf("refund of %v %v exceeds amount owed to customer %v %v",
+				dto.Amount, dto.Amount.Currency, owed, owed.Currency)}
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO refund (credit_note_id, amount, currency, method, refunded_at, reference)
+		VALUES ($1, $2, $3, $4, COALESCE($5, NOW()), $6)
+		RETURNING id, refunded_at
+		`, dto.CreditNote, dto.Amount.Amount, dto.Amount.Currency, dto.Method, dto.RefundedAt, dto.Reference).Scan(&refund.Id, &refund.RefundedAt); err != nil {
+			return err
+		}
+		if err := s.audit(ctx, tx, AuditRefund, refund.Id, AuditCreate, nil); err != nil {
+			return err
+		}
+
+		return s.refreshBillStatus(ctx, tx, bill)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &refund, nil
+}
+
+// Tax-related methods
+func (s *Service) GetTaxClasses(ctx context.Context) (classes []TaxClass, err error) {
+	classes = []TaxClass{}
+	if err = s.db.SelectContext(ctx, &classes, `
+	SELECT taxclass.id, taxclass.name FROM taxclass ORDER BY taxclass.id
+	`); err != nil {
+		return
+	}
+
+	for i := range classes {
+		if classes[i].Rates, err = s.getTaxRates(ctx, classes[i].Id); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *Service) GetTaxClassById(ctx context.Context, id int) (*TaxClass, error) {
+	class := &TaxClass{}
+	if err := s.db.GetContext(ctx, class, `
+	SELECT taxclass.id, taxclass.name FROM taxclass WHERE id = $1
+	`, id); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Tax class with passed id:%v not exists", id)}
+		}
+		return nil, err
+	}
+
+	var err error
+	if class.Rates, err = s.getTaxRates(ctx, id); err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+func (s *Service) getTaxRates(ctx context.Context, id int) (rates []TaxRate, err error) {
+	rates = []TaxRate{}
+	err = s.db.SelectContext(ctx, &rates, `
+	SELECT taxrate.id, taxrate.rate, taxrate.effective_from FROM taxrate
+	WHERE taxrate.tax_class_id = $1
+	ORDER BY taxrate.effective_from
+	`, id)
+	return
+}
+
+func (s *Service) AddTaxClass(ctx context.Context, dto TaxClassDTOAdd) (*TaxClass, error) {
+	class := TaxClass{Name: dto.Name, Rates: []TaxRate{}}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO taxclass (name) VALUES ($1) RETURNING id
+		`, dto.Name).Scan(&class.Id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditTaxClass, class.Id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &class, nil
+}
+
+func (s *Service) DeleteTaxClassById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditTaxClass, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM taxclass WHERE id = $1
+		`, id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{"Tax class assigned to products cannot be deleted"}
+			}
+			return err
+		}
+		return s.audit(ctx, tx, AuditTaxClass, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// AddTaxRate schedules new rate of tax class. Bills keep rates effective at their creation
+func (s *Service) AddTaxRate(ctx context.Context, dto TaxRateDTOAdd) (*TaxRate, error) {
+	rate := TaxRate{Rate: dto.Rate, EffectiveFrom: dto.EffectiveFrom}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditTaxClass, dto.TaxClass)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO taxrate (tax_class_id, rate, effective_from) VALUES ($1, $2, $3) RETURNING id
+		`, dto.TaxClass, dto.Rate, dto.EffectiveFrom).Scan(&rate.Id); err != nil {
+			if err, ok := err.(*pq.Error); ok {
+				switch err.Code {
+				case pq.ErrorCode("23505"): // unique_violation
+					return &ApiError{"Tax class already has rate effective from passed date"}
+				case pq.ErrorCode("23503"): // foreign_key_violation
+					return &ApiError{Err: fmt.Sprintf("Tax class with passed id:%v not exists", dto.TaxClass)}
+				}
+			}
+			return err
+		}
+		return s.audit(ctx, tx, AuditTaxClass, dto.TaxClass, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &rate, nil
+}
+
+func (s *Service) DeleteTaxRate(ctx context.Context, class_id, rate_id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditTaxClass, class_id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM taxrate WHERE id = $1 AND tax_class_id = $2
+		`, rate_id, class_id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditTaxClass, class_id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// Exchange rate-related methods
+const exchangeRateColumns = `exchangerate.id, exchangerate.base, exchangerate.quote, exchangerate.rate::text AS rate,
+	exchangerate.effective_from`
+
+// GetExchangeRates returns rates from the latest, empty currency doesn't filter rates
+func (s *Service) GetExchangeRates(ctx context.Context, base, quote string) (rates []ExchangeRate, err error) {
+	rates = []ExchangeRate{}
+	if err = s.db.SelectContext(ctx, &rates, `
+	SELECT `+exchangeRateColumns+` FROM exchangerate
+	WHERE ($1 = '' OR base = $1) AND ($2 = '' OR quote = $2)
+	ORDER BY effective_from DESC, base, quote
+	`, base, quote); err != nil {
+		return
+	}
+	for i := range rates {
+		rates[i].Rate = normalizeRate(rates[i].Rate)
+	}
+	return
+}
+
+func (s *Service) AddExchangeRate(ctx context.Context, dto ExchangeRateDTOAdd) (*ExchangeRate, error) {
+	if err := dto.validate(); err != nil {
+		return nil, err
+	}
+
+	rate := ExchangeRate{Base: dto.Base, Quote: dto.Quote, Rate: normalizeRate(dto.Rate), EffectiveFrom: dto.EffectiveFrom}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO exchangerate (base, quote, rate, effective_from) VALUES ($1, $2, $3, $4) RETURNING id
+		`, dto.Base, dto.Quote, dto.Rate, dto.EffectiveFrom).Scan(&rate.Id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23505") { // unique_violation
+				return &ApiError{Err: fmt.Sprintf("Rate from %v to %v effective from passed time already exists", dto.Base, dto.Quote)}
+			}
+			return err
+		}
+		return s.audit(ctx, tx, AuditExchangeRate, rate.Id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &rate, nil
+}
+
+// ImportExchangeRates loads rates from csv. Import is all or nothing, rates with the same currencies and
+// effective time are replaced
+func (s *Service) ImportExchangeRates(ctx context.Context, r io.Reader) (*ExchangeRatesImport, error) {
+	rates, err := parseExchangeRatesCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		for _, rate := range rates {
+			// replaced rate is snapshotted for audit
+			var before json.RawMessage
+			var id int
+			if err := tx.QueryRowContext(ctx, `
+			SELECT id FROM exchangerate WHERE base = $1 AND quote = $2 AND effective_from = $3
+			`, rate.Base, rate.Quote, rate.EffectiveFrom).Scan(&id); err != nil && err != sql.ErrNoRows {
+				return err
+			} else if err == nil {
+				if before, err = auditSnapshot(ctx, tx, AuditExchangeRate, id); err != nil {
+					return err
+				}
+			}
+
+			if err := tx.QueryRowContext(ctx, `
+			INSERT INTO exchangerate (base, quote, rate, effective_from) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (base, quote, effective_from) DO UPDATE SET rate = EXCLUDED.rate
+			RETURNING id
+			`, rate.Base, rate.Quote, rate.Rate, rate.EffectiveFrom).Scan(&id); err != nil {
+				return err
+			}
+
+			action := AuditUpdate
+			if before == nil {
+				action = AuditCreate
+			}
+			if err := s.audit(ctx, tx, AuditExchangeRate, id, action, before); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return &ExchangeRatesImport{Imported: len(rates)}, nil
+}
+
+func (s *Service) DeleteExchangeRateById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditExchangeRate, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM exchangerate WHERE id = $1
+		`, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditExchangeRate, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// Coupon-related methods
+const couponColumns = `coupon.id, coupon.code, coupon.discount, coupon.valid_from, coupon.valid_to,
+	coupon.usage_limit, coupon.usage_limit_per_customer, COALESCE(coupon.currency, '') AS currency, coupon.min_bill_total, coupon.products, coupon.categories,
+	(SELECT COUNT(*) FROM couponredemption WHERE couponredemption.coupon_id = coupon.id) AS used`
+
+// couponError converts violation of coupon's code uniqueness into ApiError
+func couponError(err error) error {
+	if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23505") { // unique_violation
+		return &ApiError{"Coupon with passed code already exists"}
+	}
+	return err
+}
+
+func validateCoupon(dto CouponDTOAdd) error {
+	if err := dto.Discount.validate(); err != nil {
+		return err
+	}
+	if dto.ValidFrom != nil && dto.ValidTo != nil && !dto.ValidFrom.Before(*dto.ValidTo) {
+		return &ApiError{Err: "coupon's valid_from have to be before valid_to"}
+	}
+	if dto.Currency == "" {
+		if dto.Discount.Type == DiscountFixed || dto.MinBillTotal > 0 {
+			return &ApiError{Err: "currency is required for coupon with fixed discount or minimal bill total"}
+		}
+	} else if _, ok := currencyExponents[dto.Currency]; !ok {
+		return &ApiError{Err: fmt.Sprintf("unsupported currency %q", dto.Currency)}
+	}
+	return nil
+}
+
+func (s *Service) GetCoupons(ctx context.Context) (coupons []Coupon, err error) {
+	coupons = []Coupon{}
+	if err = s.db.SelectContext(ctx, &coupons, `
+	SELECT `+couponColumns+` FROM coupon
+	ORDER BY coupon.id
+	`); err != nil {
+		return
+	}
+	return
+}
+
+func (s *Service) GetCouponById(ctx context.Context, id int) (*Coupon, error) {
+	coupon := &Coupon{}
+	if err := s.db.GetContext(ctx, coupon, `
+	SELECT `+couponColumns+` FROM coupon
+	WHERE id = $1
+	`, id); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Coupon with passed id:%v not exists", id)}
+		}
+		return nil, err
+	}
+	return coupon, nil
+}
+
+func (s *Service) AddCoupon(ctx context.Context, dto CouponDTOAdd) (*Coupon, error) {
+	if err := validateCoupon(dto); err != nil {
+		return nil, err
+	}
+
+	var id int
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO coupon (code, discount, valid_from, valid_to, usage_limit, usage_limit_per_customer, currency, min_bill_total,
+			products, categories)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10) RETURNING id
+		`, dto.Code, dto.Discount.arg(), dto.ValidFrom, dto.ValidTo, dto.UsageLimit, dto.UsageLimitPerCustomer,
+			dto.Currency, dto.MinBillTotal, pq.Array(dto.Products), pq.Array(dto.Categories)).Scan(&id); err != nil {
+			return couponError(err)
+		}
+		return s.audit(ctx, tx, AuditCoupon, id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Commit()
+	return s.GetCouponById(ctx, id)
+}
+
+// UpdateCouponById changes coupon's terms. Bills which already use coupon keep their discount
+func (s *Service) UpdateCouponById(ctx context.Context, dto CouponDTOUpdate) error {
+	if err := validateCoupon(dto.CouponDTOAdd); err != nil {
+		return err
+	}
+
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCoupon, dto.Id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE coupon
+		SET code = $2, discount = $3, valid_from = $4, valid_to = $5, usage_limit = $6, usage_limit_per_customer = $7,
+			currency = NULLIF($8, ''), min_bill_total = $9, products = $10, categories = $11
+		WHERE id = $1
+		`, dto.Id, dto.Code, dto.Discount.arg(), dto.ValidFrom, dto.ValidTo, dto.UsageLimit, dto.UsageLimitPerCustomer,
+			dto.Currency, dto.MinBillTotal, pq.Array(dto.Products), pq.Array(dto.Categories)); err != nil {
+			return couponError(err)
+		}
+		return s.audit(ctx, tx, AuditCoupon, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) DeleteCouponById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditCoupon, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM coupon WHERE id = $1
+		`, id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{"Coupon used in bills cannot be deleted"}
+			}
+			return err
+		}
+		return s.audit(ctx, tx, AuditCoupon, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// applyCoupon checks coupon's terms and records its usage by bill. Coupon's row is locked until transaction
+// ends, so concurrent bills cannot exceed usage limits
+func (s *Service) applyCoupon(ctx context.Context, tx *sqlx.Tx, id, customer int, code string) error {
+	var couponId int
+	if err := tx.QueryRowContext(ctx, `
+	SELECT id FROM coupon WHERE LOWER(code) = LOWER($1) FOR UPDATE
+	`, code).Scan(&couponId); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Coupon %v not exists", code)}
+		}
+		return err
+	}
+
+	coupon := &Coupon{}
+	if err := tx.GetContext(ctx, coupon, `
+	SELECT `+couponColumns+` FROM coupon WHERE id = $1
+	`, couponId); err != nil {
+		return err
+	}
+
+	if err := s.checkCouponUsage(ctx, tx, coupon, customer); err != nil {
+		return err
+	}
+
+	amount, err := s.billCouponDiscount(ctx, tx, id, coupon)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO couponredemption (coupon_id, bill_id, customer_id, amount) VALUES ($1, $2, $3, $4)
+	`, coupon.Id, id, customer, amount)
+	return err
+}
+
+// checkCouponUsage checks that coupon is valid at the moment and its usage limits aren't exhausted.
+// Customer's limit isn't checked when customer is unknown yet
+func (s *Service) checkCouponUsage(ctx context.Context, q sqlx.QueryerContext, coupon *Coupon, customer int) error {
+	now := time.Now()
+	if (coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom)) || (coupon.ValidTo != nil && !now.Before(*coupon.ValidTo)) {
+		return &ApiError{Err: fmt.Sprintf("Coupon %v isn't valid at the moment", coupon.Code)}
+	}
+	if coupon.UsageLimit > 0 && coupon.Used >= coupon.UsageLimit {
+		return &ApiError{Err: fmt.Sprintf("Coupon %v usage limit is exhausted", coupon.Code)}
+	}
+	if coupon.UsageLimitPerCustomer > 0 && customer != 0 {
+		var used int
+		if err := q.QueryRowxContext(ctx, `
+		SELECT COUNT(*) FROM couponredemption WHERE coupon_id = $1 AND customer_id = $2
+		`, coupon.Id, customer).Scan(&used); err != nil {
+			return err
+		}
+		if used >= coupon.UsageLimitPerCustomer {
+			return &ApiError{Err: fmt.Sprintf("Coupon %v usage limit for customer is exhausted", coupon.Code)}
+		}
+	}
+	return nil
+}
+
+// refreshBillCoupon recalculates discount of coupon applied to bill after its products change. Bill which no longer
+// qualifies for coupon keeps it without discount, so its products can be changed freely and the discount comes back
+// once bill qualifies again
+func (s *Service) refreshBillCoupon(ctx context.Context, tx *sqlx.Tx, id int) error {
+	coupon := &Coupon{}
+	if err := tx.GetContext(ctx, coupon, `
+	SELECT `+couponColumns+` FROM coupon
+	JOIN couponredemption ON couponredemption.coupon_id = coupon.id
+	WHERE couponredemption.bill_id = $1
+	`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	amount, err := s.billCouponDiscount(ctx, tx, id, coupon)
+	if _, ok := err.(*ApiError); ok {
+		amount, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE couponredemption SET amount = $1 WHERE bill_id = $2", amount, id)
+	return err
+}
+
+func (s *Service) billCouponDiscount(ctx context.Context, tx *sqlx.Tx, id int, coupon *Coupon) (int64, error) {
+	var discount *Discount
+	var currency string
+	if err := tx.QueryRowContext(ctx, "SELECT discount, currency FROM bill WHERE id = $1", id).Scan(&discount, &currency); err != nil {
+		return 0, err
+	}
+
+	lines, err := s.getBillLines(ctx, tx, id)
+	if err != nil {
+		return 0, err
 	}
-	return &product, nil
+	return couponDiscount(coupon, lines, discount, currency)
 }
 
-func (s *Service) UpdateProductById(ctx context.Context, dto ProductDTOUpdate) error {
-	if _, err := s.db.NamedExecContext(ctx, `
-	UPDATE product
-	SET name = :name, description = :description, price = :price, quantity = :quantity
-	WHERE id = :id
-	`, &dto); err != nil {
+// validateProductsExist checks that every passed product exists and reports missing ones. Archived products are
+// treated as missing unless they are already lines of passed bill, which is 0 when products aren't billed
+func (s *Service) validateProductsExist(ctx context.Context, tx *sqlx.Tx, ids []int, bill int) error {
+	var existing []int
+	if err := tx.SelectContext(ctx, &existing, `
+	SELECT id FROM product WHERE id = ANY($1)
+		AND (archived_at IS NULL OR id IN (SELECT product_id FROM productbill WHERE bill_id = $2))
+	`, pq.Array(ids), bill); err != nil {
 		return err
 	}
-	return nil
-}
 
-func (s *Service) DeleteProductById(ctx context.Context, id int) error {
-	if _, err := s.db.ExecContext(ctx, `
-	DELETE FROM product WHERE id = $1
-	`, id); err != nil {
-		return err
+	found := make(map[int]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+
+	missing := []int{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return &ApiError{Err: fmt.Sprintf("products with passed ids:%v not exists", missing)}
 	}
 	return nil
 }
 
-// Customer-related methods
-func (s *Service) GetCustomers(ctx context.Context) (customers []Customer, err error) {
-	customers = []Customer{}
-	if err = s.db.SelectContext(ctx, &customers, `
-	SELECT customer.id, customer.first_name, customer.last_name FROM customer
+// Supplier-related methods
+func (s *Service) GetSuppliers(ctx context.Context) (suppliers []Supplier, err error) {
+	suppliers = []Supplier{}
+	if err = s.db.SelectContext(ctx, &suppliers, `
+	SELECT supplier.id, supplier.name, supplier.email, supplier.phone FROM supplier
 	`); err != nil {
 		return
 	}
 	return
 }
 
-func (s *Service) GetCustomerById(ctx context.Context, id int) (*Customer, error) {
-	customer := &Customer{}
-	if err := s.db.GetContext(ctx, customer, `
-	SELECT customer.id, customer.first_name, customer.last_name FROM customer
+func (s *Service) GetSupplierById(ctx context.Context, id int) (*Supplier, error) {
+	supplier := &Supplier{}
+	if err := s.db.GetContext(ctx, supplier, `
+	SELECT supplier.id, supplier.name, supplier.email, supplier.phone FROM supplier
 	WHERE id = $1
 	`, id); err != nil {
 		if err == sql.ErrNoRows {
-			err = &ApiError{Err: fmt.Sprintf("Customer with passed id:%v not exists", id)}
+			err = &ApiError{Err: fmt.Sprintf("Supplier with passed id:%v not exists", id)}
 		}
 		return nil, err
 	}
-	return customer, nil
+	return supplier, nil
 }
 
-func (s *Service) AddCustomer(ctx context.Context, dto CustomerDTOAdd) (*Customer, error) {
-	var customer Customer
-	resp, err := s.db.NamedQueryContext(ctx, `
-	INSERT INTO customer (first_name, last_name) VALUES (:first_name, :last_name) RETURNING id
-	`, &dto)
-	if err != nil {
+func (s *Service) AddSupplier(ctx context.Context, dto SupplierDTOAdd) (*Supplier, error) {
+	supplier := Supplier{Name: dto.Name, Email: dto.Email, Phone: dto.Phone}
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO supplier (name, email, phone) VALUES ($1, $2, $3) RETURNING id
+		`, dto.Name, dto.Email, dto.Phone).Scan(&supplier.Id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditSupplier, supplier.Id, AuditCreate, nil)
+	}(); err != nil {
+		tx.Rollback()
 		return nil, err
 	}
 
-	for resp.Next() {
-		if err := resp.Scan(&customer.Id); err != nil {
-			return nil, err
-		}
-		customer.FirstName = dto.FirstName
-		customer.LastName = dto.LastName
-	}
-	return &customer, nil
+	tx.Commit()
+	return &supplier, nil
 }
 
-func (s *Service) UpdateCustomerById(ctx context.Context, dto CustomerDTOUpdate) error {
-	if _, err := s.db.NamedExecContext(ctx, `
-	UPDATE customer
-	SET first_name = :first_name, last_name = :last_name
-	WHERE id = :id
-	`, &dto); err != nil {
+func (s *Service) UpdateSupplierById(ctx context.Context, dto SupplierDTOUpdate) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditSupplier, dto.Id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+		UPDATE supplier
+		SET name = :name, email = :email, phone = :phone
+		WHERE id = :id
+		`, &dto); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditSupplier, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
 		return err
 	}
+
+	tx.Commit()
 	return nil
 }
 
-func (s *Service) DeleteCustomerById(ctx context.Context, id int) error {
-	if _, err := s.db.ExecContext(ctx, `
-	DELETE FROM customer WHERE id = $1
-	`, id); err != nil {
+func (s *Service) DeleteSupplierById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditSupplier, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM supplier WHERE id = $1
+		`, id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{"Supplier with purchase orders cannot be deleted"}
+			}
+			return err
+		}
+		return s.audit(ctx, tx, AuditSupplier, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
 		return err
 	}
+
+	tx.Commit()
 	return nil
 }
 
-// Bill-related methods
-func (s *Service) GetBills(ctx context.Context) (bills []Bill, err error) {
-	bills = []Bill{}
-	if err = s.db.SelectContext(ctx, &bills, `
-	SELECT bill.id, bill.number, bill.created_at, bill.customer_id FROM bill
-	`); err != nil {
+func (s *Service) GetSupplierProducts(ctx context.Context, id int) (products []SupplierProduct, err error) {
+	products = []SupplierProduct{}
+	if err = s.db.SelectContext(ctx, &products, `
+	SELECT supplierproduct.product_id, supplierproduct.price AS "price.amount", supplierproduct.currency AS "price.currency"
+	FROM supplierproduct
+	WHERE supplierproduct.supplier_id = $1
+	ORDER BY supplierproduct.product_id
+	`, id); err != nil {
 		return
 	}
 	return
 }
 
-func (s *Service) GetBillById(ctx context.Context, id int) (bill *BillVerbose, err error) {
-	bill = &BillVerbose{}
+// SetSupplierProduct adds product to supplier's price list or updates its price
+func (s *Service) SetSupplierProduct(ctx context.Context, dto SupplierDTOSetProduct) error {
+	if err := validatePrice(dto.Price); err != nil {
+		return err
+	}
+
 	tx := s.db.MustBeginTx(ctx, nil)
-	err = func() error {
-		err := tx.QueryRowContext(ctx, `
-		SELECT bill.id AS bill_id, bill.number, bill.created_at, customer.id AS customer_id, customer.first_name, customer.last_name
-		FROM bill
-		JOIN customer ON bill.customer_id = customer.id
-		WHERE bill.id = $1
-		`, id).Scan(
-			&bill.Id,
-			&bill.Number,
-			&bill.CreatedAt,
-			&bill.Customer.Id,
-			&bill.Customer.FirstName,
-			&bill.Customer.LastName,
-		)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditSupplier, dto.Id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				err = &ApiError{Err: fmt.Sprintf("Bill with passed id:%v not exists", id)}
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO supplierproduct (supplier_id, product_id, price, currency) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (supplier_id, product_id) DO UPDATE SET price = EXCLUDED.price, currency = EXCLUDED.currency
+		`, dto.Id, dto.Product, dto.Price.Amount, dto.Price.Currency); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{"Passed product or supplier not exists"}
 			}
 			return err
 		}
+		return s.audit(ctx, tx, AuditSupplier, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-		if err := tx.SelectContext(ctx, &bill.Products, `
-		SELECT productbill.product_id AS product, productbill.quantity FROM productbill WHERE productbill.bill_id = $1
-		`, id); err != nil {
+	tx.Commit()
+	return nil
+}
+
+func (s *Service) DeleteSupplierProduct(ctx context.Context, supplier_id, product_id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		before, err := auditSnapshot(ctx, tx, AuditSupplier, supplier_id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+		DELETE FROM supplierproduct WHERE supplier_id = $1 AND product_id = $2
+		`, supplier_id, product_id); err != nil {
 			return err
 		}
+		return s.audit(ctx, tx, AuditSupplier, supplier_id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-		return nil
-	}()
-	if err != nil {
+	tx.Commit()
+	return nil
+}
+
+// GetReorderSuggestion prefills purchase order for supplier's products which fell to their reorder point
+func (s *Service) GetReorderSuggestion(ctx context.Context, id int) (*PurchaseOrderDTOAdd, error) {
+	if _, err := s.GetSupplierById(ctx, id); err != nil {
 		return nil, err
 	}
-	return
+
+	dto := &PurchaseOrderDTOAdd{Supplier: id, Lines: []PurchaseOrderLineDTO{}}
+	if err := s.db.SelectContext(ctx, &dto.Lines, `
+	SELECT product.id AS product, GREATEST(product.reorder_quantity, 1) AS quantity,
+		supplierproduct.price AS "price.amount", supplierproduct.currency AS "price.currency"
+	FROM product
+	JOIN supplierproduct ON supplierproduct.product_id = product.id
+	WHERE supplierproduct.supplier_id = $1 AND product.archived_at IS NULL
+		AND product.reorder_point > 0 AND product.quantity <= product.reorder_point
+	ORDER BY product.id
+	`, id); err != nil {
+		return nil, err
+	}
+	return dto, nil
 }
 
-func (s *Service) validateUpsertBillFields(ctx context.Context, tx *sqlx.Tx, customer int, products []BillProduct) error {
-	// Checking customer on existence
-	var hasCustomer bool
-	tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT id FROM customer WHERE id = $1)", customer).Scan(&hasCustomer)
-	if !hasCustomer {
-		return &ApiError{Err: fmt.Sprintf("customer with passed id:%v not exists", customer)}
+// Purchase order-related methods
+func (s *Service) GetPurchaseOrders(ctx context.Context) (orders []PurchaseOrder, err error) {
+	orders = []PurchaseOrder{}
+	if err = s.db.SelectContext(ctx, &orders, `
+	SELECT purchaseorder.id, purchaseorder.supplier_id, purchaseorder.status, purchaseorder.created_at
+	FROM purchaseorder
+	ORDER BY purchaseorder.id
+	`); err != nil {
+		return
 	}
+	return
+}
 
-	// Checking that all passed products have to exists
-	buf := bytes.NewBufferString("SELECT COUNT(*) FROM product WHERE id IN (")
-	for i, billProduct := range products {
-		if i > 0 {
-			buf.WriteString(",")
+func (s *Service) GetPurchaseOrderById(ctx context.Context, id int) (*PurchaseOrder, error) {
+	order := &PurchaseOrder{}
+	if err := s.db.GetContext(ctx, order, `
+	SELECT purchaseorder.id, purchaseorder.supplier_id, purchaseorder.status, purchaseorder.created_at
+	FROM purchaseorder
+	WHERE id = $1
+	`, id); err != nil {
+		if err == sql.ErrNoRows {
+			err = &ApiError{Err: fmt.Sprintf("Purchase order with passed id:%v not exists", id)}
 		}
-		buf.WriteString(strconv.Itoa(billProduct.Product))
+		return nil, err
 	}
-	buf.WriteString(")")
 
-	var realProductsCount int
-	tx.QueryRowContext(ctx, buf.String()).Scan(&realProductsCount)
-	if len(products) != realProductsCount {
-		return &ApiError{Err: "not all passed products exists"}
+	order.Lines = []PurchaseOrderLine{}
+	if err := s.db.SelectContext(ctx, &order.Lines, `
+	SELECT purchaseorderline.product_id, purchaseorderline.quantity, purchaseorderline.received_quantity,
+		purchaseorderline.price AS "price.amount", purchaseorderline.currency AS "price.currency"
+	FROM purchaseorderline
+	WHERE purchaseorderline.purchase_order_id = $1
+	ORDER BY purchaseorderline.product_id
+	`, id); err != nil {
+		return nil, err
 	}
+	return order, nil
+}
 
-	return nil
+// lockPurchaseOrder locks purchase order's row until transaction ends and returns its status
+func (s *Service) lockPurchaseOrder(ctx context.Context, tx *sqlx.Tx, id int) (status string, err error) {
+	err = tx.QueryRowContext(ctx, `
+	SELECT status FROM purchaseorder WHERE id = $1 FOR UPDATE
+	`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		err = &ApiError{Err: fmt.Sprintf("Purchase order with passed id:%v not exists", id)}
+	}
+	return
 }
 
-func (s *Service) AddBill(ctx context.Context, dto BillDTOAdd) (*Bill, error) {
-	var bill Bill
-	tx := s.db.MustBeginTx(ctx, nil)
-	if err := func() error {
-		if err := s.validateUpsertBillFields(ctx, tx, dto.Customer, dto.Products); err != nil {
+func (s *Service) insertPurchaseOrderLines(ctx context.Context, tx *sqlx.Tx, id, supplier int, lines []PurchaseOrderLineDTO) error {
+	var hasSupplier bool
+	tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT id FROM supplier WHERE id = $1)", supplier).Scan(&hasSupplier)
+	if !hasSupplier {
+		return &ApiError{Err: fmt.Sprintf("supplier with passed id:%v not exists", supplier)}
+	}
+
+	ids := make([]int, 0, len(lines))
+	passed := map[int]bool{}
+	for _, line := range lines {
+		if passed[line.Product] {
+			return &ApiError{Err: fmt.Sprintf("product with passed id:%v passed more than once", line.Product)}
+		}
+		passed[line.Product] = true
+		ids = append(ids, line.Product)
+	}
+	if err := s.validateProductsExist(ctx, tx, ids, 0); err != nil {
+		return err
+	}
+
+	var currency string
+	for _, line := range lines {
+		// Price isn't passed so it is taken from supplier's price list
+		if line.Price.Amount == 0 {
+			err := tx.QueryRowContext(ctx, `
+			SELECT price, currency FROM supplierproduct WHERE supplier_id = $1 AND product_id = $2
+			`, supplier, line.Product).Scan(&line.Price.Amount, &line.Price.Currency)
+			if err == sql.ErrNoRows {
+				return &ApiError{Err: fmt.Sprintf("price of product id:%v isn't passed and absent in supplier's price list", line.Product)}
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := validatePrice(line.Price); err != nil {
 			return err
 		}
+		if currency == "" {
+			currency = line.Price.Currency
+		} else if line.Price.Currency != currency {
+			return &ApiError{Err: fmt.Sprintf("product id:%v is priced in %v while purchase order's products are priced in %v",
+				line.Product, line.Price.Currency, currency)}
+		}
 
-		err := tx.QueryRowContext(ctx, `
-		INSERT INTO bill (number, customer_id) VALUES ($1, $2) RETURNING id, created_at, number
-		`, uuid.New().String(), dto.Customer).Scan(&bill.Id, &bill.CreatedAt, &bill.Number)
-		if err != nil {
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO purchaseorderline (purchase_order_id, product_id, quantity, price, currency) VALUES ($1, $2, $3, $4, $5)
+		`, id, line.Product, line.Quantity, line.Price.Amount, line.Price.Currency); err != nil {
 			return err
 		}
-		bill.Customer = dto.Customer
+	}
+	return nil
+}
 
-		for _, billProduct := range dto.Products {
-			if _, err := tx.ExecContext(ctx, `
-			INSERT INTO productbill (product_id, bill_id, quantity) VALUES ($1, $2, $3)
-			`, billProduct.Product, bill.Id, billProduct.Quantity); err != nil {
-				return err
+func (s *Service) AddPurchaseOrder(ctx context.Context, dto PurchaseOrderDTOAdd) (*PurchaseOrder, error) {
+	var id int
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		if err := tx.QueryRowContext(ctx, `
+		INSERT INTO purchaseorder (supplier_id, status) VALUES ($1, $2) RETURNING id
+		`, dto.Supplier, PurchaseOrderDraft).Scan(&id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{Err: fmt.Sprintf("supplier with passed id:%v not exists", dto.Supplier)}
 			}
+			return err
 		}
 
-		return nil
+		if err := s.insertPurchaseOrderLines(ctx, tx, id, dto.Supplier, dto.Lines); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditPurchaseOrder, id, AuditCreate, nil)
 	}(); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
 	tx.Commit()
-	return &bill, nil
+	return s.GetPurchaseOrderById(ctx, id)
 }
 
-func (s *Service) UpdateBillById(ctx context.Context, dto BillDTOUpdate) error {
+// UpdatePurchaseOrderById replaces supplier and lines of draft purchase order
+func (s *Service) UpdatePurchaseOrderById(ctx context.Context, dto PurchaseOrderDTOUpdate) error {
 	tx := s.db.MustBeginTx(ctx, nil)
 	if err := func() error {
-		var hasBill bool
-		tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT id FROM bill WHERE id = $1)", dto.Id).Scan(&hasBill)
-		if !hasBill {
-			return &ApiError{Err: fmt.Sprintf("Bill with passed id:%v not exists", dto.Id)}
+		status, err := s.lockPurchaseOrder(ctx, tx, dto.Id)
+		if err != nil {
+			return err
 		}
-
-		if err := s.validateUpsertBillFields(ctx, tx, dto.Customer, dto.Products); err != nil {
+		if status != PurchaseOrderDraft {
+			return &ApiError{Err: fmt.Sprintf("Purchase order in status %v cannot be changed", status)}
+		}
+		before, err := auditSnapshot(ctx, tx, AuditPurchaseOrder, dto.Id)
+		if err != nil {
 			return err
 		}
 
 		if _, err := tx.ExecContext(ctx, `
-		UPDATE bill SET customer_id = $1 WHERE id = $2
-		`, dto.Customer, dto.Id); err != nil {
+		UPDATE purchaseorder SET supplier_id = $1 WHERE id = $2
+		`, dto.Supplier, dto.Id); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pq.ErrorCode("23503") { // foreign_key_violation
+				return &ApiError{Err: fmt.Sprintf("supplier with passed id:%v not exists", dto.Supplier)}
+			}
 			return err
 		}
 
-		if _, err := tx.ExecContext(ctx, "DELETE FROM productbill WHERE bill_id = $1", dto.Id); err != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM purchaseorderline WHERE purchase_order_id = $1", dto.Id); err != nil {
 			return err
 		}
 
-		for _, billProduct := range dto.Products {
-			if _, err := tx.ExecContext(ctx, `
-			INSERT INTO productbill (product_id, bill_id, quantity) VALUES ($1, $2, $3)
-			`, billProduct.Product, dto.Id, billProduct.Quantity); err != nil {
-				return err
-			}
+		if err := s.insertPurchaseOrderLines(ctx, tx, dto.Id, dto.Supplier, dto.Lines); err != nil {
+			return err
 		}
-
-		return nil
+		return s.audit(ctx, tx, AuditPurchaseOrder, dto.Id, AuditUpdate, before)
 	}(); err != nil {
 		tx.Rollback()
 		return err
@@ -310,51 +3279,132 @@ func (s *Service) UpdateBillById(ctx context.Context, dto BillDTOUpdate) error {
 	return nil
 }
 
-func (s *Service) DeleteBillById(ctx context.Context, id int) error {
-	if _, err := s.db.ExecContext(ctx, `
-	DELETE FROM bill WHERE id = $1
-	`, id); err != nil {
+// DeletePurchaseOrderById deletes purchase order which wasn't sent to supplier yet
+func (s *Service) DeletePurchaseOrderById(ctx context.Context, id int) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		status, err := s.lockPurchaseOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if status != PurchaseOrderDraft && status != PurchaseOrderCancelled {
+			return &ApiError{Err: fmt.Sprintf("Purchase order in status %v cannot be deleted", status)}
+		}
+
+		before, err := auditSnapshot(ctx, tx, AuditPurchaseOrder, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM purchaseorder WHERE id = $1", id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditPurchaseOrder, id, AuditDelete, before)
+	}(); err != nil {
+		tx.Rollback()
 		return err
 	}
+
+	tx.Commit()
 	return nil
 }
 
-func (s *Service) GetBillProducts(ctx context.Context, id int) (products []Product, err error) {
-	products = []Product{}
-	if err = s.db.SelectContext(ctx, &products, `
-	SELECT product.id, product.name, product.description, product.price, product.quantity
-	FROM product
-	JOIN productbill ON productbill.product_id = product.id
-	WHERE productbill.bill_id = $1
-	`, id); err != nil {
-		return
-	}
-	return
-}
+// setPurchaseOrderStatus moves purchase order to passed status if current one is allowed for transition
+func (s *Service) setPurchaseOrderStatus(ctx context.Context, id int, status string, allowed ...string) error {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		current, err := s.lockPurchaseOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
 
-func (s *Service) DeleteProductFromBill(ctx context.Context, bill_id, product_id int) error {
-	if _, err := s.db.ExecContext(ctx, `
-	DELETE FROM productbill WHERE bill_id = $1 and product_id = $2
-	`, bill_id, product_id); err != nil {
+		isAllowed := false
+		for _, a := range allowed {
+			if current == a {
+				isAllowed = true
+			}
+		}
+		if !isAllowed {
+			return &ApiError{Err: fmt.Sprintf("Purchase order cannot be moved from status %v to %v", current, status)}
+		}
+
+		before, err := auditSnapshot(ctx, tx, AuditPurchaseOrder, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE purchaseorder SET status = $1 WHERE id = $2", status, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditPurchaseOrder, id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
 		return err
 	}
+
+	tx.Commit()
 	return nil
 }
 
-func (s *Service) AddProductToBill(ctx context.Context, dto BillDtoAddProduct) error {
-	if _, err := s.db.ExecContext(ctx, `
-	INSERT INTO productbill (product_id, bill_id, quantity) VALUES ($1, $2, $3)
-	`, dto.BillProduct.Product, dto.Id, dto.BillProduct.Quantity); err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			switch err.Code {
-			case pq.ErrorCode("23505"): // unique_violation
-				return &ApiError{"Passed product already exists in bill"}
-			case pq.ErrorCode("23503"): // foreign_key_violation
-				return &ApiError{"Passed product or bill not exists"}
+func (s *Service) OrderPurchaseOrder(ctx context.Context, id int) error {
+	return s.setPurchaseOrderStatus(ctx, id, PurchaseOrderOrdered, PurchaseOrderDraft)
+}
+
+func (s *Service) CancelPurchaseOrder(ctx context.Context, id int) error {
+	return s.setPurchaseOrderStatus(ctx, id, PurchaseOrderCancelled, PurchaseOrderDraft, PurchaseOrderOrdered)
+}
+
+// ReceivePurchaseOrder registers arrived goods and increases products' stock within single transaction
+func (s *Service) ReceivePurchaseOrder(ctx context.Context, dto PurchaseOrderDTOReceive) (*PurchaseOrder, error) {
+	tx := s.db.MustBeginTx(ctx, nil)
+	if err := func() error {
+		status, err := s.lockPurchaseOrder(ctx, tx, dto.Id)
+		if err != nil {
+			return err
+		}
+		if status != PurchaseOrderOrdered && status != PurchaseOrderPartiallyReceived {
+			return &ApiError{Err: fmt.Sprintf("Purchase order in status %v cannot be received", status)}
+		}
+		before, err := auditSnapshot(ctx, tx, AuditPurchaseOrder, dto.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range dto.Lines {
+			res, err := tx.ExecContext(ctx, `
+			UPDATE purchaseorderline SET received_quantity = received_quantity + $1
+			WHERE purchase_order_id = $2 AND product_id = $3 AND received_quantity + $1 <= quantity
+			`, line.Quantity, dto.Id, line.Product)
+			if err != nil {
+				return err
+			}
+			if affected, _ := res.RowsAffected(); affected == 0 {
+				return &ApiError{Err: fmt.Sprintf("product id:%v isn't ordered or received quantity exceeds ordered one", line.Product)}
+			}
+
+			if err := s.addProductStock(ctx, tx, line.Product, line.Quantity); err != nil {
+				return err
 			}
 		}
 
-		return err
+		var isReceived bool
+		if err := tx.QueryRowContext(ctx, `
+		SELECT NOT EXISTS(SELECT 1 FROM purchaseorderline WHERE purchase_order_id = $1 AND received_quantity < quantity)
+		`, dto.Id).Scan(&isReceived); err != nil {
+			return err
+		}
+		status = PurchaseOrderPartiallyReceived
+		if isReceived {
+			status = PurchaseOrderReceived
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE purchaseorder SET status = $1 WHERE id = $2", status, dto.Id); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditPurchaseOrder, dto.Id, AuditUpdate, before)
+	}(); err != nil {
+		tx.Rollback()
+		return nil, err
 	}
-	return nil
+
+	tx.Commit()
+	return s.GetPurchaseOrderById(ctx, dto.Id)
 }
@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-playground/validator"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -26,7 +31,7 @@ func GetEnvironment() *Environment {
 			log.Fatal(err)
 		}
 
-		service := NewService(db)
+		service := NewService(db, config)
 		environmentInstance = &Environment{s: *service}
 	})
 	return environmentInstance
@@ -37,7 +42,7 @@ func TestProduct(t *testing.T) {
 	dtoAdd := ProductDTOAdd{
 		Name:        "Test Product",
 		Description: "Description of test product",
-		Price:       100000,
+		Price:       NewMoney(100000, "USD"),
 		Quantity:    100000,
 	}
 	var product *Product
@@ -87,7 +92,7 @@ func TestProduct(t *testing.T) {
 		Id:          product.Id,
 		Name:        "Updated Test Product",
 		Description: "Updated Description of test product",
-		Price:       50000,
+		Price:       NewMoney(50000, "USD"),
 		Quantity:    50000,
 	}
 	err = e.s.UpdateProductById(context.TODO(), dtoUpdate)
@@ -115,6 +120,160 @@ func TestProduct(t *testing.T) {
 	}
 }
 
+func TestProductImport(t *testing.T) {
+	e := GetEnvironment()
+
+	validate := validator.New()
+	importProducts := func(mode string, dryRun bool, file string) (*ProductImportSummary, []ProductImportRow) {
+		rows := []ProductImportRow{}
+		summary, err := e.s.ImportProducts(context.TODO(), strings.NewReader(file), ProductImportOptions{
+			Format:   ProductImportCSV,
+			Mode:     mode,
+			DryRun:   dryRun,
+			Validate: func(dto ProductDTOAdd) error { return validate.Struct(dto) },
+		}, func(row ProductImportRow) error {
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Error when importing products: %+v", err)
+		}
+		return summary, rows
+	}
+	fetch := func(sku string) *Product {
+		var product Product
+		if err := e.s.db.GetContext(context.TODO(), &product, "SELECT "+productColumns+" FROM product WHERE sku = $1", sku); err != nil {
+			return nil
+		}
+		return &product
+	}
+
+	file := `sku,name,description,price,quantity
+IMPORT-1,Imported One,Description,10.00,5
+IMPORT-2,Imported Two,,20.00,5
+`
+	// Row without description fails whole import
+	summary, rows := importProducts(ProductImportAllOrNothing, false, file)
+	if summary.Committed || summary.Created != 1 || summary.Failed != 1 || len(rows) != 2 || rows[1].Line != 3 || rows[1].Error == "" {
+		t.Errorf("Invalid report of failed import: %+v %+v", summary, rows)
+	}
+	if fetch("IMPORT-1") != nil {
+		t.Errorf("Failed all-or-nothing import cannot be committed")
+	}
+
+	summary, rows = importProducts(ProductImportBestEffort, true, file)
+	if summary.Committed || summary.Created != 1 || rows[0].Id != 0 || fetch("IMPORT-1") != nil {
+		t.Errorf("Dry run cannot be committed: %+v %+v", summary, rows)
+	}
+
+	summary, rows = importProducts(ProductImportBestEffort, false, file)
+	if !summary.Committed || summary.Created != 1 || summary.Failed != 1 {
+		t.Errorf("Invalid report of best-effort import: %+v %+v", summary, rows)
+	}
+	product := fetch("IMPORT-1")
+	if product == nil || product.Id != rows[0].Id || product.Price != NewMoney(1000, "USD") || product.Quantity != 5 {
+		t.Errorf("Imported product have to be committed, got %+v", product)
+	}
+
+	// Import upserts products by sku
+	summary, rows = importProducts(ProductImportAllOrNothing, false, `sku,name,description,price,quantity,category
+IMPORT-1,Imported One,Updated,12.50,7,imported
+IMPORT-2,Imported Two,Description,20.00,5,imported
+`)
+	if !summary.Committed || summary.Updated != 1 || summary.Created != 1 || rows[0].Action != ProductImportUpdated || rows[0].Id != product.Id {
+		t.Errorf("Invalid report of upsert: %+v %+v", summary, rows)
+	}
+	if updated := fetch("IMPORT-1"); updated == nil || updated.Description != "Updated" || updated.Price != NewMoney(1250, "USD") || updated.Quantity != 7 {
+		t.Errorf("Imported product have to be updated, got %+v", updated)
+	}
+
+	// Columns missing in file keep values of existing product
+	importProducts(ProductImportAllOrNothing, false, `sku,name,description,price,quantity,reorder_point,reorder_quantity
+IMPORT-1,Imported One,Updated,12.50,7,3,10
+`)
+	summary, rows = importProducts(ProductImportAllOrNothing, false, `sku,name,description,price,quantity
+IMPORT-1,Imported One,Renamed,13.00,8
+`)
+	if !summary.Committed || summary.Updated != 1 {
+		t.Errorf("Invalid report of partial update: %+v %+v", summary, rows)
+	}
+	if updated := fetch("IMPORT-1"); updated == nil || updated.Description != "Renamed" || updated.Quantity != 8 ||
+		updated.Category != "imported" || updated.ReorderPoint != 3 || updated.ReorderQuantity != 10 {
+		t.Errorf("Partial import have to keep category and reorder settings, got %+v", updated)
+	}
+
+	// Price without currency column cannot re-denominate existing product
+	importProducts(ProductImportAllOrNothing, false, `sku,name,description,price,quantity,currency
+IMPORT-1,Imported One,Renamed,13.00,8,EUR
+`)
+	summary, rows = importProducts(ProductImportBestEffort, false, `sku,name,description,price,quantity
+IMPORT-1,Imported One,Renamed,14.00,8
+`)
+	if summary.Failed != 1 || !strings.Contains(rows[0].Error, "EUR") {
+		t.Errorf("Price without currency of product in another currency have to fail: %+v %+v", summary, rows)
+	}
+	if updated := fetch("IMPORT-1"); updated == nil || updated.Price != NewMoney(1300, "EUR") {
+		t.Errorf("Product have to keep its price and currency, got %+v", updated)
+	}
+
+	// teardown
+	if _, err := e.s.db.ExecContext(context.TODO(), "DELETE FROM product WHERE sku LIKE 'IMPORT-%'"); err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestProductImport: %+v", err))
+	}
+	// end teardown
+}
+
+func TestProductSearch(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	// database ids of fixture's products by their fixture's ids
+	ids := map[int]int{}
+	for _, product := range searchProductsFixture {
+		added, err := e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        product.Name,
+			Description: product.Description,
+			Price:       product.Price,
+			Quantity:    1,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("Cannot setup TestProductSearch: %+v", err))
+		}
+		ids[added.Id] = product.Id
+	}
+	// end setup
+
+	// Database and in-memory search find fixture's products in the same order
+	for _, q := range []string{"samsng galaxy", "samsung", "phones", "charged", "samsung cable", "the case"} {
+		results, err := e.s.SearchProducts(context.TODO(), q, maxPageLimit, 0)
+		if err != nil {
+			t.Errorf("Error when searching products by %q: %+v", q, err)
+		}
+		found := []int{}
+		for _, result := range results {
+			if id, ok := ids[result.Id]; ok {
+				found = append(found, id)
+			}
+		}
+		if expected := searchResultIds(searchProducts(searchProductsFixture, q, maxPageLimit, 0)); !cmp.Equal(found, expected) {
+			t.Errorf("Search of %q have to find %v like in-memory search, got %v", q, expected, found)
+		}
+	}
+
+	results, err := e.s.SearchProducts(context.TODO(), "samsng", maxPageLimit, 0)
+	if err != nil || len(results) == 0 || !strings.Contains(results[0].Highlight.Name, "<b>Samsung</b>") {
+		t.Errorf("Found product have to be highlighted, got %+v %+v", results, err)
+	}
+
+	// teardown
+	for id := range ids {
+		if err := e.s.DeleteProductById(context.TODO(), id); err != nil {
+			panic(fmt.Sprintf("Cannot teardown TestProductSearch: %+v", err))
+		}
+	}
+	// end teardown
+}
+
 func TestCustomer(t *testing.T) {
 	e := GetEnvironment()
 	dtoAdd := CustomerDTOAdd{
@@ -205,7 +364,7 @@ func TestBill(t *testing.T) {
 		productOne, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
 			Name:        "Product One",
 			Description: "Description",
-			Price:       1000,
+			Price:       NewMoney(1000, "USD"),
 			Quantity:    10,
 		})
 		if err != nil {
@@ -214,7 +373,7 @@ func TestBill(t *testing.T) {
 		productTwo, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
 			Name:        "Product One",
 			Description: "Description",
-			Price:       1000,
+			Price:       NewMoney(1000, "USD"),
 			Quantity:    10,
 		})
 		if err != nil {
@@ -266,7 +425,7 @@ func TestBill(t *testing.T) {
 
 	// Get bills (checking whether created bill in bills slice)
 	var bills []Bill
-	bills, err = e.s.GetBills(context.TODO())
+	bills, _, err = e.s.GetBills(context.TODO(), BillsFilter{Customer: customerOne.Id, Limit: 50})
 	if err != nil {
 		t.Errorf("Error when fetching bills: %+v", err)
 	}
@@ -315,23 +474,48 @@ func TestBill(t *testing.T) {
 		t.Errorf("Created bill and updated bill are same:\n created: %+v\n updated: %+v", billVerbose, billVerboseCopy)
 	}
 
-	// Delete bill
+	// Duplicated products are merged
+	dtoUpdate.Products = []BillProduct{{Product: productTwo.Id, Quantity: 4}, {Product: productTwo.Id, Quantity: 6}}
+	if err := e.s.UpdateBillById(context.TODO(), dtoUpdate); err != nil {
+		t.Errorf("Error when updating bill with duplicated products: %+v", err)
+	}
+	lines, err := e.s.getBillLines(context.TODO(), e.s.db, bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill's lines: %+v", err)
+	}
+	if len(lines) != 1 || lines[0].Product != productTwo.Id || lines[0].Quantity != 10 {
+		t.Errorf("Duplicated products have to be merged into line of 10, got %+v", lines)
+	}
+
+	// Missing products are reported
+	missing := productTwo.Id + 1000000
+	dtoUpdate.Products = []BillProduct{{Product: productOne.Id, Quantity: 1}, {Product: missing, Quantity: 1}}
+	err = e.s.UpdateBillById(context.TODO(), dtoUpdate)
+	if apiErr, ok := err.(*ApiError); !ok || !strings.Contains(apiErr.Err, fmt.Sprintf("[%v]", missing)) {
+		t.Errorf("Updating bill with missing product have to report its id, got %+v", err)
+	}
+
+	// Delete bill, it's voided and keeps its number
 	err = e.s.DeleteBillById(context.TODO(), bill.Id)
 	if err != nil {
 		t.Errorf("Error when deleting bill: %+v", err)
 	}
 
-	var undesiredBillVerbose *BillVerbose
-	undesiredBillVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
-	if undesiredBillVerbose != nil {
-		t.Errorf("Get bill by id return not nil bill after deleting: %+v", undesiredBillVerbose)
-	}
+	voidedBill, err := e.s.GetBillById(context.TODO(), bill.Id)
 	if err != nil {
-		if _, ok := err.(*ApiError); !ok {
-			t.Errorf("Error when fetching bill: %+v", err)
-		}
-	} else {
-		t.Errorf("Get product must return not found ApiError")
+		t.Errorf("Error when fetching voided bill: %+v", err)
+	} else if voidedBill.Status != BillVoid || voidedBill.Number != bill.Number || len(voidedBill.Products) != 0 ||
+		voidedBill.Totals.Total.Amount != 0 {
+		t.Errorf("Deleted bill have to be void with its number and without products, got %+v", voidedBill)
+	}
+	if err := e.s.AddProductToBill(context.TODO(), BillDtoAddProduct{
+		Id:          bill.Id,
+		BillProduct: BillProduct{Product: productOne.Id, Quantity: 1},
+	}); err == nil {
+		t.Errorf("Adding product to voided bill must return ApiError")
+	}
+	if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+		t.Errorf("Deleting voided bill again have to succeed, got %+v", err)
 	}
 
 	// teardown
@@ -361,6 +545,281 @@ func TestBill(t *testing.T) {
 	// end teardown
 }
 
+func TestExport(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		productOne, productTwo *Product
+		customer               *Customer
+		bill                   *Bill
+	)
+	err := func() error {
+		var err error
+		for _, product := range []**Product{&productOne, &productTwo} {
+			*product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+				Name:        "Export Product",
+				Description: "Description",
+				Price:       NewMoney(250, "USD"),
+				Quantity:    10,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{FirstName: "Export", LastName: "Customer"})
+		if err != nil {
+			return err
+		}
+		bill, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+			Customer: customer.Id,
+			Products: []BillProduct{{Product: productOne.Id, Quantity: 2}, {Product: productTwo.Id, Quantity: 1}},
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestExport: %+v", err))
+	}
+	// end setup
+
+	buf := &strings.Builder{}
+	err = e.s.ExportBills(context.TODO(), buf, ExportOptions{Format: ExportCSV, Columns: []string{"number", "customer_name", "total", "line_product", "line_quantity"}},
+		BillsFilter{Customer: customer.Id})
+	if err != nil {
+		t.Errorf("Error when exporting bills: %+v", err)
+	}
+	expected := fmt.Sprintf("number,customer_name,total,line_product,line_quantity\n%[1]v,Export Customer,7.50,%[2]v,2\n%[1]v,Export Customer,7.50,%[3]v,1\n",
+		bill.Number, productOne.Id, productTwo.Id)
+	if buf.String() != expected {
+		t.Errorf("Invalid export of bills:\n have to be %q\n got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := e.s.ExportCustomers(context.TODO(), buf, ExportOptions{Format: ExportNDJSON, Columns: []string{"id", "last_name"}}, "Export Customer"); err != nil {
+		t.Errorf("Error when exporting customers: %+v", err)
+	}
+	if !strings.Contains(buf.String(), fmt.Sprintf(`{"id":%v,"last_name":"Customer"}`, customer.Id)) {
+		t.Errorf("Found customer have to be exported, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := e.s.ExportProducts(context.TODO(), buf, ExportOptions{Format: ExportCSV, Columns: []string{"id", "price"}}); err != nil {
+		t.Errorf("Error when exporting products: %+v", err)
+	}
+	if !strings.Contains(buf.String(), fmt.Sprintf("\n%v,2.50\n", productOne.Id)) {
+		t.Errorf("Product have to be exported, got %q", buf.String())
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		for _, product := range []*Product{productOne, productTwo} {
+			if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+				return err
+			}
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestExport: %+v", err))
+	}
+	// end teardown
+}
+
+func TestReport(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		bills    []*Bill
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Report Product",
+			Description: "Description",
+			Price:       NewMoney(250, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{FirstName: "Report", LastName: "Customer"})
+		if err != nil {
+			return err
+		}
+		for _, quantity := range []int{4, 1} {
+			bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+				Customer: customer.Id,
+				Products: []BillProduct{{Product: product.Id, Quantity: quantity}},
+			})
+			if err != nil {
+				return err
+			}
+			bills = append(bills, bill)
+		}
+		return nil
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestReport: %+v", err))
+	}
+	// end setup
+
+	// Only bills of the test are in range
+	to := bills[1].CreatedAt.Add(time.Millisecond)
+	filter := ReportFilter{From: &bills[0].CreatedAt, To: &to, Limit: 10}
+
+	sales, err := e.s.GetSalesReport(context.TODO(), filter, ReportMonth)
+	if err != nil {
+		t.Errorf("Error when fetching sales report: %+v", err)
+	}
+	expectedSales := []SalesReportRow{{
+		Period:   bills[0].CreatedAt.Format("2006-01") + "-01",
+		Bills:    2,
+		Quantity: 5,
+		Revenue:  NewMoney(1250, "USD"),
+	}}
+	if !cmp.Equal(sales, expectedSales) {
+		t.Errorf("Invalid sales report: have to be %+v, got %+v", expectedSales, sales)
+	}
+	if _, err := e.s.GetSalesReport(context.TODO(), filter, "year"); err == nil {
+		t.Errorf("Unknown grouping have to be rejected")
+	}
+
+	products, err := e.s.GetTopProductsReport(context.TODO(), filter)
+	if err != nil {
+		t.Errorf("Error when fetching top products report: %+v", err)
+	}
+	expectedProducts := []TopProductReportRow{{Product: product.Id, Name: product.Name, Bills: 2, Quantity: 5, Revenue: NewMoney(1250, "USD")}}
+	if !cmp.Equal(products, expectedProducts) {
+		t.Errorf("Invalid top products report: have to be %+v, got %+v", expectedProducts, products)
+	}
+
+	customers, err := e.s.GetTopCustomersReport(context.TODO(), filter)
+	if err != nil {
+		t.Errorf("Error when fetching top customers report: %+v", err)
+	}
+	expectedCustomers := []TopCustomerReportRow{{Customer: customer.Id, FirstName: "Report", LastName: "Customer", Bills: 2, Revenue: NewMoney(1250, "USD")}}
+	if !cmp.Equal(customers, expectedCustomers) {
+		t.Errorf("Invalid top customers report: have to be %+v, got %+v", expectedCustomers, customers)
+	}
+
+	// teardown
+	err = func() error {
+		for _, bill := range bills {
+			if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+				return err
+			}
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestReport: %+v", err))
+	}
+	// end teardown
+}
+
+func TestRelatedProducts(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		products []*Product
+		customer *Customer
+		bills    []*Bill
+	)
+	err := func() error {
+		for i := 0; i < 3; i++ {
+			product, err := e.s.AddProduct(context.TODO(), ProductDTOAdd{
+				Name:        fmt.Sprintf("Related Product %v", i),
+				Description: "Description",
+				Price:       NewMoney(100, "USD"),
+				Quantity:    10,
+			})
+			if err != nil {
+				return err
+			}
+			products = append(products, product)
+		}
+		var err error
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{FirstName: "Related", LastName: "Customer"})
+		if err != nil {
+			return err
+		}
+		// the first product is bought twice with the second one and once with the third one
+		for _, other := range []*Product{products[1], products[1], products[2]} {
+			bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+				Customer: customer.Id,
+				Products: []BillProduct{{Product: products[0].Id, Quantity: 1}, {Product: other.Id, Quantity: 1}},
+			})
+			if err != nil {
+				return err
+			}
+			bills = append(bills, bill)
+		}
+		return nil
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestRelatedProducts: %+v", err))
+	}
+	// end setup
+
+	if err := e.s.RefreshProductAssociations(context.TODO(), 0); err != nil {
+		t.Errorf("Error when refreshing product associations: %+v", err)
+	}
+	related, err := e.s.GetRelatedProducts(context.TODO(), products[0].Id, RelatedSortConfidence, 10)
+	if err != nil {
+		t.Errorf("Error when fetching related products: %+v", err)
+	}
+	if len(related) != 2 || related[0].Id != products[1].Id || related[0].Bills != 2 || related[1].Id != products[2].Id ||
+		math.Abs(related[0].Confidence-2.0/3) > 1e-9 || math.Abs(related[0].Support-2*related[1].Support) > 1e-9 {
+		t.Errorf("Invalid related products: %+v", related)
+	}
+	if back, err := e.s.GetRelatedProducts(context.TODO(), products[2].Id, RelatedSortLift, 10); err != nil || len(back) != 1 ||
+		back[0].Id != products[0].Id || back[0].Confidence != 1 || math.Abs(back[0].Lift-related[1].Lift) > 1e-9 {
+		t.Errorf("Invalid related products of the third product: %+v %+v", back, err)
+	}
+
+	// Pairs bought too rarely are dropped
+	if err := e.s.RefreshProductAssociations(context.TODO(), (related[0].Support+related[1].Support)/2); err != nil {
+		t.Errorf("Error when refreshing product associations: %+v", err)
+	}
+	related, err = e.s.GetRelatedProducts(context.TODO(), products[0].Id, RelatedSortSupport, 10)
+	if err != nil || len(related) != 1 || related[0].Id != products[1].Id {
+		t.Errorf("Related products below minimal support have to be dropped: %+v %+v", related, err)
+	}
+
+	if _, err := e.s.GetRelatedProducts(context.TODO(), products[0].Id, "price", 10); err == nil {
+		t.Errorf("Unknown sort have to be rejected")
+	}
+
+	// teardown
+	err = func() error {
+		for _, bill := range bills {
+			if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+				return err
+			}
+		}
+		for _, product := range products {
+			if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+				return err
+			}
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestRelatedProducts: %+v", err))
+	}
+	// end teardown
+}
+
 func TestBillProduct(t *testing.T) {
 	e := GetEnvironment()
 
@@ -422,6 +881,27 @@ func TestBillProduct(t *testing.T) {
 		t.Errorf("Error when add product to bill: %+v", err)
 	}
 
+	delta := -2
+	line, err := e.s.UpdateBillProduct(context.TODO(), BillDTOUpdateProduct{Bill: bill.Id, Product: products[1].Id, Delta: &delta})
+	if err != nil {
+		t.Errorf("Error when updating bill's product: %+v", err)
+	} else if line.Quantity != 3 || line.Price != products[1].Price || line.Total.Amount != 3*products[1].Price.Amount {
+		t.Errorf("Invalid updated line: have to be 3 of %v, got %+v", products[1].Price, line)
+	}
+
+	for _, dto := range []BillDTOUpdateProduct{
+		{Bill: bill.Id, Product: products[1].Id},
+		{Bill: bill.Id, Product: products[1].Id, Delta: &delta, Quantity: &delta},
+		{Bill: bill.Id, Product: products[1].Id, Quantity: &[]int{products[1].Quantity + 4}[0]},
+		{Bill: bill.Id, Product: products[1].Id, Delta: &[]int{-3}[0]},
+	} {
+		if _, err := e.s.UpdateBillProduct(context.TODO(), dto); err == nil {
+			t.Errorf("Updating bill's product by %+v have to fail", dto)
+		} else if _, ok := err.(*ApiError); !ok {
+			t.Errorf("Invalid update of bill's product must return ApiError, got %+v", err)
+		}
+	}
+
 	err = e.s.DeleteProductFromBill(context.TODO(), bill.Id, products[1].Id)
 	if err != nil {
 		t.Errorf("Error when deleting product to bill: %+v", err)
@@ -433,3 +913,1676 @@ func TestBillProduct(t *testing.T) {
 	}
 	// end teardown
 }
+
+func TestBillStock(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Stock Product",
+			Description: "Description",
+			Price:       NewMoney(100, "USD"),
+			Quantity:    5,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{FirstName: "Stock", LastName: "Customer"})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestBillStock: %+v", err))
+	}
+	// end setup
+
+	checkStock := func(step string, expected int) {
+		fetched, err := e.s.GetProductById(context.TODO(), product.Id)
+		if err != nil {
+			t.Errorf("Error when fetching product: %+v", err)
+		} else if fetched.Quantity != expected {
+			t.Errorf("Invalid stock after %v: have to be %v, got %v", step, expected, fetched.Quantity)
+		}
+	}
+
+	bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 3}},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Cannot add bill in TestBillStock: %+v", err))
+	}
+	checkStock("adding bill", 2)
+
+	// Stock taken by one bill isn't available to another one
+	if _, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 3}},
+	}); err == nil {
+		t.Errorf("Bill over product's stock have to fail")
+	} else if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Bill over product's stock must return ApiError, got %+v", err)
+	}
+	other, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 2}},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Cannot add bill in TestBillStock: %+v", err))
+	}
+	checkStock("adding the second bill", 0)
+
+	delta := 2
+	if _, err := e.s.UpdateBillProduct(context.TODO(), BillDTOUpdateProduct{Bill: bill.Id, Product: product.Id, Delta: &delta}); err == nil {
+		t.Errorf("Increasing bill's line over stock have to fail")
+	}
+	if err := e.s.DeleteProductFromBill(context.TODO(), other.Id, product.Id); err != nil {
+		t.Errorf("Error when deleting product from bill: %+v", err)
+	}
+	checkStock("deleting product from bill", 2)
+	if _, err := e.s.UpdateBillProduct(context.TODO(), BillDTOUpdateProduct{Bill: bill.Id, Product: product.Id, Delta: &delta}); err != nil {
+		t.Errorf("Error when increasing bill's line: %+v", err)
+	}
+	checkStock("increasing line", 0)
+	quantity := 1
+	if _, err := e.s.UpdateBillProduct(context.TODO(), BillDTOUpdateProduct{Bill: bill.Id, Product: product.Id, Quantity: &quantity}); err != nil {
+		t.Errorf("Error when decreasing bill's line: %+v", err)
+	}
+	checkStock("decreasing line", 4)
+
+	if err := e.s.AddProductToBill(context.TODO(), BillDtoAddProduct{
+		Id:          other.Id,
+		BillProduct: BillProduct{Product: product.Id, Quantity: 5},
+	}); err == nil {
+		t.Errorf("Adding product over its stock to bill have to fail")
+	}
+	if err := e.s.AddProductToBill(context.TODO(), BillDtoAddProduct{
+		Id:          other.Id,
+		BillProduct: BillProduct{Product: product.Id, Quantity: 4},
+	}); err != nil {
+		t.Errorf("Error when adding product to bill: %+v", err)
+	}
+	checkStock("adding product to bill", 0)
+	if err := e.s.DeleteBillById(context.TODO(), other.Id); err != nil {
+		t.Errorf("Error when deleting bill: %+v", err)
+	}
+	checkStock("deleting bill", 4)
+
+	// Replaced lines return their stock before new ones take it
+	if err := e.s.UpdateBillById(context.TODO(), BillDTOUpdate{
+		Id:       bill.Id,
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 5}},
+	}); err != nil {
+		t.Errorf("Error when replacing bill's products: %+v", err)
+	}
+	checkStock("replacing products", 0)
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		if _, err := e.s.db.ExecContext(context.TODO(), "DELETE FROM product WHERE id = $1", product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestBillStock: %+v", err))
+	}
+	// end teardown
+}
+
+func TestLowStock(t *testing.T) {
+	e := GetEnvironment()
+
+	product, err := e.s.AddProduct(context.TODO(), ProductDTOAdd{
+		Name:            "Low Stock Product",
+		Description:     "Description",
+		Price:           NewMoney(1000, "USD"),
+		Quantity:        3,
+		ReorderPoint:    5,
+		ReorderQuantity: 20,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestLowStock: %+v", err))
+	}
+
+	products, err := e.s.GetLowStockProducts(context.TODO())
+	if err != nil {
+		t.Errorf("Error when fetching low stock products: %+v", err)
+	}
+	isHaveInProducts := false
+	for _, p := range products {
+		if p.Id == product.Id {
+			isHaveInProducts = true
+		}
+	}
+	if !isHaveInProducts {
+		t.Errorf("Product below reorder point are not in low stock products")
+	}
+
+	// Product is reported only once until it is restocked
+	notifier := NewChanNotifier(len(products) + 1)
+	checker := NewLowStockChecker(&e.s, notifier, time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := checker.Check(context.TODO()); err != nil {
+			t.Errorf("Error when checking low stock: %+v", err)
+		}
+	}
+	close(notifier.C)
+
+	alerts := 0
+	for alert := range notifier.C {
+		if alert.Product.Id == product.Id {
+			alerts++
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("Invalid low stock alerts count: have to be 1, got %d", alerts)
+	}
+
+	// teardown
+	if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestLowStock: %+v", err))
+	}
+	// end teardown
+}
+
+func TestPurchaseOrder(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		supplier *Supplier
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:            "Restocked Product",
+			Description:     "Description",
+			Price:           NewMoney(1000, "USD"),
+			Quantity:        1,
+			ReorderPoint:    5,
+			ReorderQuantity: 10,
+		})
+		if err != nil {
+			return err
+		}
+		supplier, err = e.s.AddSupplier(context.TODO(), SupplierDTOAdd{Name: "Test Supplier"})
+		if err != nil {
+			return err
+		}
+		return e.s.SetSupplierProduct(context.TODO(), SupplierDTOSetProduct{
+			Id:              supplier.Id,
+			SupplierProduct: SupplierProduct{Product: product.Id, Price: NewMoney(700, "USD")},
+		})
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestPurchaseOrder: %+v", err))
+	}
+	// end setup
+
+	// Reorder suggestion prefills purchase order from price list
+	suggestion, err := e.s.GetReorderSuggestion(context.TODO(), supplier.Id)
+	if err != nil {
+		t.Errorf("Error when fetching reorder suggestion: %+v", err)
+	}
+	if len(suggestion.Lines) != 1 || suggestion.Lines[0].Quantity != 10 || suggestion.Lines[0].Price != NewMoney(700, "USD") {
+		t.Errorf("Invalid reorder suggestion: %+v", suggestion)
+	}
+
+	order, err := e.s.AddPurchaseOrder(context.TODO(), *suggestion)
+	if err != nil {
+		t.Errorf("Error when adding purchase order: %+v", err)
+	}
+	if order.Status != PurchaseOrderDraft {
+		t.Errorf("Invalid status of created purchase order: %v", order.Status)
+	}
+
+	// Draft purchase order cannot be received
+	if _, err := e.s.ReceivePurchaseOrder(context.TODO(), PurchaseOrderDTOReceive{
+		Id:    order.Id,
+		Lines: []PurchaseOrderLineReceive{{Product: product.Id, Quantity: 4}},
+	}); err == nil {
+		t.Errorf("Draft purchase order have to be not receivable")
+	}
+
+	if err := e.s.OrderPurchaseOrder(context.TODO(), order.Id); err != nil {
+		t.Errorf("Error when ordering purchase order: %+v", err)
+	}
+
+	order, err = e.s.ReceivePurchaseOrder(context.TODO(), PurchaseOrderDTOReceive{
+		Id:    order.Id,
+		Lines: []PurchaseOrderLineReceive{{Product: product.Id, Quantity: 4}},
+	})
+	if err != nil {
+		t.Errorf("Error when receiving purchase order: %+v", err)
+	}
+	if order.Status != PurchaseOrderPartiallyReceived {
+		t.Errorf("Invalid status of partially received purchase order: %v", order.Status)
+	}
+
+	// Received quantity cannot exceed ordered one
+	if _, err := e.s.ReceivePurchaseOrder(context.TODO(), PurchaseOrderDTOReceive{
+		Id:    order.Id,
+		Lines: []PurchaseOrderLineReceive{{Product: product.Id, Quantity: 7}},
+	}); err == nil {
+		t.Errorf("Receiving more than ordered have to fail")
+	}
+
+	order, err = e.s.ReceivePurchaseOrder(context.TODO(), PurchaseOrderDTOReceive{
+		Id:    order.Id,
+		Lines: []PurchaseOrderLineReceive{{Product: product.Id, Quantity: 6}},
+	})
+	if err != nil {
+		t.Errorf("Error when receiving purchase order: %+v", err)
+	}
+	if order.Status != PurchaseOrderReceived {
+		t.Errorf("Invalid status of received purchase order: %v", order.Status)
+	}
+
+	restocked, err := e.s.GetProductById(context.TODO(), product.Id)
+	if err != nil {
+		t.Errorf("Error when fetching product: %+v", err)
+	}
+	if restocked.Quantity != 11 {
+		t.Errorf("Invalid product quantity after receiving: have to be 11, got %d", restocked.Quantity)
+	}
+
+	if err := e.s.CancelPurchaseOrder(context.TODO(), order.Id); err == nil {
+		t.Errorf("Received purchase order have to be not cancellable")
+	}
+
+	// teardown
+	err = func() error {
+		if _, err := e.s.db.ExecContext(context.TODO(), "DELETE FROM purchaseorder WHERE id = $1", order.Id); err != nil {
+			return err
+		}
+		if err := e.s.DeleteSupplierById(context.TODO(), supplier.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteProductById(context.TODO(), product.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestPurchaseOrder: %+v", err))
+	}
+	// end teardown
+}
+
+func TestCustomerContacts(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		customer *Customer
+		products []Product
+	)
+	err := func() error {
+		var err error
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Contact",
+			LastName:  "Customer",
+			Email:     "Contact.Customer@Example.com",
+			Phone:     "+15550009999",
+		})
+		if err != nil {
+			return err
+		}
+		products, err = e.s.GetProducts(context.TODO())
+		if len(products) < 1 {
+			return fmt.Errorf("Count of products have to be at least 1")
+		}
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestCustomerContacts: %+v", err))
+	}
+	// end setup
+
+	// Email is unique case-insensitively
+	_, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+		FirstName: "Contact",
+		LastName:  "Duplicate",
+		Email:     "contact.customer@example.com",
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Adding customer with duplicated email must return ApiError, got %+v", err)
+	}
+
+	address, err := e.s.AddCustomerAddress(context.TODO(), CustomerAddressDTOAdd{
+		Customer: customer.Id,
+		Type:     AddressBilling,
+		Address: Address{
+			Line1:      "1 Test Street",
+			City:       "Testville",
+			PostalCode: "00001",
+			Country:    "US",
+		},
+	})
+	if err != nil {
+		t.Errorf("Error when adding customer's address: %+v", err)
+	}
+
+	// Billing address cannot be used as shipping one
+	_, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer:        customer.Id,
+		Products:        []BillProduct{{Product: products[0].Id, Quantity: 1}},
+		ShippingAddress: address.Id,
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Adding bill with address of wrong type must return ApiError, got %+v", err)
+	}
+
+	bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer:       customer.Id,
+		Products:       []BillProduct{{Product: products[0].Id, Quantity: 1}},
+		BillingAddress: address.Id,
+	})
+	if err != nil {
+		t.Errorf("Error when adding bill: %+v", err)
+	}
+
+	// Bill keeps address as it was at the time of its creation
+	err = e.s.UpdateCustomerAddressById(context.TODO(), CustomerAddressDTOUpdate{
+		Id:       address.Id,
+		Customer: customer.Id,
+		Type:     AddressBilling,
+		Address: Address{
+			Line1:      "2 Moved Street",
+			City:       "Testville",
+			PostalCode: "00002",
+			Country:    "US",
+		},
+	})
+	if err != nil {
+		t.Errorf("Error when updating customer's address: %+v", err)
+	}
+
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.BillingAddress == nil || billVerbose.BillingAddress.Line1 != "1 Test Street" {
+		t.Errorf("Invalid billing address snapshot: %+v", billVerbose.BillingAddress)
+	}
+	if billVerbose.Customer.Email != customer.Email {
+		t.Errorf("Invalid bill's customer email: %v", billVerbose.Customer.Email)
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestCustomerContacts: %+v", err))
+	}
+	// end teardown
+}
+
+func TestCustomerHistory(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		customer *Customer
+		product  *Product
+		bill     *Bill
+	)
+	err := func() error {
+		var err error
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Historical",
+			LastName:  "Shopper",
+			Email:     "historical.shopper@example.com",
+			Phone:     "+15550007777",
+		})
+		if err != nil {
+			return err
+		}
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "History Product",
+			Description: "Description",
+			Price:       NewMoney(250, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		bill, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+			Customer: customer.Id,
+			Products: []BillProduct{{Product: product.Id, Quantity: 4}},
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestCustomerHistory: %+v", err))
+	}
+	// end setup
+
+	for _, q := range []string{"Historical Shopper", "historical.shopper@", "555 000 7777"} {
+		customers, err := e.s.SearchCustomers(context.TODO(), q)
+		if err != nil {
+			t.Errorf("Error when searching customers by %q: %+v", q, err)
+		}
+		if len(customers) == 0 || customers[0].Id != customer.Id {
+			t.Errorf("Customer isn't found first by %q: %+v", q, customers)
+		}
+	}
+
+	bills, err := e.s.GetCustomerBills(context.TODO(), CustomerBillsFilter{Customer: customer.Id, Limit: 10})
+	if err != nil {
+		t.Errorf("Error when fetching customer's bills: %+v", err)
+	}
+	if len(bills) != 1 || bills[0].Id != bill.Id {
+		t.Errorf("Invalid customer's bills: %+v", bills)
+	}
+
+	future := bill.CreatedAt.Add(time.Hour)
+	bills, err = e.s.GetCustomerBills(context.TODO(), CustomerBillsFilter{Customer: customer.Id, From: &future, Limit: 10})
+	if err != nil {
+		t.Errorf("Error when fetching customer's bills: %+v", err)
+	}
+	if len(bills) != 0 {
+		t.Errorf("Bills created before from date have to be excluded: %+v", bills)
+	}
+
+	summary, err := e.s.GetCustomerSummary(context.TODO(), customer.Id)
+	if err != nil {
+		t.Errorf("Error when fetching customer's summary: %+v", err)
+	}
+	if summary.BillCount != 1 || !cmp.Equal(summary.TotalSpend, []Money{NewMoney(1000, "USD")}) || len(summary.TopProducts) != 1 {
+		t.Errorf("Invalid customer's summary: %+v", summary)
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestCustomerHistory: %+v", err))
+	}
+	// end teardown
+}
+
+func TestCustomerErasure(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		customer *Customer
+		products []Product
+		bill     *Bill
+	)
+	err := func() error {
+		var err error
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Forgotten",
+			LastName:  "Customer",
+			Email:     "forgotten.customer@example.com",
+		})
+		if err != nil {
+			return err
+		}
+		products, err = e.s.GetProducts(context.TODO())
+		if len(products) < 1 {
+			return fmt.Errorf("Count of products have to be at least 1")
+		}
+		bill, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+			Customer: customer.Id,
+			Products: []BillProduct{{Product: products[0].Id, Quantity: 1}},
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestCustomerErasure: %+v", err))
+	}
+	// end setup
+
+	export, err := e.s.ExportCustomer(context.TODO(), customer.Id)
+	if err != nil {
+		t.Errorf("Error when exporting customer: %+v", err)
+	}
+	if export.Customer.Email != customer.Email || len(export.Bills) != 1 {
+		t.Errorf("Invalid customer's export: %+v", export)
+	}
+
+	// Customer with bills is soft deleted instead of failing on foreign key
+	if err := e.s.DeleteCustomerById(context.TODO(), customer.Id); err != nil {
+		t.Errorf("Error when deleting customer with bills: %+v", err)
+	}
+	if _, err := e.s.GetCustomerById(context.TODO(), customer.Id); err == nil {
+		t.Errorf("Deleted customer have to be not found")
+	}
+
+	if err := e.s.EraseCustomer(context.TODO(), customer.Id); err != nil {
+		t.Errorf("Error when erasing customer: %+v", err)
+	}
+
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill of erased customer: %+v", err)
+	}
+	if billVerbose.Customer.Email != "" || billVerbose.Customer.FirstName == customer.FirstName {
+		t.Errorf("Personal data remains after erasure: %+v", billVerbose.Customer)
+	}
+	if len(billVerbose.Products) != 1 {
+		t.Errorf("Bill's products have to be kept after erasure: %+v", billVerbose.Products)
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestCustomerErasure: %+v", err))
+	}
+	// end teardown
+}
+
+func TestCoupon(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		coupon   *Coupon
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Coupon Product",
+			Description: "Description",
+			Price:       NewMoney(1000, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Coupon",
+			LastName:  "Customer",
+		})
+		if err != nil {
+			return err
+		}
+		coupon, err = e.s.AddCoupon(context.TODO(), CouponDTOAdd{
+			Code:                  "TEST-COUPON-20",
+			Discount:              Discount{Type: DiscountPercent, Value: 20},
+			UsageLimitPerCustomer: 1,
+			Currency:              "USD",
+			MinBillTotal:          1500,
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestCoupon: %+v", err))
+	}
+	// end setup
+
+	// Bill total is below coupon's minimum
+	_, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 1}},
+		Coupon:   coupon.Code,
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Adding bill below coupon's minimal total must return ApiError, got %+v", err)
+	}
+
+	bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 2, Discount: &Discount{Type: DiscountFixed, Value: 100}}},
+		Discount: &Discount{Type: DiscountPercent, Value: 10},
+		Coupon:   "test-coupon-20",
+	})
+	if err != nil {
+		t.Errorf("Error when adding bill with coupon: %+v", err)
+	}
+
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	totals := billVerbose.Totals
+	if totals.Subtotal.Amount != 2000 || totals.LineDiscount.Amount != 100 || totals.BillDiscount.Amount != 190 ||
+		totals.CouponDiscount.Amount != 342 || billVerbose.Coupon != coupon.Code {
+		t.Errorf("Invalid bill totals: %+v", totals)
+	}
+
+	// Bill which no longer qualifies for coupon can still be changed, coupon's discount comes back when it qualifies
+	billCouponDiscount := func() int64 {
+		billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+		if err != nil {
+			t.Errorf("Error when fetching bill: %+v", err)
+			return -1
+		}
+		return billVerbose.Totals.CouponDiscount.Amount
+	}
+	one, two := 1, 2
+	if _, err := e.s.UpdateBillProduct(context.TODO(), BillDTOUpdateProduct{Bill: bill.Id, Product: product.Id, Quantity: &one}); err != nil {
+		t.Errorf("Error when reducing bill's product below coupon's minimal total: %+v", err)
+	}
+	if discount := billCouponDiscount(); discount != 0 {
+		t.Errorf("Bill below coupon's minimal total have to have no coupon discount, got %v", discount)
+	}
+	if _, err := e.s.UpdateBillProduct(context.TODO(), BillDTOUpdateProduct{Bill: bill.Id, Product: product.Id, Quantity: &two}); err != nil {
+		t.Errorf("Error when increasing bill's product: %+v", err)
+	}
+	if discount := billCouponDiscount(); discount != 342 {
+		t.Errorf("Coupon discount have to come back, got %v", discount)
+	}
+	if err := e.s.DeleteProductFromBill(context.TODO(), bill.Id, product.Id); err != nil {
+		t.Errorf("Error when removing the only eligible product of bill with coupon: %+v", err)
+	}
+	if discount := billCouponDiscount(); discount != 0 {
+		t.Errorf("Bill without eligible products have to have no coupon discount, got %v", discount)
+	}
+
+	// Coupon can be used by customer only once
+	_, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 2}},
+		Coupon:   coupon.Code,
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Exceeding coupon's usage limit per customer must return ApiError, got %+v", err)
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		if err := e.s.DeleteCouponById(context.TODO(), coupon.Id); err != nil {
+			return err
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestCoupon: %+v", err))
+	}
+	// end teardown
+}
+
+func TestExchangeRate(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		rate     *ExchangeRate
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Swiss Product",
+			Description: "Description",
+			Price:       NewMoney(1000, "CHF"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Exchange",
+			LastName:  "Customer",
+		})
+		if err != nil {
+			return err
+		}
+		rate, err = e.s.AddExchangeRate(context.TODO(), ExchangeRateDTOAdd{
+			Base:          "CHF",
+			Quote:         "NOK",
+			Rate:          "11.5",
+			EffectiveFrom: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestExchangeRate: %+v", err))
+	}
+	// end setup
+
+	if rate.Rate != "11.5" {
+		t.Errorf("Invalid added exchange rate: %+v", rate)
+	}
+
+	bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 2}},
+		Currency: "NOK",
+	})
+	if err != nil {
+		t.Errorf("Error when adding bill in another currency: %+v", err)
+	}
+
+	// Newer rate doesn't change totals of existing bill
+	imported, err := e.s.ImportExchangeRates(context.TODO(), strings.NewReader(
+		"base,quote,rate,effective_from\nCHF,NOK,12,"+time.Now().Format(time.RFC3339)+"\n",
+	))
+	if err != nil || imported.Imported != 1 {
+		t.Errorf("Error when importing exchange rates: %+v (%+v)", err, imported)
+	}
+
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.Currency != "NOK" || billVerbose.ExchangeRate == nil ||
+		billVerbose.ExchangeRate.From != "CHF" || billVerbose.ExchangeRate.Rate != "11.5" {
+		t.Errorf("Invalid bill's exchange rate snapshot: %+v", billVerbose.ExchangeRate)
+	}
+	if billVerbose.Products[0].Price != NewMoney(11500, "NOK") || billVerbose.Totals.Subtotal != NewMoney(23000, "NOK") {
+		t.Errorf("Invalid converted bill: %+v %+v", billVerbose.Products, billVerbose.Totals)
+	}
+
+	// Bill cannot be created without exchange rate
+	_, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 1}},
+		Currency: "KRW",
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Adding bill in currency without exchange rate must return ApiError, got %+v", err)
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		rates, err := e.s.GetExchangeRates(context.TODO(), "CHF", "NOK")
+		if err != nil {
+			return err
+		}
+		for _, r := range rates {
+			if err := e.s.DeleteExchangeRateById(context.TODO(), r.Id); err != nil {
+				return err
+			}
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestExchangeRate: %+v", err))
+	}
+	// end teardown
+}
+
+func TestPayment(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		bill     *Bill
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Payment Product",
+			Description: "Description",
+			Price:       NewMoney(1000, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Payment",
+			LastName:  "Customer",
+		})
+		if err != nil {
+			return err
+		}
+		bill, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+			Customer: customer.Id,
+			Products: []BillProduct{{Product: product.Id, Quantity: 2}},
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestPayment: %+v", err))
+	}
+	// end setup
+
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	total := billVerbose.Totals.Total
+
+	first, err := e.s.AddPayment(context.TODO(), PaymentDTOAdd{
+		Bill:      bill.Id,
+		Amount:    NewMoney(500, total.Currency),
+		Method:    PaymentCash,
+		Reference: "receipt 1",
+	})
+	if err != nil {
+		t.Errorf("Error when adding payment: %+v", err)
+	}
+
+	billVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.Status != BillPartiallyPaid || billVerbose.Balance.Amount != total.Amount-500 || len(billVerbose.Payments) != 1 {
+		t.Errorf("Invalid partially paid bill: status %v, balance %+v, payments %+v", billVerbose.Status, billVerbose.Balance, billVerbose.Payments)
+	}
+
+	// Partially paid bill cannot be changed
+	if err := e.s.DeleteProductFromBill(context.TODO(), bill.Id, product.Id); err == nil {
+		t.Errorf("Changing partially paid bill must return ApiError")
+	}
+
+	// Overpayment is rejected
+	_, err = e.s.AddPayment(context.TODO(), PaymentDTOAdd{
+		Bill:   bill.Id,
+		Amount: NewMoney(total.Amount, total.Currency),
+		Method: PaymentCard,
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Overpayment must return ApiError, got %+v", err)
+	}
+
+	_, err = e.s.AddPayment(context.TODO(), PaymentDTOAdd{
+		Bill:      bill.Id,
+		Amount:    NewMoney(total.Amount-500, total.Currency),
+		Method:    PaymentTransfer,
+		Reference: "TRX-42",
+	})
+	if err != nil {
+		t.Errorf("Error when adding payment: %+v", err)
+	}
+
+	billVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.Status != BillPaid || billVerbose.Balance.Amount != 0 {
+		t.Errorf("Fully paid bill have to be paid: status %v, balance %+v", billVerbose.Status, billVerbose.Balance)
+	}
+
+	// Deleting payment reopens bill
+	if err := e.s.DeletePayment(context.TODO(), bill.Id, first.Id); err != nil {
+		t.Errorf("Error when deleting payment: %+v", err)
+	}
+	billVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.Status != BillPartiallyPaid || billVerbose.Balance.Amount != 500 {
+		t.Errorf("Bill have to be partially paid after deleting payment: status %v, balance %+v", billVerbose.Status, billVerbose.Balance)
+	}
+
+	// teardown
+	err = func() error {
+		payments, err := e.s.GetBillPayments(context.TODO(), bill.Id)
+		if err != nil {
+			return err
+		}
+		for _, payment := range payments {
+			if err := e.s.DeletePayment(context.TODO(), bill.Id, payment.Id); err != nil {
+				return err
+			}
+		}
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestPayment: %+v", err))
+	}
+	// end teardown
+}
+
+func TestCreditNote(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		bill     *Bill
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Credit Note Product",
+			Description: "Description",
+			Price:       NewMoney(1000, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Credit",
+			LastName:  "Customer",
+		})
+		if err != nil {
+			return err
+		}
+		bill, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+			Customer: customer.Id,
+			Products: []BillProduct{{Product: product.Id, Quantity: 4}},
+		})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestCreditNote: %+v", err))
+	}
+	// end setup
+
+	// Open bill is changed instead of credited
+	_, err = e.s.AddCreditNote(context.TODO(), CreditNoteDTOAdd{
+		Bill:  bill.Id,
+		Lines: []CreditNoteLineDTO{{Product: product.Id, Quantity: 1}},
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Credit note of open bill must return ApiError, got %+v", err)
+	}
+
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	total := billVerbose.Totals.Total
+	if _, err := e.s.AddPayment(context.TODO(), PaymentDTOAdd{Bill: bill.Id, Amount: total, Method: PaymentCard}); err != nil {
+		t.Errorf("Error when adding payment: %+v", err)
+	}
+
+	note, err := e.s.AddCreditNote(context.TODO(), CreditNoteDTOAdd{
+		Bill:    bill.Id,
+		Lines:   []CreditNoteLineDTO{{Product: product.Id, Quantity: 1}},
+		Reason:  "damaged",
+		Restock: true,
+	})
+	if err != nil {
+		t.Errorf("Error when adding credit note: %+v", err)
+	}
+	if note.Amount.Amount != total.Amount/4 || len(note.Lines) != 1 {
+		t.Errorf("Invalid credit note: have to credit %v, got %+v", total.Amount/4, note)
+	}
+
+	fetched, err := e.s.GetProductById(context.TODO(), product.Id)
+	if err != nil {
+		t.Errorf("Error when fetching product: %+v", err)
+	}
+	// 4 of 10 are sold and 1 is returned
+	if fetched.Quantity != 7 {
+		t.Errorf("Returned product have to be restocked: quantity have to be 7, got %v", fetched.Quantity)
+	}
+
+	// Only 3 products are left to return
+	_, err = e.s.AddCreditNote(context.TODO(), CreditNoteDTOAdd{
+		Bill:  bill.Id,
+		Lines: []CreditNoteLineDTO{{Product: product.Id, Quantity: 4}},
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Returning more than sold must return ApiError, got %+v", err)
+	}
+
+	billVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.Credited.Amount != note.Amount.Amount || billVerbose.Balance.Amount != -note.Amount.Amount {
+		t.Errorf("Credited bill have to owe customer: credited %+v, balance %+v", billVerbose.Credited, billVerbose.Balance)
+	}
+
+	// Refund cannot exceed credited amount
+	_, err = e.s.AddRefund(context.TODO(), RefundDTOAdd{
+		CreditNote: note.Id,
+		Amount:     NewMoney(note.Amount.Amount+1, note.Amount.Currency),
+		Method:     PaymentCard,
+	})
+	if _, ok := err.(*ApiError); !ok {
+		t.Errorf("Over-refund must return ApiError, got %+v", err)
+	}
+
+	if _, err := e.s.AddRefund(context.TODO(), RefundDTOAdd{CreditNote: note.Id, Amount: note.Amount, Method: PaymentCard}); err != nil {
+		t.Errorf("Error when adding refund: %+v", err)
+	}
+
+	billVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil {
+		t.Errorf("Error when fetching bill: %+v", err)
+	}
+	if billVerbose.Status != BillPaid || billVerbose.Balance.Amount != 0 || billVerbose.Refunded.Amount != note.Amount.Amount {
+		t.Errorf("Refunded bill have to be settled: status %v, refunded %+v, balance %+v", billVerbose.Status, billVerbose.Refunded, billVerbose.Balance)
+	}
+
+	// teardown
+	err = func() error {
+		for _, query := range []string{
+			"DELETE FROM refund WHERE credit_note_id IN (SELECT id FROM creditnote WHERE bill_id = $1)",
+			"DELETE FROM creditnote WHERE bill_id = $1",
+			"DELETE FROM payment WHERE bill_id = $1",
+			"DELETE FROM bill WHERE id = $1",
+		} {
+			if _, err := e.s.db.ExecContext(context.TODO(), query, bill.Id); err != nil {
+				return err
+			}
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestCreditNote: %+v", err))
+	}
+	// end teardown
+}
+
+func TestBillNumber(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	customer, err := e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+		FirstName: "Numbered",
+		LastName:  "Customer",
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestBillNumber: %+v", err))
+	}
+	// end setup
+
+	// Concurrently created bills get distinct consecutive numbers
+	const count = 10
+	bills := make([]*Bill, count)
+	var wg sync.WaitGroup
+	for i := range bills {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{Customer: customer.Id, Products: []BillProduct{}})
+			if err != nil {
+				t.Errorf("Error when adding bill: %+v", err)
+				return
+			}
+			bills[i] = bill
+		}(i)
+	}
+	wg.Wait()
+
+	format, err := parseBillNumberFormat(e.s.config.Bill.NumberFormat, e.s.config.Bill.NumberTenant)
+	if err != nil {
+		t.Fatalf("Invalid bill number format: %+v", err)
+	}
+	numbers := map[string]bool{}
+	for _, bill := range bills {
+		if bill != nil {
+			numbers[bill.Number] = true
+		}
+	}
+	scope := format.Scope(bills[0].CreatedAt)
+	var last int
+	if err := e.s.db.GetContext(context.TODO(), &last, "SELECT value FROM billnumbercounter WHERE scope = $1", scope); err != nil {
+		t.Errorf("Error when fetching bill number counter: %+v", err)
+	}
+	for seq := last - count + 1; seq <= last; seq++ {
+		if number := format.Number(scope, seq); !numbers[number] {
+			t.Errorf("Bill number %v is skipped, got %v", number, numbers)
+		}
+	}
+
+	fetched, err := e.s.GetBillByNumber(context.TODO(), bills[0].Number)
+	if err != nil {
+		t.Errorf("Error when fetching bill by number: %+v", err)
+	} else if fetched.Id != bills[0].Id {
+		t.Errorf("Fetched by number %v bill have to be %v, got %v", bills[0].Number, bills[0].Id, fetched.Id)
+	}
+	if _, err := e.s.GetBillByNumber(context.TODO(), "NOT-A-NUMBER"); err == nil {
+		t.Errorf("Fetching bill by absent number must return ApiError")
+	}
+
+	// teardown
+	err = func() error {
+		for _, bill := range bills {
+			if bill == nil {
+				continue
+			}
+			if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+				return err
+			}
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestBillNumber: %+v", err))
+	}
+	// end teardown
+}
+
+func TestBillsFilter(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		bills    []*Bill
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Name:        "Filtered Product",
+			Description: "Description",
+			Price:       NewMoney(1000, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{
+			FirstName: "Filtered",
+			LastName:  "Customer",
+		})
+		if err != nil {
+			return err
+		}
+		for _, quantity := range []int{2, 1, 3} {
+			bill, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+				Customer: customer.Id,
+				Products: []BillProduct{{Product: product.Id, Quantity: quantity}},
+			})
+			if err != nil {
+				return err
+			}
+			bills = append(bills, bill)
+		}
+		return nil
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestBillsFilter: %+v", err))
+	}
+	// end setup
+
+	if bills[0].Total != NewMoney(2000, "USD") {
+		t.Errorf("Invalid total of added bill: %+v", bills[0].Total)
+	}
+
+	// Bills are paged by total
+	filter := BillsFilter{Customer: customer.Id, Sort: BillSortTotal, Limit: 2}
+	page, next, err := e.s.GetBills(context.TODO(), filter)
+	if err != nil {
+		t.Errorf("Error when fetching bills: %+v", err)
+	}
+	if len(page) != 2 || page[0].Id != bills[1].Id || page[1].Id != bills[0].Id || next == "" {
+		t.Errorf("Invalid first page of bills: %+v, cursor %q", page, next)
+	}
+
+	filter.Cursor = next
+	page, next, err = e.s.GetBills(context.TODO(), filter)
+	if err != nil {
+		t.Errorf("Error when fetching bills: %+v", err)
+	}
+	if len(page) != 1 || page[0].Id != bills[2].Id || next != "" {
+		t.Errorf("Invalid last page of bills: %+v, cursor %q", page, next)
+	}
+
+	// Cursor is bound to its sort
+	filter.Sort = "-" + BillSortTotal
+	if _, _, err := e.s.GetBills(context.TODO(), filter); err == nil {
+		t.Errorf("Cursor of another sort must return ApiError")
+	}
+
+	minTotal, maxTotal := int64(1500), int64(2500)
+	page, _, err = e.s.GetBills(context.TODO(), BillsFilter{Customer: customer.Id, Currency: "USD", MinTotal: &minTotal, MaxTotal: &maxTotal, Limit: 10})
+	if err != nil {
+		t.Errorf("Error when fetching bills: %+v", err)
+	}
+	if len(page) != 1 || page[0].Id != bills[0].Id {
+		t.Errorf("Invalid bills filtered by total: %+v", page)
+	}
+	if _, _, err := e.s.GetBills(context.TODO(), BillsFilter{Customer: customer.Id, MinTotal: &minTotal, Limit: 10}); err == nil {
+		t.Errorf("Filtering bills by total without currency have to fail")
+	}
+
+	page, _, err = e.s.GetBills(context.TODO(), BillsFilter{Customer: customer.Id, Status: BillPaid, Limit: 10})
+	if err != nil || len(page) != 0 {
+		t.Errorf("Invalid bills filtered by status: %+v (%v)", page, err)
+	}
+
+	// Expanded bills are the same as fetched one by one
+	verbose, _, err := e.s.GetBillsVerbose(context.TODO(), BillsFilter{Customer: customer.Id, Sort: BillSortTotal, Limit: 10},
+		BillExpand{Customer: true, Lines: true})
+	if err != nil {
+		t.Errorf("Error when fetching verbose bills: %+v", err)
+	}
+	for _, bill := range verbose {
+		expected, err := e.s.GetBillById(context.TODO(), bill.Id)
+		if err != nil {
+			t.Errorf("Error when fetching bill: %+v", err)
+		} else if !cmp.Equal(bill, *expected) {
+			t.Errorf("Expanded bill differs from fetched one: %v", cmp.Diff(*expected, bill))
+		}
+	}
+
+	// teardown
+	err = func() error {
+		for _, bill := range bills {
+			if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+				return err
+			}
+		}
+		if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestBillsFilter: %+v", err))
+	}
+	// end teardown
+}
+
+func TestCart(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		products []*Product
+		customer *Customer
+	)
+	err := func() error {
+		for i := 0; i < 2; i++ {
+			product, err := e.s.AddProduct(context.TODO(), ProductDTOAdd{
+				Name:        fmt.Sprintf("Cart Product %v", i),
+				Description: "Description",
+				Price:       NewMoney(100, "USD"),
+				Quantity:    3,
+			})
+			if err != nil {
+				return err
+			}
+			products = append(products, product)
+		}
+		var err error
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{FirstName: "Cart", LastName: "Customer"})
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestCart: %+v", err))
+	}
+	// end setup
+
+	cart, err := e.s.AddCart(context.TODO(), CartDTOAdd{Session: "cart-test-session"})
+	if err != nil || cart.Session != "cart-test-session" || cart.Customer != 0 || !cart.ExpiresAt.After(cart.CreatedAt) {
+		t.Errorf("Invalid added cart: %+v %+v", cart, err)
+	}
+	if _, err := e.s.AddCart(context.TODO(), CartDTOAdd{Session: "cart-test-session", Coupon: "NO-SUCH-COUPON"}); err == nil {
+		t.Errorf("Cart with unknown coupon have to be rejected")
+	}
+	if len(cart.Token) != 64 {
+		t.Errorf("Added cart have to have token, got %q", cart.Token)
+	}
+
+	// Adding the same product again increases its quantity
+	for _, dto := range []CartItemDTO{
+		{Cart: cart.Id, Product: products[0].Id, Quantity: 1},
+		{Cart: cart.Id, Product: products[0].Id, Quantity: 1},
+		{Cart: cart.Id, Product: products[1].Id, Quantity: 5},
+	} {
+		if err := e.s.AddCartItem(context.TODO(), dto); err != nil {
+			t.Errorf("Error when adding cart's item %+v: %+v", dto, err)
+		}
+	}
+	if err := e.s.AddCartItem(context.TODO(), CartItemDTO{Cart: cart.Id, Token: cart.Token, Product: -1, Quantity: 1}); err == nil {
+		t.Errorf("Not existing product have to be rejected")
+	}
+
+	// Cart is hidden without its token
+	if _, err := e.s.GetCartById(context.TODO(), cart.Id, ""); err == nil {
+		t.Errorf("Cart cannot be read without its token")
+	}
+	if err := e.s.AddCartItem(context.TODO(), CartItemDTO{Cart: cart.Id, Token: "wrong", Product: products[0].Id, Quantity: 1}); err == nil {
+		t.Errorf("Cart cannot be changed without its token")
+	}
+	if _, err := e.s.CheckoutCart(context.TODO(), CartCheckoutDTO{Cart: cart.Id, Token: "wrong", Customer: customer.Id}); err == nil {
+		t.Errorf("Cart cannot be checked out without its token")
+	}
+	if err := e.s.DeleteCartById(context.TODO(), cart.Id, "wrong"); err == nil {
+		t.Errorf("Cart cannot be deleted without its token")
+	}
+
+	preview, err := e.s.GetCartById(context.TODO(), cart.Id, cart.Token)
+	if err != nil || len(preview.Items) != 2 || preview.Items[0].Quantity != 2 || preview.Totals == nil ||
+		preview.Totals.Subtotal.Amount != 700 || len(preview.Problems) != 1 {
+		t.Errorf("Invalid cart preview: %+v %+v", preview, err)
+	}
+
+	// Checkout fails while stock is short and anonymous cart needs customer
+	if _, err := e.s.CheckoutCart(context.TODO(), CartCheckoutDTO{Cart: cart.Id, Token: cart.Token, Customer: customer.Id}); err == nil {
+		t.Errorf("Checkout of cart with stock shortage have to fail")
+	}
+	if err := e.s.UpdateCartItem(context.TODO(), CartItemDTO{Cart: cart.Id, Token: cart.Token, Product: products[1].Id, Quantity: 1}); err != nil {
+		t.Errorf("Error when updating cart's item: %+v", err)
+	}
+	if _, err := e.s.CheckoutCart(context.TODO(), CartCheckoutDTO{Cart: cart.Id, Token: cart.Token}); err == nil {
+		t.Errorf("Checkout of anonymous cart without customer have to fail")
+	}
+
+	bill, err := e.s.CheckoutCart(context.TODO(), CartCheckoutDTO{Cart: cart.Id, Token: cart.Token, Customer: customer.Id})
+	if err != nil || bill.Customer != customer.Id || bill.Total.Amount != 300 {
+		t.Errorf("Invalid bill checked out from cart: %+v %+v", bill, err)
+	}
+	if _, err := e.s.GetCartById(context.TODO(), cart.Id, cart.Token); err == nil {
+		t.Errorf("Checked out cart have to be deleted")
+	}
+	if stocked, err := e.s.GetProductById(context.TODO(), products[0].Id); err != nil || stocked.Quantity != 1 {
+		t.Errorf("Checkout have to take products from stock: %+v %+v", stocked, err)
+	}
+
+	// Expired carts are deleted by cleanup
+	expired, err := e.s.AddCart(context.TODO(), CartDTOAdd{Customer: customer.Id})
+	if err != nil {
+		t.Errorf("Error when adding cart: %+v", err)
+	}
+	if _, err := e.s.db.ExecContext(context.TODO(), "UPDATE cart SET expires_at = NOW() - INTERVAL '1 second' WHERE id = $1", expired.Id); err != nil {
+		t.Errorf("Error when expiring cart: %+v", err)
+	}
+	if _, err := e.s.GetCartById(context.TODO(), expired.Id, expired.Token); err == nil {
+		t.Errorf("Expired cart have to be hidden")
+	}
+	if deleted, err := e.s.DeleteExpiredCarts(context.TODO()); err != nil || deleted < 1 {
+		t.Errorf("Expired carts have to be deleted: %v %+v", deleted, err)
+	}
+
+	// teardown
+	err = func() error {
+		if bill != nil {
+			if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+				return err
+			}
+		}
+		for _, product := range products {
+			if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+				return err
+			}
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestCart: %+v", err))
+	}
+	// end teardown
+}
+
+func TestProductArchive(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	var (
+		product  *Product
+		customer *Customer
+		bill     *Bill
+		cart     *Cart
+	)
+	err := func() error {
+		var err error
+		product, err = e.s.AddProduct(context.TODO(), ProductDTOAdd{
+			Sku:         "ARCHIVE-TEST",
+			Name:        "Archived Product",
+			Description: "Description",
+			Price:       NewMoney(250, "USD"),
+			Quantity:    10,
+		})
+		if err != nil {
+			return err
+		}
+		customer, err = e.s.AddCustomer(context.TODO(), CustomerDTOAdd{FirstName: "Archive", LastName: "Customer"})
+		if err != nil {
+			return err
+		}
+		bill, err = e.s.AddBill(context.TODO(), BillDTOAdd{
+			Customer: customer.Id,
+			Products: []BillProduct{{Product: product.Id, Quantity: 2}},
+		})
+		if err != nil {
+			return err
+		}
+		if cart, err = e.s.AddCart(context.TODO(), CartDTOAdd{Customer: customer.Id}); err != nil {
+			return err
+		}
+		return e.s.AddCartItem(context.TODO(), CartItemDTO{Cart: cart.Id, Token: cart.Token, Product: product.Id, Quantity: 1})
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestProductArchive: %+v", err))
+	}
+	// end setup
+
+	if err := e.s.DeleteProductById(context.TODO(), product.Id); err != nil {
+		t.Errorf("Error when archiving product: %+v", err)
+	}
+
+	// Archived product is hidden from products and new bills
+	products, err := e.s.GetProducts(context.TODO())
+	if err != nil {
+		t.Errorf("Error when fetching products: %+v", err)
+	}
+	for _, p := range products {
+		if p.Id == product.Id {
+			t.Errorf("Archived product have to be hidden from products")
+		}
+	}
+	archived, err := e.s.GetArchivedProducts(context.TODO())
+	if err != nil || len(archived) == 0 || archived[0].Id != product.Id || archived[0].ArchivedAt == nil {
+		t.Errorf("Invalid archived products: %+v %+v", archived, err)
+	}
+	if _, err := e.s.AddBill(context.TODO(), BillDTOAdd{
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 1}},
+	}); err == nil {
+		t.Errorf("Archived product cannot be added to new bill")
+	}
+	if err := e.s.AddCartItem(context.TODO(), CartItemDTO{Cart: cart.Id, Token: cart.Token, Product: product.Id, Quantity: 1}); err == nil {
+		t.Errorf("Archived product cannot be added to cart")
+	}
+	if preview, err := e.s.GetCartById(context.TODO(), cart.Id, cart.Token); err != nil || len(preview.Items) != 0 {
+		t.Errorf("Archived product have to be removed from cart: %+v %+v", preview, err)
+	}
+
+	// Existing bill keeps archived product
+	billVerbose, err := e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil || len(billVerbose.Products) != 1 || billVerbose.Products[0].Product != product.Id ||
+		billVerbose.Products[0].Total.Amount != 500 {
+		t.Errorf("Bill have to keep archived product: %+v %+v", billVerbose, err)
+	}
+
+	// Bill keeps archived product when it's updated, but archived product cannot be changed
+	if err := e.s.UpdateBillById(context.TODO(), BillDTOUpdate{
+		Id:       bill.Id,
+		Customer: customer.Id,
+		Products: []BillProduct{{Product: product.Id, Quantity: 2}},
+		Discount: &Discount{Type: DiscountFixed, Value: 100},
+	}); err != nil {
+		t.Errorf("Error when updating bill with archived product: %+v", err)
+	}
+	billVerbose, err = e.s.GetBillById(context.TODO(), bill.Id)
+	if err != nil || len(billVerbose.Products) != 1 || billVerbose.Products[0].Price != NewMoney(250, "USD") ||
+		billVerbose.Products[0].Quantity != 2 || billVerbose.Totals.BillDiscount.Amount != 100 {
+		t.Errorf("Updated bill have to keep archived product: %+v %+v", billVerbose, err)
+	}
+	if err := e.s.UpdateProductById(context.TODO(), ProductDTOUpdate{
+		Id:          product.Id,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       NewMoney(300, "USD"),
+		Quantity:    10,
+	}); err == nil {
+		t.Errorf("Archived product cannot be updated")
+	}
+
+	// Sku of archived product may be reused, then product cannot be restored until sku is free
+	other, err := e.s.AddProduct(context.TODO(), ProductDTOAdd{
+		Sku:         product.Sku,
+		Name:        "Product Reusing Sku",
+		Description: "Description",
+		Price:       NewMoney(100, "USD"),
+		Quantity:    1,
+	})
+	if err != nil {
+		t.Errorf("Error when adding product with sku of archived one: %+v", err)
+	}
+	if _, err := e.s.RestoreProductById(context.TODO(), product.Id); err == nil {
+		t.Errorf("Product cannot be restored while its sku is taken")
+	}
+	if err := e.s.DeleteProductById(context.TODO(), other.Id); err != nil {
+		t.Errorf("Error when archiving product: %+v", err)
+	}
+
+	restored, err := e.s.RestoreProductById(context.TODO(), product.Id)
+	if err != nil || restored.ArchivedAt != nil || restored.Name != product.Name {
+		t.Errorf("Invalid restored product: %+v %+v", restored, err)
+	}
+	if _, err := e.s.RestoreProductById(context.TODO(), product.Id); err == nil {
+		t.Errorf("Not archived product cannot be restored")
+	}
+	if _, err := e.s.GetProductById(context.TODO(), product.Id); err != nil {
+		t.Errorf("Restored product have to be visible: %+v", err)
+	}
+
+	// teardown
+	err = func() error {
+		if err := e.s.DeleteCartById(context.TODO(), cart.Id, cart.Token); err != nil {
+			return err
+		}
+		if err := e.s.DeleteBillById(context.TODO(), bill.Id); err != nil {
+			return err
+		}
+		// products are archived only, skus are freed for next runs
+		if _, err := e.s.db.ExecContext(context.TODO(), "DELETE FROM product WHERE id IN ($1, $2)", product.Id, other.Id); err != nil {
+			return err
+		}
+		return e.s.DeleteCustomerById(context.TODO(), customer.Id)
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestProductArchive: %+v", err))
+	}
+	// end teardown
+}
+
+func TestAudit(t *testing.T) {
+	e := GetEnvironment()
+
+	// setup
+	ctx := WithAuditActor(context.TODO(), "auditor", "audit-test-create")
+	product, err := e.s.AddProduct(ctx, ProductDTOAdd{
+		Sku:         "AUDIT-TEST",
+		Name:        "Audited Product",
+		Description: "Description",
+		Price:       NewMoney(250, "USD"),
+		Quantity:    10,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Cannot setup TestAudit: %+v", err))
+	}
+	// end setup
+
+	ctx = WithAuditActor(context.TODO(), "auditor", "audit-test-update")
+	if err := e.s.UpdateProductById(ctx, ProductDTOUpdate{
+		Id:          product.Id,
+		Sku:         product.Sku,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       NewMoney(300, "USD"),
+		Quantity:    product.Quantity,
+	}); err != nil {
+		t.Errorf("Error when updating product: %+v", err)
+	}
+	// Unchanged product isn't recorded
+	if err := e.s.UpdateProductById(ctx, ProductDTOUpdate{
+		Id:          product.Id,
+		Sku:         product.Sku,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       NewMoney(300, "USD"),
+		Quantity:    product.Quantity,
+	}); err != nil {
+		t.Errorf("Error when updating product: %+v", err)
+	}
+
+	entries, err := e.s.GetAuditEntries(context.TODO(), AuditFilter{Entity: AuditProduct, EntityId: product.Id, Limit: 10})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Invalid audit entries of product: %+v %+v", entries, err)
+	} else {
+		update, create := entries[0], entries[1]
+		if update.Actor != "auditor" || update.RequestId != "audit-test-update" || update.Action != AuditUpdate {
+			t.Errorf("Invalid audit entry of update: %+v", update)
+		}
+		var before, after map[string]any
+		json.Unmarshal(update.Before, &before)
+		json.Unmarshal(update.After, &after)
+		if !cmp.Equal(before, map[string]any{"price": 250.0}) || !cmp.Equal(after, map[string]any{"price": 300.0}) {
+			t.Errorf("Invalid diff of update: %s -> %s", update.Before, update.After)
+		}
+		if create.RequestId != "audit-test-create" || create.Action != AuditCreate || string(create.Before) != "null" {
+			t.Errorf("Invalid audit entry of create: %+v", create)
+		}
+	}
+
+	entries, err = e.s.GetAuditEntries(context.TODO(), AuditFilter{RequestId: "audit-test-update", Action: AuditCreate, Limit: 10})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Audit entries have to be filtered by action: %+v %+v", entries, err)
+	}
+	if _, err := e.s.GetAuditEntries(context.TODO(), AuditFilter{Entity: "unknown", Limit: 10}); err == nil {
+		t.Errorf("Unknown audited entity have to be rejected")
+	}
+
+	// Archiving is recorded as update of archived_at
+	if err := e.s.DeleteProductById(ctx, product.Id); err != nil {
+		t.Errorf("Error when archiving product: %+v", err)
+	}
+	entries, err = e.s.GetAuditEntries(context.TODO(), AuditFilter{Entity: AuditProduct, EntityId: product.Id, Limit: 1})
+	if err != nil || len(entries) != 1 || entries[0].Action != AuditUpdate || !strings.Contains(string(entries[0].After), "archived_at") {
+		t.Errorf("Invalid audit entry of archiving: %+v %+v", entries, err)
+	}
+
+	// teardown
+	err = func() error {
+		if _, err := e.s.db.ExecContext(context.TODO(), "DELETE FROM product WHERE id = $1", product.Id); err != nil {
+			return err
+		}
+		_, err := e.s.db.ExecContext(context.TODO(), "DELETE FROM audit WHERE entity = $1 AND entity_id = $2", AuditProduct, product.Id)
+		return err
+	}()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot teardown TestAudit: %+v", err))
+	}
+	// end teardown
+}
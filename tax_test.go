@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with actual results")
+
+type taxCase struct {
+	Name           string     `json:"name"`
+	Mode           string     `json:"mode"`
+	Discount       *Discount  `json:"discount"`
+	Coupon         *Coupon    `json:"coupon"`
+	CouponDiscount int64      `json:"coupon_discount"`
+	Lines          []BillLine `json:"lines"`
+}
+
+type taxResult struct {
+	Name   string     `json:"name"`
+	Totals BillTotals `json:"totals"`
+}
+
+// TestTaxRounding checks rounding edge cases against golden file. Run with -update to regenerate it
+func TestTaxRounding(t *testing.T) {
+	buf, err := os.ReadFile(filepath.Join("testdata", "tax_rounding.json"))
+	if err != nil {
+		t.Fatalf("Cannot read tax cases: %+v", err)
+	}
+
+	var cases []taxCase
+	if err := json.Unmarshal(buf, &cases); err != nil {
+		t.Fatalf("Cannot parse tax cases: %+v", err)
+	}
+
+	results := make([]taxResult, 0, len(cases))
+	for _, c := range cases {
+		totals := calculateBillTotals(c.Lines, c.Discount, c.CouponDiscount, "USD")
+		totals = applyTaxes(totals, c.Lines, c.Coupon, c.Mode)
+		results = append(results, taxResult{Name: c.Name, Totals: totals})
+
+		var base int64
+		for _, tax := range totals.Taxes {
+			base += tax.Base.Amount + tax.Tax.Amount
+		}
+		if base != totals.Total.Amount {
+			t.Errorf("%v: taxes don't add up to total %v: %+v", c.Name, totals.Total, totals.Taxes)
+		}
+	}
+
+	actual, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		t.Fatalf("Cannot marshal results: %+v", err)
+	}
+	actual = append(actual, '\n')
+
+	golden := filepath.Join("testdata", "tax_rounding.golden")
+	if *update {
+		if err := os.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatalf("Cannot update golden file: %+v", err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Cannot read golden file: %+v", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Tax totals differ from %v:\n%s", golden, actual)
+	}
+}
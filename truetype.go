@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// trueTypeFont is parsed TrueType font, just enough to lay text out and embed font's subset into PDF
+type trueTypeFont struct {
+	name   string
+	tables map[string][]byte
+
+	unitsPerEm int
+	bbox       [4]int
+	ascent     int
+	descent    int
+	advances   []int
+	glyphs     map[rune]uint16
+	loca       []int
+}
+
+var errInvalidTrueType = errors.New("invalid TrueType font")
+
+func parseTrueType(name string, data []byte) (*trueTypeFont, error) {
+	if len(data) < 12 || binary.BigEndian.Uint32(data) != 0x00010000 {
+		return nil, errInvalidTrueType
+	}
+
+	f := &trueTypeFont{name: name, tables: map[string][]byte{}}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		entry := 12 + i*16
+		if entry+16 > len(data) {
+			return nil, errInvalidTrueType
+		}
+		offset, length := binary.BigEndian.Uint32(data[entry+8:]), binary.BigEndian.Uint32(data[entry+12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, errInvalidTrueType
+		}
+		f.tables[string(data[entry:entry+4])] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap", "loca", "glyf"} {
+		if _, ok := f.tables[tag]; !ok {
+			return nil, fmt.Errorf("TrueType font %v has no %v table", name, tag)
+		}
+	}
+
+	head, hhea, maxp := f.tables["head"], f.tables["hhea"], f.tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errInvalidTrueType
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+i*2:])))
+	}
+	f.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	if f.unitsPerEm == 0 {
+		return nil, errInvalidTrueType
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := f.tables["hmtx"]
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < numMetrics*4 {
+		return nil, errInvalidTrueType
+	}
+	f.advances = make([]int, numGlyphs)
+	for i := range f.advances {
+		// Glyphs after the last metric share its advance
+		f.advances[i] = int(binary.BigEndian.Uint16(hmtx[(minInt(i, numMetrics-1))*4:]))
+	}
+
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+	loca := f.tables["loca"]
+	f.loca = make([]int, numGlyphs+1)
+	for i := range f.loca {
+		switch {
+		case longLoca && len(loca) >= (i+1)*4:
+			f.loca[i] = int(binary.BigEndian.Uint32(loca[i*4:]))
+		case !longLoca && len(loca) >= (i+1)*2:
+			f.loca[i] = int(binary.BigEndian.Uint16(loca[i*2:])) * 2
+		default:
+			return nil, errInvalidTrueType
+		}
+		if f.loca[i] > len(f.tables["glyf"]) || (i > 0 && f.loca[i] < f.loca[i-1]) {
+			return nil, errInvalidTrueType
+		}
+	}
+
+	glyphs, err := parseCmap(f.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.glyphs = glyphs
+	return f, nil
+}
+
+// parseCmap reads unicode subtable of cmap table: format 12 covering all planes or format 4 covering the BMP
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errInvalidTrueType
+	}
+
+	var bmp, full []byte
+	for i := 0; i < int(binary.BigEndian.Uint16(cmap[2:])); i++ {
+		record := 4 + i*8
+		if record+8 > len(cmap) {
+			return nil, errInvalidTrueType
+		}
+		platform, encoding := binary.BigEndian.Uint16(cmap[record:]), binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if offset+4 > len(cmap) {
+			return nil, errInvalidTrueType
+		}
+		switch format := binary.BigEndian.Uint16(cmap[offset:]); {
+		case platform == 3 && encoding == 10 && format == 12:
+			full = cmap[offset:]
+		case platform == 3 && encoding == 1 && format == 4:
+			bmp = cmap[offset:]
+		}
+	}
+
+	glyphs := map[rune]uint16{}
+	switch {
+	case full != nil:
+		if len(full) < 16 {
+			return nil, errInvalidTrueType
+		}
+		groups := int(binary.BigEndian.Uint32(full[12:]))
+		if len(full) < 16+groups*12 {
+			return nil, errInvalidTrueType
+		}
+		for i := 0; i < groups; i++ {
+			group := full[16+i*12:]
+			start, end := binary.BigEndian.Uint32(group), binary.BigEndian.Uint32(group[4:])
+			glyph := binary.BigEndian.Uint32(group[8:])
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				glyphs[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	case bmp != nil:
+		if len(bmp) < 14 {
+			return nil, errInvalidTrueType
+		}
+		segments := int(binary.BigEndian.Uint16(bmp[6:])) / 2
+		ends, starts := 14, 16+segments*2
+		deltas, rangeOffsets := starts+segments*2, starts+segments*4
+		if len(bmp) < rangeOffsets+segments*2 {
+			return nil, errInvalidTrueType
+		}
+		for i := 0; i < segments; i++ {
+			end, start := int(binary.BigEndian.Uint16(bmp[ends+i*2:])), int(binary.BigEndian.Uint16(bmp[starts+i*2:]))
+			delta := binary.BigEndian.Uint16(bmp[deltas+i*2:])
+			rangeOffset := int(binary.BigEndian.Uint16(bmp[rangeOffsets+i*2:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				glyph := uint16(c) + delta
+				if rangeOffset != 0 {
+					// Offset is relative to the position of range offset itself
+					at := rangeOffsets + i*2 + rangeOffset + (c-start)*2
+					if at+2 > len(bmp) {
+						return nil, errInvalidTrueType
+					}
+					if glyph = binary.BigEndian.Uint16(bmp[at:]); glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(c)] = glyph
+				}
+			}
+		}
+	default:
+		return nil, errors.New("TrueType font has no unicode cmap")
+	}
+	return glyphs, nil
+}
+
+// glyph returns glyph of rune, missing runes are drawn by .notdef glyph
+func (f *trueTypeFont) glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+// width returns advance of glyph in thousandths of font size
+func (f *trueTypeFont) width(glyph uint16) int {
+	if int(glyph) >= len(f.advances) {
+		return 0
+	}
+	return f.advances[glyph] * 1000 / f.unitsPerEm
+}
+
+// textWidth returns width of text in points when it is drawn by passed font size
+func (f *trueTypeFont) textWidth(text string, size float64) float64 {
+	var width int
+	for _, r := range text {
+		width += f.width(f.glyph(r))
+	}
+	return float64(width) * size / 1000
+}
+
+// scale converts font units into thousandths of font size like width does
+func (f *trueTypeFont) scale(v int) int {
+	return v * 1000 / f.unitsPerEm
+}
+
+// subset returns font having outlines of passed glyphs only. Glyph ids are kept, so text drawn by the full font
+// is drawn by its subset the same way, and glyphs referenced by composite glyphs are kept too
+func (f *trueTypeFont) subset(used map[uint16]bool) []byte {
+	glyf := f.tables["glyf"]
+	keep := map[uint16]bool{0: true}
+	queue := []uint16{}
+	for glyph := range used {
+		queue = append(queue, glyph)
+	}
+	for len(queue) > 0 {
+		glyph := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if keep[glyph] || int(glyph) >= len(f.loca)-1 {
+			continue
+		}
+		keep[glyph] = true
+		queue = append(queue, compositeComponents(glyf[f.loca[glyph]:f.loca[glyph+1]])...)
+	}
+
+	var outlines []byte
+	loca := make([]byte, len(f.loca)*4)
+	for glyph := 0; glyph < len(f.loca)-1; glyph++ {
+		binary.BigEndian.PutUint32(loca[glyph*4:], uint32(len(outlines)))
+		if keep[uint16(glyph)] {
+			outlines = append(outlines, glyf[f.loca[glyph]:f.loca[glyph+1]]...)
+			for len(outlines)%4 != 0 {
+				outlines = append(outlines, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(loca[(len(f.loca)-1)*4:], uint32(len(outlines)))
+
+	// Subset always has long loca offsets, checksum adjustment is calculated for the whole font later
+	head := append([]byte{}, f.tables["head"]...)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	binary.BigEndian.PutUint32(head[8:], 0)
+
+	tables := map[string][]byte{"head": head, "loca": loca, "glyf": outlines}
+	for _, tag := range []string{"cmap", "hhea", "hmtx", "maxp", "cvt ", "fpgm", "prep"} {
+		if table, ok := f.tables[tag]; ok {
+			tables[tag] = table
+		}
+	}
+	font := writeTrueType(tables)
+	binary.BigEndian.PutUint32(font[trueTypeTableOffset(font, "head")+8:], 0xB1B0AFBA-trueTypeChecksum(font))
+	return font
+}
+
+// trueTypeTableOffset finds offset of table in font written by writeTrueType
+func trueTypeTableOffset(font []byte, tag string) int {
+	for i := 0; i < int(binary.BigEndian.Uint16(font[4:])); i++ {
+		if entry := 12 + i*16; string(font[entry:entry+4]) == tag {
+			return int(binary.BigEndian.Uint32(font[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// compositeComponents returns glyphs composite glyph is built of, simple glyph has no components
+func compositeComponents(glyph []byte) []uint16 {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return nil
+	}
+
+	const (
+		argsAreWords   = 0x0001
+		haveScale      = 0x0008
+		moreComponents = 0x0020
+		haveXYScale    = 0x0040
+		haveTwoByTwo   = 0x0080
+	)
+	var components []uint16
+	for at := 10; at+4 <= len(glyph); {
+		flags := binary.BigEndian.Uint16(glyph[at:])
+		components = append(components, binary.BigEndian.Uint16(glyph[at+2:]))
+		at += 4
+		if flags&argsAreWords != 0 {
+			at += 4
+		} else {
+			at += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			at += 2
+		case flags&haveXYScale != 0:
+			at += 4
+		case flags&haveTwoByTwo != 0:
+			at += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return components
+}
+
+// writeTrueType writes font file of passed tables ordered by tag
+func writeTrueType(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	searchRange, selector := 1, 0
+	for searchRange*2 <= len(tags) {
+		searchRange *= 2
+		selector++
+	}
+	font := make([]byte, 12+len(tags)*16)
+	binary.BigEndian.PutUint32(font, 0x00010000)
+	binary.BigEndian.PutUint16(font[4:], uint16(len(tags)))
+	binary.BigEndian.PutUint16(font[6:], uint16(searchRange*16))
+	binary.BigEndian.PutUint16(font[8:], uint16(selector))
+	binary.BigEndian.PutUint16(font[10:], uint16(len(tags)*16-searchRange*16))
+
+	for i, tag := range tags {
+		table := tables[tag]
+		entry := 12 + i*16
+		copy(font[entry:], tag)
+		binary.BigEndian.PutUint32(font[entry+4:], trueTypeChecksum(table))
+		binary.BigEndian.PutUint32(font[entry+8:], uint32(len(font)))
+		binary.BigEndian.PutUint32(font[entry+12:], uint32(len(table)))
+		font = append(font, table...)
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+	}
+	return font
+}
+
+func trueTypeChecksum(data []byte) (sum uint32) {
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,33 +1,204 @@
 package main
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// Currency-related types
+
+// ExchangeRate is price of one unit of base currency in quote currency since its effective time until the next rate
+type ExchangeRate struct {
+	Id            int       `json:"id" db:"id"`
+	Base          string    `json:"base" db:"base"`
+	Quote         string    `json:"quote" db:"quote"`
+	Rate          string    `json:"rate" db:"rate"`
+	EffectiveFrom time.Time `json:"effective_from" db:"effective_from"`
+}
+
+type ExchangeRateDTOAdd struct {
+	Base          string    `json:"base" validate:"required,len=3"`
+	Quote         string    `json:"quote" validate:"required,len=3,nefield=Base"`
+	Rate          string    `json:"rate" validate:"required"`
+	EffectiveFrom time.Time `json:"effective_from" validate:"required"`
+}
+
+func (dto *ExchangeRateDTOAdd) validate() error {
+	for _, currency := range []string{dto.Base, dto.Quote} {
+		if _, ok := currencyExponents[currency]; !ok {
+			return &ApiError{Err: fmt.Sprintf("unsupported currency %q", currency)}
+		}
+	}
+	if dto.Base == dto.Quote {
+		return &ApiError{Err: "base and quote currencies have to differ"}
+	}
+	if _, err := parseRate(dto.Rate); err != nil {
+		return &ApiError{Err: err.Error()}
+	}
+	return nil
+}
+
+type ExchangeRatesImport struct {
+	Imported int `json:"imported"`
+}
+
+// BillExchangeRate is snapshot of rate products' prices were converted into bill's currency with
+type BillExchangeRate struct {
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Rate          string    `json:"rate"`
+	EffectiveFrom time.Time `json:"effective_from"`
+}
+
 // Product-related types
 type Product struct {
 	Id          int    `json:"id" db:"id"`
+	Sku         string `json:"sku" db:"sku"`
 	Name        string `json:"name" db:"name"`
 	Description string `json:"description" db:"description"`
-	Price       int    `json:"price" db:"price"`
+	Price       Money  `json:"price" db:"price"`
 	Quantity    int    `json:"quantity" db:"quantity"`
+	Category    string `json:"category" db:"category"`
+	TaxClass    int    `json:"tax_class" db:"tax_class"`
+
+	ReorderPoint    int `json:"reorder_point" db:"reorder_point"`
+	ReorderQuantity int `json:"reorder_quantity" db:"reorder_quantity"`
+
+	ArchivedAt *time.Time `json:"archived_at" db:"archived_at"`
 }
 
 type ProductDTOAdd struct {
+	Sku         string `json:"sku" validate:"max=64" db:"sku"`
 	Name        string `json:"name" validate:"required" db:"name"`
 	Description string `json:"description" validate:"required" db:"description"`
-	Price       int    `json:"price" validate:"required,gt=0" db:"price"`
+	Price       Money  `json:"price" db:"price"`
 	Quantity    int    `json:"quantity" validate:"required,gt=0" db:"quantity"`
+	Category    string `json:"category" validate:"max=50" db:"category"`
+	TaxClass    int    `json:"tax_class" validate:"gte=0" db:"tax_class"`
+
+	ReorderPoint    int `json:"reorder_point" validate:"gte=0" db:"reorder_point"`
+	ReorderQuantity int `json:"reorder_quantity" validate:"gte=0" db:"reorder_quantity"`
 }
 
 type ProductDTOUpdate struct {
 	Id          int    `db:"id"`
+	Sku         string `json:"sku" validate:"max=64" db:"sku"`
 	Name        string `json:"name" validate:"required" db:"name"`
 	Description string `json:"description" validate:"required" db:"description"`
-	Price       int    `json:"price" validate:"required,gt=0" db:"price"`
+	Price       Money  `json:"price" db:"price"`
 	Quantity    int    `json:"quantity" validate:"required,gt=0" db:"quantity"`
+	Category    string `json:"category" validate:"max=50" db:"category"`
+	TaxClass    int    `json:"tax_class" validate:"gte=0" db:"tax_class"`
+
+	ReorderPoint    int `json:"reorder_point" validate:"gte=0" db:"reorder_point"`
+	ReorderQuantity int `json:"reorder_quantity" validate:"gte=0" db:"reorder_quantity"`
+}
+
+// Product import's formats, modes and rows' actions
+const (
+	ProductImportCSV    = "csv"
+	ProductImportNDJSON = "ndjson"
+
+	// ProductImportAllOrNothing commits import only when every row succeeds
+	ProductImportAllOrNothing = "all_or_nothing"
+	// ProductImportBestEffort commits succeeded rows skipping failed ones
+	ProductImportBestEffort = "best_effort"
+
+	ProductImportCreated = "created"
+	ProductImportUpdated = "updated"
+	ProductImportFailed  = "failed"
+)
+
+type ProductImportOptions struct {
+	Format string
+	Mode   string
+	DryRun bool
+	// Validate checks row by the same rules as ProductDTOAdd passed into handler
+	Validate func(dto ProductDTOAdd) error
+}
+
+// ProductImportRow is result of single row of import. Line is where row starts in imported file,
+// id is absent when row failed or import is dry run
+type ProductImportRow struct {
+	Line   int    `json:"line"`
+	Sku    string `json:"sku"`
+	Action string `json:"action"`
+	Id     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ProductImportSummary struct {
+	Created   int  `json:"created"`
+	Updated   int  `json:"updated"`
+	Failed    int  `json:"failed"`
+	DryRun    bool `json:"dry_run"`
+	Committed bool `json:"committed"`
+	// Error tells why import is aborted before the end of file
+	Error string `json:"error,omitempty"`
+}
+
+// ProductSearchResult is product found by words of its name or description. Rank is relevance from 0 to 1
+type ProductSearchResult struct {
+	Product
+	Rank      float64                `json:"rank" db:"rank"`
+	Highlight ProductSearchHighlight `json:"highlight" db:"-"`
+}
+
+// ProductSearchHighlight contains html-escaped snippets where matched words are wrapped in <b> tags
+type ProductSearchHighlight struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// RelatedProduct is product bought together with another one. Support is share of all bills having both products,
+// confidence is share of another product's bills having this one and lift is how many times more often they are
+// bought together than by chance
+type RelatedProduct struct {
+	Product
+	Bills      int     `json:"bills" db:"bills"`
+	Support    float64 `json:"support" db:"support"`
+	Confidence float64 `json:"confidence" db:"confidence"`
+	Lift       float64 `json:"lift" db:"lift"`
+}
+
+// ExportOptions select format and columns of exported file, all columns are exported when none are passed
+type ExportOptions struct {
+	Format  string
+	Columns []string
+}
+
+// LowStockAlert is emitted when product's quantity falls to its reorder point
+type LowStockAlert struct {
+	Product   Product   `json:"product"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Tax-related types
+type TaxClass struct {
+	Id    int       `json:"id" db:"id"`
+	Name  string    `json:"name" db:"name"`
+	Rates []TaxRate `json:"rates"`
+}
+
+type TaxClassDTOAdd struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+// TaxRate is in hundredths of percent (2000 is 20%) and applies since its effective date until the next rate
+type TaxRate struct {
+	Id            int       `json:"id" db:"id"`
+	Rate          int       `json:"rate" db:"rate"`
+	EffectiveFrom time.Time `json:"effective_from" db:"effective_from"`
+}
+
+type TaxRateDTOAdd struct {
+	TaxClass      int       `db:"tax_class_id"`
+	Rate          int       `json:"rate" validate:"gte=0,lte=10000" db:"rate"`
+	EffectiveFrom time.Time `json:"effective_from" validate:"required" db:"effective_from"`
 }
 
 // Customer-related types
@@ -35,52 +206,600 @@ type Customer struct {
 	Id        int    `json:"id" db:"id"`
 	FirstName string `json:"first_name" db:"first_name"`
 	LastName  string `json:"last_name" db:"last_name"`
+	Email     string `json:"email" db:"email"`
+	Phone     string `json:"phone" db:"phone"`
 }
 
 type CustomerDTOAdd struct {
 	FirstName string `json:"first_name" validate:"required" db:"first_name"`
 	LastName  string `json:"last_name" validate:"required" db:"last_name"`
+	Email     string `json:"email" validate:"omitempty,email" db:"email"`
+	Phone     string `json:"phone" validate:"omitempty,e164" db:"phone"`
 }
 
 type CustomerDTOUpdate struct {
 	Id        int    `db:"id"`
 	FirstName string `json:"first_name" validate:"required" db:"first_name"`
 	LastName  string `json:"last_name" validate:"required" db:"last_name"`
+	Email     string `json:"email" validate:"omitempty,email" db:"email"`
+	Phone     string `json:"phone" validate:"omitempty,e164" db:"phone"`
+}
+
+const (
+	AddressBilling  = "billing"
+	AddressShipping = "shipping"
+)
+
+type Address struct {
+	Line1      string `json:"line1" validate:"required" db:"line1"`
+	Line2      string `json:"line2" db:"line2"`
+	City       string `json:"city" validate:"required" db:"city"`
+	PostalCode string `json:"postal_code" validate:"required" db:"postal_code"`
+	Country    string `json:"country" validate:"required,len=2" db:"country"`
+}
+
+// AddressSnapshot is customer's address copied into bill. It is stored as json
+type AddressSnapshot Address
+
+func (a AddressSnapshot) Value() (driver.Value, error) {
+	buf, err := json.Marshal(a)
+	return string(buf), err
+}
+
+func (a *AddressSnapshot) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	default:
+		return fmt.Errorf("cannot scan %T into AddressSnapshot", src)
+	}
+}
+
+type CustomerAddress struct {
+	Id       int    `json:"id" db:"id"`
+	Customer int    `json:"customer" db:"customer_id"`
+	Type     string `json:"type" db:"type"`
+	Address
+}
+
+type CustomerAddressDTOAdd struct {
+	Customer int    `db:"customer_id"`
+	Type     string `json:"type" validate:"required,oneof=billing shipping" db:"type"`
+	Address
+}
+
+type CustomerAddressDTOUpdate struct {
+	Id       int    `db:"id"`
+	Customer int    `db:"customer_id"`
+	Type     string `json:"type" validate:"required,oneof=billing shipping" db:"type"`
+	Address
+}
+
+// CustomerExport contains all data stored about customer
+type CustomerExport struct {
+	Customer   Customer          `json:"customer"`
+	Addresses  []CustomerAddress `json:"addresses"`
+	Bills      []BillVerbose     `json:"bills"`
+	ExportedAt time.Time         `json:"exported_at"`
+}
+
+// CustomerBillsFilter narrows customer's purchase history. Nil dates are not restricted
+type CustomerBillsFilter struct {
+	Customer int
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
+
+// CustomerTopProduct is product bought by customer in single currency
+type CustomerTopProduct struct {
+	Product  int    `json:"product"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	Spend    Money  `json:"spend"`
+}
+
+// CustomerSummary has customer's spend for every currency of its bills
+type CustomerSummary struct {
+	Customer      int                  `json:"customer" db:"customer_id"`
+	TotalSpend    []Money              `json:"total_spend" db:"-"`
+	BillCount     int                  `json:"bill_count" db:"bill_count"`
+	FirstPurchase *time.Time           `json:"first_purchase" db:"first_purchase"`
+	LastPurchase  *time.Time           `json:"last_purchase" db:"last_purchase"`
+	TopProducts   []CustomerTopProduct `json:"top_products"`
 }
 
 // Bill-related types
+const (
+	// BillOpen bill has no payments and may be changed
+	BillOpen          = "open"
+	BillPartiallyPaid = "partially_paid"
+	BillPaid          = "paid"
+	// BillVoid bill is deleted one: it keeps its number, so numbering stays gapless, but has no products
+	BillVoid = "void"
+)
+
 type Bill struct {
 	Id        int       `json:"id" db:"id"`
-	Number    uuid.UUID `json:"number" db:"number"`
+	Number    string    `json:"number" db:"number"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	Customer  int       `json:"customer" db:"customer_id"`
+	Currency  string    `json:"currency" db:"currency"`
+	Status    string    `json:"status" db:"status"`
+	Total     Money     `json:"total" db:"total"`
+
+	BillingAddress  *AddressSnapshot `json:"billing_address" db:"billing_address"`
+	ShippingAddress *AddressSnapshot `json:"shipping_address" db:"shipping_address"`
+}
+
+// Sort orders of bills listing, descending ones are prefixed by minus
+const (
+	BillSortCreatedAt = "created_at"
+	BillSortTotal     = "total"
+	BillSortNumber    = "number"
+)
+
+// BillsFilter narrows bills listing. Zero values are not restricted, totals are in minor units of Currency,
+// which is required with them. Cursor is returned with previous page and continues listing after its last bill
+type BillsFilter struct {
+	From     *time.Time
+	To       *time.Time
+	Customer int
+	Status   string
+	Currency string
+	MinTotal *int64
+	MaxTotal *int64
+	Sort     string
+	Cursor   string
+	Limit    int
+}
+
+// BillExpand selects parts of BillVerbose loaded for listed bills. Customer is only referenced by id
+// and products and totals are omitted unless expanded
+type BillExpand struct {
+	Customer bool
+	Lines    bool
 }
 
 type BillVerbose struct {
-	Id        int           `json:"id"`
-	Number    uuid.UUID     `json:"number"`
-	CreatedAt time.Time     `json:"created_at"`
-	Customer  Customer      `json:"customer"`
-	Products  []BillProduct `json: "products"`
+	Id        int        `json:"id"`
+	Number    string     `json:"number"`
+	CreatedAt time.Time  `json:"created_at"`
+	Customer  Customer   `json:"customer"`
+	Currency  string     `json:"currency"`
+	Status    string     `json:"status"`
+	Products  []BillLine `json:"products"`
+
+	BillingAddress  *AddressSnapshot `json:"billing_address"`
+	ShippingAddress *AddressSnapshot `json:"shipping_address"`
+
+	Discount *Discount   `json:"discount"`
+	Coupon   string      `json:"coupon"`
+	Totals   *BillTotals `json:"totals"`
+
+	ExchangeRate *BillExchangeRate `json:"exchange_rate"`
+
+	Payments []Payment `json:"payments"`
+	Paid     Money     `json:"paid"`
+	Credited Money     `json:"credited"`
+	Refunded Money     `json:"refunded"`
+	Balance  Money     `json:"balance"`
 }
 
+// BillProduct is product passed into bill with optional discount of its line
 type BillProduct struct {
-	Product  int `json:"product" validate:"required" db:"product"`
-	Quantity int `json:"quantity" validate:"required,gt=0" db:"quantity"`
+	Product  int       `json:"product" validate:"required" db:"product"`
+	Quantity int       `json:"quantity" validate:"required,gt=0" db:"quantity"`
+	Discount *Discount `json:"discount" db:"discount"`
 }
 
+// BillDTOAdd optionally references customer's addresses by id. Their content is snapshotted into bill.
+// Coupon is passed by its code
 type BillDTOAdd struct {
 	Customer int           `json:"customer" validate:"required"`
 	Products []BillProduct `json:"products" validate:"required,dive"`
+
+	BillingAddress  int `json:"billing_address"`
+	ShippingAddress int `json:"shipping_address"`
+
+	Discount *Discount `json:"discount"`
+	Coupon   string    `json:"coupon"`
+
+	// Currency is one bill is paid in, products' prices are converted into it. Products' currency by default
+	Currency string `json:"currency" validate:"omitempty,len=3"`
 }
 
 type BillDTOUpdate struct {
 	Id       int           `db:"id"`
 	Customer int           `json:"customer" validate:"required"`
 	Products []BillProduct `json:"products" validate:"required,dive"`
+
+	BillingAddress  int `json:"billing_address"`
+	ShippingAddress int `json:"shipping_address"`
+
+	Discount *Discount `json:"discount"`
+	Coupon   string    `json:"coupon"`
+
+	// Currency is one bill is paid in, products' prices are converted into it. Products' currency by default
+	Currency string `json:"currency" validate:"omitempty,len=3"`
 }
 
 type BillDtoAddProduct struct {
 	BillProduct
 	Id int `db:"id"`
 }
+
+// BillDTOUpdateProduct changes quantity of bill's line, either sets passed quantity or adds delta to it
+type BillDTOUpdateProduct struct {
+	Bill     int  `db:"bill_id"`
+	Product  int  `db:"product_id"`
+	Quantity *int `json:"quantity" validate:"required_without=Delta,omitempty,gt=0"`
+	Delta    *int `json:"delta" validate:"required_without=Quantity,omitempty,ne=0"`
+}
+
+// Cart-related types
+
+// Cart collects products before they are checked out into bill. Anonymous cart has session instead of customer,
+// its customer is 0. Cart expires when it isn't changed for configured time. Token is secret required by every
+// access to cart, it's returned only when cart is created
+type Cart struct {
+	Id        int       `json:"id" db:"id"`
+	Customer  int       `json:"customer" db:"customer_id"`
+	Session   string    `json:"session" db:"session"`
+	Token     string    `json:"token,omitempty" db:"token"`
+	Coupon    string    `json:"coupon" db:"coupon"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// CartDTOAdd ties cart to customer or to session of anonymous visitor. Coupon is passed by its code
+type CartDTOAdd struct {
+	Customer int    `json:"customer" validate:"required_without=Session"`
+	Session  string `json:"session" validate:"required_without=Customer,max=100"`
+	Coupon   string `json:"coupon" validate:"max=50"`
+}
+
+type CartDTOUpdate struct {
+	Id    int
+	Token string
+	CartDTOAdd
+}
+
+// CartItemDTO adds quantity of product to cart or sets it
+type CartItemDTO struct {
+	Cart     int
+	Token    string
+	Product  int `json:"product" validate:"required"`
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+// CartItem is cart's product priced like bill's line would be on checkout. Stock is product's quantity in stock
+type CartItem struct {
+	BillLine
+	Stock int `json:"stock" db:"stock"`
+}
+
+// CartPreview is cart with items and totals bill would have if cart were checked out now. Totals are absent
+// when products are priced in different currencies. Problems tell what prevents checkout or coupon's discount
+type CartPreview struct {
+	Cart
+	Items    []CartItem  `json:"items"`
+	Totals   *BillTotals `json:"totals"`
+	Problems []string    `json:"problems"`
+}
+
+// CartCheckoutDTO describes bill created from cart. Customer is required when cart is anonymous
+type CartCheckoutDTO struct {
+	Cart     int
+	Token    string
+	Customer int `json:"customer"`
+
+	BillingAddress  int `json:"billing_address"`
+	ShippingAddress int `json:"shipping_address"`
+
+	// Currency is one bill is paid in, products' prices are converted into it. Products' currency by default
+	Currency string `json:"currency" validate:"omitempty,len=3"`
+}
+
+// Payment-related types
+const (
+	PaymentCash     = "cash"
+	PaymentCard     = "card"
+	PaymentTransfer = "transfer"
+)
+
+type Payment struct {
+	Id        int       `json:"id" db:"id"`
+	Bill      int       `json:"bill" db:"bill_id"`
+	Amount    Money     `json:"amount" db:"amount"`
+	Method    string    `json:"method" db:"method"`
+	PaidAt    time.Time `json:"paid_at" db:"paid_at"`
+	Reference string    `json:"reference" db:"reference"`
+}
+
+// PaymentDTOAdd describes payment in bill's currency. Omitted time means payment is made now
+type PaymentDTOAdd struct {
+	Bill      int        `db:"bill_id"`
+	Amount    Money      `json:"amount"`
+	Method    string     `json:"method" validate:"required,oneof=cash card transfer"`
+	PaidAt    *time.Time `json:"paid_at"`
+	Reference string     `json:"reference" validate:"max=100"`
+}
+
+// Settlement sums money moved on bill after it was issued, in minor units of bill's currency
+type Settlement struct {
+	Paid     int64 `db:"paid"`
+	Credited int64 `db:"credited"`
+	Refunded int64 `db:"refunded"`
+}
+
+// balance is amount customer still owes. Returned items reduce it, so it becomes negative until refunded
+func (s Settlement) balance(total int64) int64 {
+	return total - s.Credited - s.Paid + s.Refunded
+}
+
+// Credit note-related types
+type CreditNote struct {
+	Id        int              `json:"id" db:"id"`
+	Bill      int              `json:"bill" db:"bill_id"`
+	CreatedAt time.Time        `json:"created_at" db:"created_at"`
+	Reason    string           `json:"reason" db:"reason"`
+	Restock   bool             `json:"restock" db:"restock"`
+	Lines     []CreditNoteLine `json:"lines" db:"-"`
+	Amount    Money            `json:"amount" db:"-"`
+	Refunds   []Refund         `json:"refunds" db:"-"`
+	Refunded  Money            `json:"refunded" db:"-"`
+}
+
+// CreditNoteLine is returned quantity of bill's product and part of its paid amount credited to customer
+type CreditNoteLine struct {
+	Product  int   `json:"product" db:"product_id"`
+	Quantity int   `json:"quantity" db:"quantity"`
+	Amount   Money `json:"amount" db:"amount"`
+}
+
+type CreditNoteLineDTO struct {
+	Product  int `json:"product" validate:"required"`
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+// CreditNoteDTOAdd describes return of bill's products. Restocked products go back into stock
+type CreditNoteDTOAdd struct {
+	Bill    int                 `db:"bill_id"`
+	Lines   []CreditNoteLineDTO `json:"lines" validate:"required,min=1,dive"`
+	Reason  string              `json:"reason" validate:"max=500"`
+	Restock bool                `json:"restock"`
+}
+
+type Refund struct {
+	Id         int       `json:"id" db:"id"`
+	CreditNote int       `json:"credit_note" db:"credit_note_id"`
+	Amount     Money     `json:"amount" db:"amount"`
+	Method     string    `json:"method" db:"method"`
+	RefundedAt time.Time `json:"refunded_at" db:"refunded_at"`
+	Reference  string    `json:"reference" db:"reference"`
+}
+
+// RefundDTOAdd describes refund in bill's currency. Omitted time means refund is made now
+type RefundDTOAdd struct {
+	CreditNote int        `db:"credit_note_id"`
+	Amount     Money      `json:"amount"`
+	Method     string     `json:"method" validate:"required,oneof=cash card transfer"`
+	RefundedAt *time.Time `json:"refunded_at"`
+	Reference  string     `json:"reference" validate:"max=100"`
+}
+
+// Supplier-related types
+type Supplier struct {
+	Id    int    `json:"id" db:"id"`
+	Name  string `json:"name" db:"name"`
+	Email string `json:"email" db:"email"`
+	Phone string `json:"phone" db:"phone"`
+}
+
+type SupplierDTOAdd struct {
+	Name  string `json:"name" validate:"required" db:"name"`
+	Email string `json:"email" validate:"omitempty,email" db:"email"`
+	Phone string `json:"phone" db:"phone"`
+}
+
+type SupplierDTOUpdate struct {
+	Id    int    `db:"id"`
+	Name  string `json:"name" validate:"required" db:"name"`
+	Email string `json:"email" validate:"omitempty,email" db:"email"`
+	Phone string `json:"phone" db:"phone"`
+}
+
+// SupplierProduct is an entry of supplier's price list
+type SupplierProduct struct {
+	Product int   `json:"product" validate:"required" db:"product_id"`
+	Price   Money `json:"price" db:"price"`
+}
+
+type SupplierDTOSetProduct struct {
+	SupplierProduct
+	Id int `db:"id"`
+}
+
+// Purchase order-related types
+const (
+	PurchaseOrderDraft             = "draft"
+	PurchaseOrderOrdered           = "ordered"
+	PurchaseOrderPartiallyReceived = "partially_received"
+	PurchaseOrderReceived          = "received"
+	PurchaseOrderCancelled         = "cancelled"
+)
+
+type PurchaseOrder struct {
+	Id        int                 `json:"id" db:"id"`
+	Supplier  int                 `json:"supplier" db:"supplier_id"`
+	Status    string              `json:"status" db:"status"`
+	CreatedAt time.Time           `json:"created_at" db:"created_at"`
+	Lines     []PurchaseOrderLine `json:"lines"`
+}
+
+type PurchaseOrderLine struct {
+	Product          int   `json:"product" db:"product_id"`
+	Quantity         int   `json:"quantity" db:"quantity"`
+	ReceivedQuantity int   `json:"received_quantity" db:"received_quantity"`
+	Price            Money `json:"price" db:"price"`
+}
+
+// PurchaseOrderLineDTO describes ordered product. Zero price is prefilled from supplier's price list.
+// All lines of purchase order have to be priced in the same currency
+type PurchaseOrderLineDTO struct {
+	Product  int   `json:"product" validate:"required"`
+	Quantity int   `json:"quantity" validate:"required,gt=0"`
+	Price    Money `json:"price" db:"price"`
+}
+
+type PurchaseOrderDTOAdd struct {
+	Supplier int                    `json:"supplier" validate:"required"`
+	Lines    []PurchaseOrderLineDTO `json:"lines" validate:"required,dive"`
+}
+
+type PurchaseOrderDTOUpdate struct {
+	Id       int                    `db:"id"`
+	Supplier int                    `json:"supplier" validate:"required"`
+	Lines    []PurchaseOrderLineDTO `json:"lines" validate:"required,dive"`
+}
+
+type PurchaseOrderLineReceive struct {
+	Product  int `json:"product" validate:"required"`
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+type PurchaseOrderDTOReceive struct {
+	Id    int                        `db:"id"`
+	Lines []PurchaseOrderLineReceive `json:"lines" validate:"required,dive"`
+}
+
+// Coupon-related types
+type Coupon struct {
+	Id                    int            `json:"id" db:"id"`
+	Code                  string         `json:"code" db:"code"`
+	Discount              Discount       `json:"discount" db:"discount"`
+	ValidFrom             *time.Time     `json:"valid_from" db:"valid_from"`
+	ValidTo               *time.Time     `json:"valid_to" db:"valid_to"`
+	UsageLimit            int            `json:"usage_limit" db:"usage_limit"`
+	UsageLimitPerCustomer int            `json:"usage_limit_per_customer" db:"usage_limit_per_customer"`
+	Currency              string         `json:"currency" db:"currency"`
+	MinBillTotal          int64          `json:"min_bill_total" db:"min_bill_total"`
+	Products              pq.Int64Array  `json:"products" db:"products"`
+	Categories            pq.StringArray `json:"categories" db:"categories"`
+	Used                  int            `json:"used" db:"used"`
+}
+
+// appliesTo reports whether coupon discounts passed line. Coupon without restrictions applies to any line
+func (c *Coupon) appliesTo(line BillLine) bool {
+	if len(c.Products) == 0 && len(c.Categories) == 0 {
+		return true
+	}
+	for _, product := range c.Products {
+		if int(product) == line.Product {
+			return true
+		}
+	}
+	for _, category := range c.Categories {
+		if category == line.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// CouponDTOAdd describes coupon. Zero usage limits mean unlimited usage, nil dates aren't restricted.
+// Coupon with fixed discount or minimal bill total applies only to bills in its currency
+type CouponDTOAdd struct {
+	Code                  string     `json:"code" validate:"required,max=50"`
+	Discount              Discount   `json:"discount"`
+	ValidFrom             *time.Time `json:"valid_from"`
+	ValidTo               *time.Time `json:"valid_to"`
+	UsageLimit            int        `json:"usage_limit" validate:"gte=0"`
+	UsageLimitPerCustomer int        `json:"usage_limit_per_customer" validate:"gte=0"`
+	Currency              string     `json:"currency" validate:"omitempty,len=3"`
+	MinBillTotal          int64      `json:"min_bill_total" validate:"gte=0"`
+	Products              []int64    `json:"products"`
+	Categories            []string   `json:"categories"`
+}
+
+type CouponDTOUpdate struct {
+	Id int
+	CouponDTOAdd
+}
+
+// Report-related types
+const (
+	ReportDay   = "day"
+	ReportWeek  = "week"
+	ReportMonth = "month"
+)
+
+// ReportFilter narrows reports to bills created from From inclusive to To exclusive, nil dates aren't restricted.
+// Limit is only used by top reports
+type ReportFilter struct {
+	From  *time.Time
+	To    *time.Time
+	Limit int
+}
+
+// SalesReportRow sums bills of period in one currency. Period is the first day of day, week or month,
+// weeks start on Monday
+type SalesReportRow struct {
+	Period   string `json:"period" db:"period"`
+	Bills    int    `json:"bills" db:"bills"`
+	Quantity int    `json:"quantity" db:"quantity"`
+	Revenue  Money  `json:"revenue" db:"revenue"`
+}
+
+// TopProductReportRow sums product's lines in bills of one currency, revenue is before bill's discounts and taxes
+type TopProductReportRow struct {
+	Product  int    `json:"product" db:"product_id"`
+	Sku      string `json:"sku" db:"sku"`
+	Name     string `json:"name" db:"name"`
+	Bills    int    `json:"bills" db:"bills"`
+	Quantity int    `json:"quantity" db:"quantity"`
+	Revenue  Money  `json:"revenue" db:"revenue"`
+}
+
+// TopCustomerReportRow sums customer's bills of one currency
+type TopCustomerReportRow struct {
+	Customer  int    `json:"customer" db:"customer_id"`
+	FirstName string `json:"first_name" db:"first_name"`
+	LastName  string `json:"last_name" db:"last_name"`
+	Email     string `json:"email" db:"email"`
+	Bills     int    `json:"bills" db:"bills"`
+	Revenue   Money  `json:"revenue" db:"revenue"`
+}
+
+// Audit-related types
+
+// AuditEntry is change of entity. Before and after keep only changed fields, before is null for created entity
+// and after is null for deleted one
+type AuditEntry struct {
+	Id        int64           `json:"id" db:"id"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	Actor     string          `json:"actor" db:"actor"`
+	RequestId string          `json:"request_id" db:"request_id"`
+	Entity    string          `json:"entity" db:"entity"`
+	EntityId  int             `json:"entity_id" db:"entity_id"`
+	Action    string          `json:"action" db:"action"`
+	Before    json.RawMessage `json:"before" db:"before"`
+	After     json.RawMessage `json:"after" db:"after"`
+}
+
+// AuditFilter narrows audit entries. Zero values are not restricted
+type AuditFilter struct {
+	Entity    string
+	EntityId  int
+	Actor     string
+	Action    string
+	RequestId string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
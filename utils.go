@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 func DoWithTries(fn func() error, attempts int, delay time.Duration) (err error) {
 	for attempts > 0 {
@@ -16,3 +19,8 @@ func DoWithTries(fn func() error, attempts int, delay time.Duration) (err error)
 
 	return
 }
+
+// escapeLike escapes wildcard characters of LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}